@@ -0,0 +1,212 @@
+package courier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nyaruka/courier/utils"
+)
+
+// ChannelQueue describes the state of the queue of outgoing messages for a channel
+type ChannelQueue struct {
+	ChannelUUID ChannelUUID `json:"channel_uuid"`
+	TPS         int         `json:"tps"`
+	State       string      `json:"state"`
+	Workers     int         `json:"workers"`
+	Size        int         `json:"size"`
+	BulkSize    int         `json:"bulk_size"`
+	CurrentTPS  int         `json:"current_tps"`
+	Paused      bool        `json:"paused"`
+	BulkPaused  bool        `json:"bulk_paused"`
+	Limited     bool        `json:"limited"`
+}
+
+// DeadLetter is an outgoing message which was popped from the queue but couldn't be sent
+type DeadLetter struct {
+	ID          string      `json:"id"`
+	ChannelUUID ChannelUUID `json:"channel_uuid"`
+	Msg         string      `json:"msg"`
+	Error       string      `json:"error"`
+	FailedOn    time.Time   `json:"failed_on"`
+}
+
+type pauseQueueRequest struct {
+	Duration int  `json:"duration" validate:"required,min=1"` // in seconds
+	BulkOnly bool `json:"bulk_only"`
+}
+
+// adds our admin endpoints which are only enabled if we have an auth token to protect them
+func (s *server) addAdminRoutes() {
+	if s.config.AuthToken == "" {
+		return
+	}
+
+	s.router.Route("/admin", func(r chi.Router) {
+		r.Get("/queues", s.tokenAuthRequired(s.handleListQueues))
+		r.Post("/queues/{uuid}/pause", s.tokenAuthRequired(s.handlePauseQueue))
+		r.Post("/queues/{uuid}/resume", s.tokenAuthRequired(s.handleResumeQueue))
+		r.Post("/queues/{uuid}/purge", s.tokenAuthRequired(s.handlePurgeQueue))
+		r.Post("/queues/{uuid}/update", s.tokenAuthRequired(s.handleUpdateQueue))
+		r.Get("/deadletters", s.tokenAuthRequired(s.handleListDeadLetters))
+		r.Post("/deadletters/{id}/replay", s.tokenAuthRequired(s.handleReplayDeadLetter))
+		r.Post("/deadletters/{id}/discard", s.tokenAuthRequired(s.handleDiscardDeadLetter))
+		r.Get("/msgs/{uuid}/timeline", s.tokenAuthRequired(s.handleMsgStatusTimeline))
+	})
+}
+
+func (s *server) handleListQueues(w http.ResponseWriter, r *http.Request) {
+	queues, err := s.backend.ChannelQueues(r.Context())
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]any{"queues": queues})
+}
+
+func (s *server) handlePauseQueue(w http.ResponseWriter, r *http.Request) {
+	ch, err := s.adminChannel(r)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("error reading request body: %w", err))
+		return
+	}
+
+	req := &pauseQueueRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("error unmarshalling request: %w", err))
+		return
+	}
+	if err := utils.Validate(req); err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.backend.PauseChannel(r.Context(), ch, time.Duration(req.Duration)*time.Second, req.BulkOnly); err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]any{"channel_uuid": ch.UUID(), "paused": req.Duration, "bulk_only": req.BulkOnly})
+}
+
+func (s *server) handleResumeQueue(w http.ResponseWriter, r *http.Request) {
+	ch, err := s.adminChannel(r)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if err := s.backend.ResumeChannel(r.Context(), ch); err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]any{"channel_uuid": ch.UUID(), "resumed": true})
+}
+
+func (s *server) handlePurgeQueue(w http.ResponseWriter, r *http.Request) {
+	ch, err := s.adminChannel(r)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	purged, err := s.backend.PurgeChannel(r.Context(), ch)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]any{"channel_uuid": ch.UUID(), "purged": purged})
+}
+
+func (s *server) handleUpdateQueue(w http.ResponseWriter, r *http.Request) {
+	uuid := ChannelUUID(chi.URLParam(r, "uuid"))
+
+	ch, err := s.backend.UpdateChannelQueue(r.Context(), uuid)
+	if errors.Is(err, ErrChannelNotFound) {
+		WriteError(w, http.StatusNotFound, fmt.Errorf("unable to find channel %s: %w", uuid, err))
+		return
+	} else if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]any{"channel_uuid": ch.UUID(), "updated": true})
+}
+
+func (s *server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	dls, err := s.backend.DeadLetters(r.Context())
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]any{"dead_letters": dls})
+}
+
+func (s *server) handleReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	replayed, err := s.backend.ReplayDeadLetter(r.Context(), id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !replayed {
+		WriteError(w, http.StatusNotFound, fmt.Errorf("unable to find dead letter %s", id))
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]any{"id": id, "replayed": true})
+}
+
+func (s *server) handleDiscardDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	discarded, err := s.backend.DiscardDeadLetter(r.Context(), id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !discarded {
+		WriteError(w, http.StatusNotFound, fmt.Errorf("unable to find dead letter %s", id))
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]any{"id": id, "discarded": true})
+}
+
+func (s *server) handleMsgStatusTimeline(w http.ResponseWriter, r *http.Request) {
+	uuid := MsgUUID(chi.URLParam(r, "uuid"))
+
+	timeline, err := s.backend.MsgStatusTimeline(r.Context(), uuid)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]any{"msg_uuid": uuid, "timeline": timeline})
+}
+
+// looks up the channel whose UUID is in the path of the passed in admin request
+func (s *server) adminChannel(r *http.Request) (Channel, error) {
+	uuid := ChannelUUID(chi.URLParam(r, "uuid"))
+
+	ch, err := s.backend.GetChannel(r.Context(), AnyChannelType, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find channel %s: %w", uuid, err)
+	}
+	return ch, nil
+}
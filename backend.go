@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/gocommon/httpx"
@@ -79,6 +80,50 @@ type Backend interface {
 	// a message is being forced in being resent by a user
 	ClearMsgSent(context.Context, MsgID) error
 
+	// WasMsgDuplicate returns whether a message with the same text and attachments as the passed in message was sent to
+	// the same URN within the dedup window of its channel. This can be used to avoid repeating ourselves to a contact.
+	WasMsgDuplicate(context.Context, MsgOut) (bool, error)
+
+	// RequeueMsg puts the passed in message back on the queue so that it is retried after the given delay. Callers
+	// should still call OnSendComplete for the current attempt
+	RequeueMsg(context.Context, MsgOut, time.Duration) error
+
+	// ReturnMsg puts the passed in message back on the queue unchanged because it was popped but never sent, e.g.
+	// because we are stopping. Callers should not call OnSendComplete for the message
+	ReturnMsg(context.Context, MsgOut) error
+
+	// PauseChannel pauses sending of messages on the passed in channel for the given duration, or only sending of bulk
+	// messages if bulkOnly is set. This is used when the channel tells us we are being rate limited.
+	PauseChannel(context.Context, Channel, time.Duration, bool) error
+
+	// ResumeChannel removes any pause on sending of messages on the passed in channel
+	ResumeChannel(context.Context, Channel) error
+
+	// UpdateChannelQueue reloads the channel with the passed in UUID, e.g. after its config has been changed, and applies
+	// its TPS and rate limits to its queue so that they apply to messages already queued
+	UpdateChannelQueue(context.Context, ChannelUUID) (Channel, error)
+
+	// PurgeChannel removes all queued messages for the passed in channel without sending them, returning how many
+	// queue items were removed
+	PurgeChannel(context.Context, Channel) (int, error)
+
+	// ChannelQueues returns the state of the outgoing message queues of all channels which have any
+	ChannelQueues(context.Context) ([]*ChannelQueue, error)
+
+	// DeadLetters returns the outgoing messages which were popped from the queue but couldn't be sent because they
+	// couldn't be read or their channel couldn't be loaded
+	DeadLetters(context.Context) ([]*DeadLetter, error)
+
+	// ReplayDeadLetter puts the dead letter with the given ID back on the queue, returning false if it doesn't exist
+	ReplayDeadLetter(context.Context, string) (bool, error)
+
+	// DiscardDeadLetter removes the dead letter with the given ID, returning false if it doesn't exist
+	DiscardDeadLetter(context.Context, string) (bool, error)
+
+	// MsgStatusTimeline returns the status updates which were applied to the outgoing message with the given UUID,
+	// oldest first
+	MsgStatusTimeline(context.Context, MsgUUID) ([]*StatusTimelineEntry, error)
+
 	// OnSendComplete is called when the sender has finished trying to send a message
 	OnSendComplete(context.Context, MsgOut, StatusUpdate, *ChannelLog)
 
@@ -31,6 +31,7 @@ import (
 	"github.com/nyaruka/gocommon/cache"
 	"github.com/nyaruka/gocommon/dbutil"
 	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/syncx"
 	"github.com/nyaruka/gocommon/urns"
@@ -44,6 +45,12 @@ const msgQueueName = "msgs"
 // our timeout for backend operations
 const backendTimeout = time.Second * 20
 
+// the max number of msgs we'll skip over in a single pop because they can't be sent yet
+const maxPopSkips = 100
+
+// how long a msg which couldn't be read because of a temporary error is put back on the queue for
+const msgRetryDelay = time.Second * 10
+
 var uuidRegex = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
 
 func init() {
@@ -59,6 +66,7 @@ type backend struct {
 
 	db           *sqlx.DB
 	rp           *redis.Pool
+	msgQueue     queue.Queue
 	dynamo       *dynamo.Table[DynamoKey, DynamoItem]
 	s3           *s3x.Service
 	cw           *cwatch.Service
@@ -66,6 +74,11 @@ type backend struct {
 
 	channelsByUUID *cache.Local[courier.ChannelUUID, *Channel]
 	channelsByAddr *cache.Local[courier.ChannelAddress, *Channel]
+	channelTypes   *cache.Local[courier.ChannelUUID, courier.ChannelType] // for reporting by type, which doesn't change
+
+	// the TPS and rate limits we last applied to the queue of each channel, so we only apply them again when they change
+	queueConfigs      map[courier.ChannelUUID]string
+	queueConfigsMutex sync.Mutex
 
 	stopChan  chan bool
 	waitGroup *sync.WaitGroup
@@ -87,6 +100,15 @@ type backend struct {
 	// tracking of external ids of messages we've sent in case we need one before its status update has been written
 	sentExternalIDs *vkutil.IntervalHash
 
+	// tracking of the external ids and statuses of the parts of messages we've sent in multiple parts
+	sentPartExternalIDs *vkutil.IntervalHash
+
+	// tracking of when msg content was last sent to each URN for channels with a dedup window
+	sentContent *vkutil.IntervalHash
+
+	// tracking of contacts which have been described again by their channel recently
+	refreshedContacts *vkutil.IntervalSet
+
 	stats *StatsCollector
 
 	// both sqlx and redis provide wait stats which are cummulative that we need to convert into increments by
@@ -122,13 +144,19 @@ func newBackend(cfg *courier.Config) courier.Backend {
 
 		writerWG: &sync.WaitGroup{},
 
+		queueConfigs: make(map[courier.ChannelUUID]string),
+
 		mediaCache:   vkutil.NewIntervalHash("media-lookups", time.Hour*24, 2),
 		mediaMutexes: *syncx.NewHashMutex(8),
 
-		receivedMsgs:        vkutil.NewIntervalHash("seen-msgs", time.Second*2, 2),        // 2 - 4 seconds
-		receivedExternalIDs: vkutil.NewIntervalHash("seen-external-ids", time.Hour*24, 2), // 24 - 48 hours
-		sentIDs:             vkutil.NewIntervalSet("sent-ids", time.Hour, 2),              // 1 - 2 hours
-		sentExternalIDs:     vkutil.NewIntervalHash("sent-external-ids", time.Hour, 2),    // 1 - 2 hours
+		receivedMsgs:        vkutil.NewIntervalHash("seen-msgs", time.Second*2, 2),             // 2 - 4 seconds
+		receivedExternalIDs: vkutil.NewIntervalHash("seen-external-ids", time.Hour*24, 2),      // 24 - 48 hours
+		sentIDs:             vkutil.NewIntervalSet("sent-ids", time.Hour, 2),                   // 1 - 2 hours
+		sentExternalIDs:     vkutil.NewIntervalHash("sent-external-ids", time.Hour, 2),         // 1 - 2 hours
+		sentPartExternalIDs: vkutil.NewIntervalHash("sent-part-external-ids", time.Hour*24, 2), // 24 - 48 hours
+		sentContent:         vkutil.NewIntervalHash("sent-content", time.Hour, 2),              // 1 - 2 hours
+
+		refreshedContacts: vkutil.NewIntervalSet("refreshed-contacts", time.Hour*24, cfg.ContactRefreshDays+1), // N - N+1 days
 
 		stats: NewStatsCollector(),
 	}
@@ -168,9 +196,29 @@ func (b *backend) Start() error {
 		log.Info("valkey ok")
 	}
 
-	// start our dethrottler if we are going to be doing some sending
+	b.msgQueue = queue.NewValkeyQueue(b.rp, msgQueueName)
+
+	// start our dethrottler and releaser of scheduled msgs if we are going to be doing some sending
 	if b.config.MaxWorkers > 0 {
-		queue.StartDethrottler(b.rp, b.stopChan, b.waitGroup, msgQueueName)
+		b.msgQueue.Start(b.stopChan, b.waitGroup, b.config.RequeueExpired)
+	}
+
+	// msgs are popped with the priority configured for their origin, so that they're sent ahead of msgs of lower
+	// priority origins, whether they were queued by us or by mailroom
+	originPriorities, _ := b.config.ParseOriginPriorities() // already validated
+	priorities := make(map[string]queue.Priority, len(originPriorities))
+	for origin, priority := range originPriorities {
+		priorities[string(origin)] = queue.Priority(priority)
+	}
+	if err := b.msgQueue.SetOriginPriorities(priorities); err != nil {
+		log.Error("error setting origin priorities", "error", err)
+	}
+
+	// give queues whose msgs were pushed with different TPS a single TPS
+	if migrated, err := b.migrateQueueTPS(ctx); err != nil {
+		log.Error("error migrating queue TPS", "error", err)
+	} else if migrated > 0 {
+		log.Info("migrated queue TPS", "queues", migrated)
 	}
 
 	// setup DynamoDB main table
@@ -209,6 +257,8 @@ func (b *backend) Start() error {
 	b.channelsByUUID.Start()
 	b.channelsByAddr = cache.NewLocal(b.loadChannelByAddress, time.Minute)
 	b.channelsByAddr.Start()
+	b.channelTypes = cache.NewLocal(b.loadChannelType, time.Hour)
+	b.channelTypes.Start()
 
 	// make sure our spool dirs are writable
 	err = courier.EnsureSpoolDirPresent(b.config.SpoolDir, "msgs")
@@ -243,6 +293,7 @@ func (b *backend) Start() error {
 	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "events"), b.flushChannelEventFile)
 
 	b.startMetricsReporter(time.Minute)
+	b.startUnresolvedStatusRetrier(time.Minute)
 
 	slog.Info("backend started", "comp", "backend", "state", "started")
 	return nil
@@ -280,6 +331,34 @@ func (b *backend) startMetricsReporter(interval time.Duration) {
 	}()
 }
 
+func (b *backend) startUnresolvedStatusRetrier(interval time.Duration) {
+	b.waitGroup.Add(1)
+
+	go func() {
+		defer func() {
+			slog.Info("unresolved status retrier exiting")
+			b.waitGroup.Done()
+		}()
+
+		for {
+			select {
+			case <-b.stopChan:
+				return
+			case <-time.After(interval):
+				rc := b.rp.Get()
+				count, err := b.retryUnresolvedStatuses(rc)
+				rc.Close()
+
+				if err != nil {
+					slog.Error("error retrying unresolved statuses", "error", err)
+				} else if count > 0 {
+					slog.Info("retried unresolved statuses", "count", count)
+				}
+			}
+		}
+	}()
+}
+
 // Stop stops our RapidPro backend, closing our db and redis connections
 func (b *backend) Stop() error {
 	// close our stop channel
@@ -287,6 +366,7 @@ func (b *backend) Stop() error {
 
 	b.channelsByUUID.Stop()
 	b.channelsByAddr.Stop()
+	b.channelTypes.Stop()
 
 	// wait for our threads to exit
 	b.waitGroup.Wait()
@@ -350,7 +430,7 @@ func (b *backend) GetChannelByAddress(ctx context.Context, typ courier.ChannelTy
 // GetContact returns the contact for the passed in channel and URN
 func (b *backend) GetContact(ctx context.Context, c courier.Channel, urn urns.URN, authTokens map[string]string, name string, allowCreate bool, clog *courier.ChannelLog) (courier.Contact, error) {
 	dbChannel := c.(*Channel)
-	return contactForURN(ctx, b, dbChannel.OrgID_, dbChannel, urn, authTokens, name, allowCreate, clog)
+	return contactForURN(ctx, b, dbChannel.OrgID_, dbChannel, urn, authTokens, name, i18n.NilLanguage, allowCreate, clog)
 }
 
 // AddURNtoContact adds a URN to the passed in contact
@@ -361,7 +441,7 @@ func (b *backend) AddURNtoContact(ctx context.Context, c courier.Channel, contac
 	}
 	dbChannel := c.(*Channel)
 	dbContact := contact.(*Contact)
-	_, err = getOrCreateContactURN(tx, dbChannel, dbContact.ID_, urn, authTokens)
+	_, err = getOrCreateContactURN(tx, dbChannel, dbContact.ID_, urn, authTokens, b.config.KeepURNDisplays)
 	if err != nil {
 		return urns.NilURN, err
 	}
@@ -427,59 +507,135 @@ func (b *backend) NewIncomingMsg(ctx context.Context, channel courier.Channel, u
 
 // PopNextOutgoingMsg pops the next message that needs to be sent
 func (b *backend) PopNextOutgoingMsg(ctx context.Context) (courier.MsgOut, error) {
-	tryToPop := func() (queue.WorkerToken, string, error) {
-		rc := b.rp.Get()
-		defer rc.Close()
-		return queue.PopFromQueue(rc, msgQueueName)
+	// msgs which can't be sent yet are parked and skipped over, but only so many before we give up until the next call
+	for range maxPopSkips {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		msg, skipped, err := b.popOutgoingMsg(ctx)
+		if err != nil || !skipped {
+			return msg, err
+		}
 	}
+	return nil, nil
+}
 
+// popOutgoingMsg pops the next message off our queue, returning skipped as true if it couldn't be sent yet and the
+// caller should try the next one
+func (b *backend) popOutgoingMsg(ctx context.Context) (courier.MsgOut, bool, error) {
 	markComplete := func(token queue.WorkerToken) {
-		rc := b.rp.Get()
-		defer rc.Close()
-		if err := queue.MarkComplete(rc, msgQueueName, token); err != nil {
+		if err := b.msgQueue.MarkComplete(token); err != nil {
 			slog.Error("error marking queue task complete", "error", err)
 		}
 	}
 
+	// msgs which we don't hand to a sender shouldn't count against their channel's TPS or rate limits
+	refund := func(token queue.WorkerToken) {
+		if err := b.msgQueue.Refund(token); err != nil {
+			slog.Error("error refunding queue pop", "error", err)
+		}
+	}
+
 	// pop the next message off our queue
-	token, msgJSON, err := tryToPop()
+	token, msgJSON, pushedOn, err := b.msgQueue.Pop()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-
-	for token == queue.Retry {
-		token, msgJSON, err = tryToPop()
-		if err != nil {
-			return nil, err
-		}
+	if token == queue.Retry {
+		return nil, true, nil
 	}
-
 	if msgJSON == "" {
-		return nil, nil
+		return nil, false, nil
+	}
+
+	// msgs we can't send are moved to our dead letters rather than dropped so they can be inspected and replayed
+	deadLetter := func(cause error) {
+		if err := deadLetterMsg(b.msgQueue, token, msgJSON, cause); err != nil {
+			slog.Error("error adding msg to dead letters", "error", err)
+		}
 	}
 
 	dbMsg := &Msg{}
 	err = json.Unmarshal([]byte(msgJSON), dbMsg)
 	if err != nil {
+		deadLetter(fmt.Errorf("unable to unmarshal message: %w", err))
+		refund(token)
 		markComplete(token)
-		return nil, fmt.Errorf("unable to unmarshal message: %s: %w", string(msgJSON), err)
+		return nil, false, fmt.Errorf("unable to unmarshal message: %s: %w", string(msgJSON), err)
 	}
 
 	// populate the channel on our db msg
 	channel, err := b.GetChannel(ctx, courier.AnyChannelType, dbMsg.ChannelUUID_)
 	if err != nil {
+		// only msgs whose channel doesn't exist can't be sent, otherwise put it back to try again later
+		if errors.Is(err, courier.ErrChannelNotFound) {
+			deadLetter(fmt.Errorf("unable to load channel: %w", err))
+		} else if err := retryMsgJSON(b.msgQueue, token, msgJSON, time.Now().Add(msgRetryDelay)); err != nil {
+			slog.Error("error requeuing msg", "error", err)
+		}
+		refund(token)
 		markComplete(token)
-		return nil, err
+		return nil, false, err
 	}
 
 	dbMsg.Direction_ = MsgOutgoing
 	dbMsg.channel = channel.(*Channel)
 	dbMsg.workerToken = token
+	dbMsg.priority = token.Priority()
+	dbMsg.payload = []byte(msgJSON)
+
+	// if this msg can't be sent yet, because of its send after time or its channel's send window, park it until it can
+	// be and try the next one
+	// whether a msg is bulk depends on the priority it's actually being sent with, which may be that of its origin
+	now := time.Now()
+	bulk := token.Priority() == queue.LowPriority
+
+	if sendAt := courier.NextSendTime(dbMsg, bulk, now); sendAt.After(now) {
+		var err error
+
+		// if it's only waiting for its channel's send window to open, then so is every other msg the window applies to,
+		// so rather than park them one at a time, put this one back and pause its channel's bulk msgs, or all its msgs if
+		// the window applies to all of them, until the window opens
+		allPriorities := channel.BoolConfigForKey(courier.ConfigSendWindowAllPriorities, false)
+		waitingOnWindow := dbMsg.SendAfter() == nil || !dbMsg.SendAfter().After(now)
+
+		if waitingOnWindow && (bulk || allPriorities) {
+			err = retryMsgJSON(b.msgQueue, token, msgJSON, pushedOn)
+			if err == nil {
+				err = b.msgQueue.Pause(string(channel.UUID()), sendAt.Sub(now), !allPriorities)
+			}
+		} else {
+			err = scheduleMsg(b.msgQueue, token, msgJSON, dbMsg, sendAt)
+		}
+
+		refund(token)
+		markComplete(token)
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to schedule message: %w", err)
+		}
+		return nil, true, nil
+	}
+
+	// only send one msg at a time to each URN on a channel, so if one is being sent, this one has to wait its turn
+	urnLock, err := lockMsgURN(b.msgQueue, token, msgJSON, pushedOn, dbMsg)
+
+	if err != nil {
+		slog.Error("error locking msg URN", "error", err, "msg_id", dbMsg.ID())
+	} else if urnLock == "" {
+		refund(token)
+		markComplete(token)
+		return nil, true, nil
+	}
+	dbMsg.urnLock = urnLock
+
+	// record how long this msg waited in the queue
+	b.stats.RecordQueuePop(channel.ChannelType(), time.Since(pushedOn))
 
 	// clear out our seen incoming messages
 	b.clearMsgSeen(ctx, dbMsg)
 
-	return dbMsg, nil
+	return dbMsg, false, nil
 }
 
 // WasMsgSent returns whether the passed in message has already been sent
@@ -497,6 +653,130 @@ func (b *backend) ClearMsgSent(ctx context.Context, id courier.MsgID) error {
 	return b.sentIDs.Rem(ctx, rc, id.String())
 }
 
+// WasMsgDuplicate returns whether a msg with the same content was sent to the same URN within the channel's dedup window
+func (b *backend) WasMsgDuplicate(ctx context.Context, msg courier.MsgOut) (bool, error) {
+	window := msgDedupWindow(msg)
+	if window == 0 {
+		return false, nil
+	}
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	lastSent, err := b.sentContent.Get(ctx, rc, msgContentFingerprint(msg.(*Msg)))
+	if err != nil || lastSent == "" {
+		return false, err
+	}
+
+	lastSentMS, err := strconv.ParseInt(lastSent, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid sent content time '%s': %w", lastSent, err)
+	}
+
+	return time.Since(time.UnixMilli(lastSentMS)) < window, nil
+}
+
+// RequeueMsg pushes the passed in message back onto the queue it was popped from so that it is retried after the given delay
+func (b *backend) RequeueMsg(ctx context.Context, msg courier.MsgOut, delay time.Duration) error {
+	return requeueMsg(b.msgQueue, msg.(*Msg), time.Now().Add(delay))
+}
+
+// ReturnMsg pushes the passed in message back onto the queue it was popped from without it counting as a retry
+func (b *backend) ReturnMsg(ctx context.Context, msg courier.MsgOut) error {
+	return returnMsg(b.msgQueue, msg.(*Msg))
+}
+
+// PauseChannel pauses popping of msgs from the queue of the passed in channel
+func (b *backend) PauseChannel(ctx context.Context, ch courier.Channel, duration time.Duration, bulkOnly bool) error {
+	return b.msgQueue.Pause(string(ch.UUID()), duration, bulkOnly)
+}
+
+// UpdateChannelQueue reloads the channel with the passed in UUID and applies its TPS and rate limits to its queue
+func (b *backend) UpdateChannelQueue(ctx context.Context, uuid courier.ChannelUUID) (courier.Channel, error) {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	channel, err := b.loadChannelByUUID(timeout, uuid)
+	if err != nil {
+		return nil, err // so we don't return a non-nil interface and nil ptr
+	}
+
+	// replace any cached copy so the rest of its config changes apply too
+	b.channelsByUUID.Set(uuid, channel)
+
+	// loading it will have applied its TPS and rate limits if they've changed, but apply them regardless in case its
+	// queue has been changed by something else
+	if err := b.setChannelQueueConfig(channel); err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+// ResumeChannel removes any pause on popping msgs from the queue of the passed in channel
+func (b *backend) ResumeChannel(ctx context.Context, ch courier.Channel) error {
+	return b.msgQueue.Resume(string(ch.UUID()))
+}
+
+// PurgeChannel removes all msgs from the queue of the passed in channel, leaving them queued in the database
+func (b *backend) PurgeChannel(ctx context.Context, ch courier.Channel) (int, error) {
+	return b.msgQueue.Purge(string(ch.UUID()))
+}
+
+// DeadLetters returns the msgs which couldn't be sent because they couldn't be read or their channel couldn't be loaded
+func (b *backend) DeadLetters(ctx context.Context) ([]*courier.DeadLetter, error) {
+	dls, err := b.msgQueue.DeadLetters()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*courier.DeadLetter, len(dls))
+	for i, dl := range dls {
+		letters[i] = &courier.DeadLetter{
+			ID:          dl.ID,
+			ChannelUUID: courier.ChannelUUID(dl.Queue),
+			Msg:         dl.Value,
+			Error:       dl.Error,
+			FailedOn:    dl.FailedOn,
+		}
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetter pushes the dead letter with the given ID back onto the queue it was popped from
+func (b *backend) ReplayDeadLetter(ctx context.Context, id string) (bool, error) {
+	return b.msgQueue.ReplayDeadLetter(id)
+}
+
+// DiscardDeadLetter removes the dead letter with the given ID
+func (b *backend) DiscardDeadLetter(ctx context.Context, id string) (bool, error) {
+	return b.msgQueue.DiscardDeadLetter(id)
+}
+
+// ChannelQueues returns the state of all channel queues with msgs or workers
+func (b *backend) ChannelQueues(ctx context.Context) ([]*courier.ChannelQueue, error) {
+	infos, err := b.msgQueue.Queues()
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make([]*courier.ChannelQueue, len(infos))
+	for i, info := range infos {
+		queues[i] = &courier.ChannelQueue{
+			ChannelUUID: courier.ChannelUUID(info.Queue),
+			TPS:         info.TPS,
+			State:       string(info.State),
+			Workers:     info.Workers,
+			Size:        info.Size,
+			BulkSize:    info.BulkSize,
+			CurrentTPS:  info.CurrentTPS,
+			Paused:      info.Paused,
+			BulkPaused:  info.BulkPaused,
+			Limited:     info.Limited,
+		}
+	}
+	return queues, nil
+}
+
 // OnSendComplete is called when the sender has finished trying to send a message
 func (b *backend) OnSendComplete(ctx context.Context, msg courier.MsgOut, status courier.StatusUpdate, clog *courier.ChannelLog) {
 	log := slog.With("channel", msg.Channel().UUID(), "msg", msg.UUID(), "clog", clog.UUID, "status", status)
@@ -506,12 +786,22 @@ func (b *backend) OnSendComplete(ctx context.Context, msg courier.MsgOut, status
 
 	dbMsg := msg.(*Msg)
 
-	if err := queue.MarkComplete(rc, msgQueueName, dbMsg.workerToken); err != nil {
+	if err := b.msgQueue.MarkComplete(dbMsg.workerToken); err != nil {
 		log.Error("unable to mark queue task complete", "error", err)
 	}
 
+	// let the next msg to this URN be sent, after the channel's send gap if it has one
+	if err := unlockMsgURN(b.msgQueue, dbMsg, msgSendGap(msg)); err != nil {
+		log.Error("unable to unlock msg URN", "error", err)
+	}
+
+	// a dry run send wasn't really made so nothing else should treat the msg as sent
+	if clog.DryRun() {
+		return
+	}
+
 	// if message won't be retried, mark as sent to avoid dupe sends
-	if status.Status() != courier.MsgStatusErrored {
+	if status.Status() != courier.MsgStatusErrored && status.Status() != courier.MsgStatusQueued {
 		if err := b.sentIDs.Add(ctx, rc, msg.ID().String()); err != nil {
 			log.Error("unable to mark message sent", "error", err)
 		}
@@ -525,6 +815,13 @@ func (b *backend) OnSendComplete(ctx context.Context, msg courier.MsgOut, status
 		}
 	}
 
+	// if message was successfully sent, and its channel dedups content, record when we sent this content
+	if wasSuccess && msgDedupWindow(msg) > 0 {
+		if err := b.sentContent.Set(ctx, rc, msgContentFingerprint(dbMsg), strconv.FormatInt(time.Now().UnixMilli(), 10)); err != nil {
+			log.Error("unable to record sent msg content", "error", err)
+		}
+	}
+
 	b.stats.RecordOutgoing(msg.Channel().ChannelType(), wasSuccess, clog.Elapsed)
 }
 
@@ -583,9 +880,8 @@ func (b *backend) WriteStatusUpdate(ctx context.Context, status courier.StatusUp
 			rc := b.rp.Get()
 			defer rc.Close()
 
-			err := b.sentExternalIDs.Set(ctx, rc, fmt.Sprintf("%d|%s", su.ChannelID_, su.ExternalID_), fmt.Sprintf("%d", status.MsgID()))
-			if err != nil {
-				log.Error("error recording external id", "error", err)
+			if err := b.recordSentExternalIDs(ctx, rc, su); err != nil {
+				log.Error("error recording external ids", "error", err)
 			}
 		}
 
@@ -788,39 +1084,36 @@ func (b *backend) Health() string {
 }
 
 func (b *backend) reportMetrics(ctx context.Context) (int, error) {
-	metrics := b.stats.Extract().ToMetrics()
-
-	// get queue sizes
-	rc := b.rp.Get()
-	defer rc.Close()
-	active, err := redis.Strings(rc.Do("ZRANGE", fmt.Sprintf("%s:active", msgQueueName), "0", "-1"))
+	// get queue sizes, in total and by channel type
+	queues, err := b.msgQueue.Queues()
 	if err != nil {
-		return 0, fmt.Errorf("error getting active queues: %w", err)
+		return 0, fmt.Errorf("error getting queues: %w", err)
 	}
-	throttled, err := redis.Strings(rc.Do("ZRANGE", fmt.Sprintf("%s:throttled", msgQueueName), "0", "-1"))
-	if err != nil {
-		return 0, fmt.Errorf("error getting throttled queues: %w", err)
-	}
-	queues := append(active, throttled...)
 
 	prioritySize := 0
 	bulkSize := 0
-	for _, queue := range queues {
-		q := fmt.Sprintf("%s/1", queue)
-		count, err := redis.Int(rc.Do("ZCARD", q))
-		if err != nil {
-			return 0, fmt.Errorf("error getting size of priority queue: %s: %w", q, err)
+	for _, q := range queues {
+		prioritySize += q.Size
+		bulkSize += q.BulkSize
+
+		if typ, err := b.channelTypes.GetOrFetch(ctx, courier.ChannelUUID(q.Queue)); err == nil {
+			b.stats.RecordQueue(typ, q.Size+q.BulkSize)
 		}
-		prioritySize += count
+	}
 
-		q = fmt.Sprintf("%s/0", queue)
-		count, err = redis.Int(rc.Do("ZCARD", q))
-		if err != nil {
-			return 0, fmt.Errorf("error getting size of bulk queue: %s: %w", q, err)
+	// and how many times queues were throttled since we last reported
+	throttles, err := b.msgQueue.ExtractThrottles()
+	if err != nil {
+		return 0, fmt.Errorf("error getting queue throttles: %w", err)
+	}
+	for name, count := range throttles {
+		if typ, err := b.channelTypes.GetOrFetch(ctx, courier.ChannelUUID(name)); err == nil {
+			b.stats.RecordQueueThrottles(typ, count)
 		}
-		bulkSize += count
 	}
 
+	metrics := b.stats.Extract().ToMetrics()
+
 	// calculate DB and redis pool metrics
 	dbStats := b.db.Stats()
 	redisStats := b.rp.Stats()
@@ -848,68 +1141,30 @@ func (b *backend) reportMetrics(ctx context.Context) (int, error) {
 
 // Status returns information on our queue sizes, number of workers etc..
 func (b *backend) Status() string {
-	rc := b.rp.Get()
-	defer rc.Close()
-
 	status := bytes.Buffer{}
 	status.WriteString("------------------------------------------------------------------------------------\n")
-	status.WriteString("     Size | Bulk Size | Workers | TPS | Type | Channel              \n")
+	status.WriteString("     Size | Bulk Size | Workers | TPS | Max | Type | Channel              \n")
 	status.WriteString("------------------------------------------------------------------------------------\n")
 
-	var queue string
-	var workers float64
-
-	// get all our queues
-	rc.Send("zrevrangebyscore", fmt.Sprintf("%s:active", msgQueueName), "+inf", "-inf", "withscores")
-	rc.Send("zrevrangebyscore", fmt.Sprintf("%s:throttled", msgQueueName), "+inf", "-inf", "withscores")
-	rc.Flush()
-
-	active, err := redis.Values(rc.Receive())
+	queues, err := b.msgQueue.Queues()
 	if err != nil {
-		return fmt.Sprintf("unable to read active queues: %v", err)
+		return fmt.Sprintf("unable to read queues: %v", err)
 	}
-	throttled, err := redis.Values(rc.Receive())
-	if err != nil {
-		return fmt.Sprintf("unable to read throttled queues: %v", err)
-	}
-	values := append(active, throttled...)
-
-	for len(values) > 0 {
-		values, err = redis.Scan(values, &queue, &workers)
-		if err != nil {
-			return fmt.Sprintf("error reading active queues: %v", err)
-		}
-
-		// our queue name is in the format msgs:uuid|tps, break it apart
-		queue = strings.TrimPrefix(queue, "msgs:")
-		parts := strings.Split(queue, "|")
-		if len(parts) != 2 {
-			return fmt.Sprintf("error parsing queue name '%s'", queue)
-		}
-		uuid := parts[0]
-		tps := parts[1]
 
+	for _, q := range queues {
 		// try to look up our channel
-		channelUUID := courier.ChannelUUID(uuid)
-		channel, err := b.GetChannel(context.Background(), courier.AnyChannelType, channelUUID)
+		channel, err := b.GetChannel(context.Background(), courier.AnyChannelType, courier.ChannelUUID(q.Queue))
 		channelType := "!!"
+		maxInFlight := "-"
 		if err == nil {
 			channelType = string(channel.ChannelType())
-		}
 
-		// get # of items in our normal queue
-		size, err := redis.Int64(rc.Do("ZCARD", fmt.Sprintf("%s:%s/1", msgQueueName, queue)))
-		if err != nil {
-			return fmt.Sprintf("error reading queue size: %v", err)
-		}
-
-		// get # of items in the bulk queue
-		bulkSize, err := redis.Int64(rc.Do("ZCARD", fmt.Sprintf("%s:%s/0", msgQueueName, queue)))
-		if err != nil {
-			return fmt.Sprintf("error reading bulk queue size: %v", err)
+			if limit := b.config.ChannelMaxInFlight(channel); limit > 0 {
+				maxInFlight = strconv.Itoa(limit)
+			}
 		}
 
-		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3s   % 4s   %s\n", size, bulkSize, int(workers), tps, channelType, uuid))
+		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3d   % 3s   % 4s   %s\n", q.Size, q.BulkSize, q.Workers, q.TPS, maxInFlight, channelType, q.Queue))
 	}
 
 	return status.String()
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/buger/jsonparser"
@@ -134,6 +135,11 @@ func (ts *BackendTestSuite) clearValkey() {
 	defer r.Close()
 	_, err := r.Do("FLUSHDB")
 	ts.Require().NoError(err)
+
+	// forget what we've applied to queues since that's now gone
+	ts.b.queueConfigsMutex.Lock()
+	ts.b.queueConfigs = make(map[courier.ChannelUUID]string)
+	ts.b.queueConfigsMutex.Unlock()
 }
 
 func (ts *BackendTestSuite) getChannel(cType string, cUUID string) *Channel {
@@ -238,18 +244,18 @@ func (ts *BackendTestSuite) TestContact() {
 	ctx := context.Background()
 	now := time.Now()
 
-	contact, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Ryan Lewis", false, clog)
+	contact, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Ryan Lewis", i18n.NilLanguage, false, clog)
 	ts.NoError(err)
 	ts.Nil(contact)
 
 	// create our new contact
-	contact, err = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Ryan Lewis", true, clog)
+	contact, err = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Ryan Lewis", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 
 	now2 := time.Now()
 
 	// load this contact again by URN, should be same contact, name unchanged
-	contact2, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Other Name", true, clog)
+	contact2, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Other Name", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 
 	ts.Equal(contact.UUID_, contact2.UUID_)
@@ -263,7 +269,7 @@ func (ts *BackendTestSuite) TestContact() {
 
 	// load a contact by URN instead (this one is in our testdata)
 	cURN := urns.URN("tel:+12067799192")
-	contact, err = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, cURN, nil, "", true, clog)
+	contact, err = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, cURN, nil, "", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 	ts.NotNil(contact)
 
@@ -275,11 +281,25 @@ func (ts *BackendTestSuite) TestContact() {
 	// long name are truncated
 
 	longName := "LongRandomNameHPGBRDjZvkz7y58jI2UPkio56IKGaMvaeDTvF74Q5SUkIHozFn1MLELfjX7vRrFto8YG2KPVaWzekgmFbkuxujIotFAgfhHqoHKW5c177FUtKf5YK9KbY8hp0x7PxIFY3MS5lMyMA5ELlqIgikThpr"
-	contact3, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, longName, true, clog)
+	contact3, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, longName, i18n.NilLanguage, true, clog)
+	ts.NoError(err)
+
+	ts.Equal(null.String(longName[0:128]), contact3.Name_)
+
+	// new contacts are created with the language reported by the channel
+	urn = urns.URN("tel:+12065551520")
+	contact4, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Marie", i18n.Language("fra"), true, clog)
 	ts.NoError(err)
+	ts.Equal(i18n.Language("fra"), contact4.Language_)
 
-	ts.Equal(null.String(longName[0:127]), contact3.Name_)
+	assertdb.Query(ts.T(), ts.b.db, `SELECT language FROM contacts_contact WHERE id = $1`, contact4.ID_).Returns("fra")
 
+	// but existing contacts keep their language
+	contact5, err := contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Marie", i18n.Language("spa"), true, clog)
+	ts.NoError(err)
+	ts.Equal(contact4.ID_, contact5.ID_)
+
+	assertdb.Query(ts.T(), ts.b.db, `SELECT language FROM contacts_contact WHERE id = $1`, contact4.ID_).Returns("fra")
 }
 
 func (ts *BackendTestSuite) TestContactRace() {
@@ -297,10 +317,10 @@ func (ts *BackendTestSuite) TestContactRace() {
 	var err1, err2 error
 
 	go func() {
-		contact1, err1 = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Ryan Lewis", true, clog)
+		contact1, err1 = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Ryan Lewis", i18n.NilLanguage, true, clog)
 	}()
 	go func() {
-		contact2, err2 = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Ryan Lewis", true, clog)
+		contact2, err2 = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn, nil, "Ryan Lewis", i18n.NilLanguage, true, clog)
 	}()
 
 	time.Sleep(time.Second)
@@ -317,7 +337,7 @@ func (ts *BackendTestSuite) TestAddAndRemoveContactURN() {
 
 	cURN := urns.URN("tel:+12067799192")
 
-	contact, err := contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, cURN, nil, "", true, clog)
+	contact, err := contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, cURN, nil, "", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 	ts.NotNil(contact)
 
@@ -359,14 +379,14 @@ func (ts *BackendTestSuite) TestContactURN() {
 
 	ctx := context.Background()
 
-	contact, err := contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, urn, nil, "", true, clog)
+	contact, err := contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, urn, nil, "", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 	ts.NotNil(contact)
 
 	tx, err := ts.b.db.Beginx()
 	ts.NoError(err)
 
-	contact, err = contactForURN(ctx, ts.b, fbChannel.OrgID_, fbChannel, urn, map[string]string{"token1": "chestnut"}, "", true, clog)
+	contact, err = contactForURN(ctx, ts.b, fbChannel.OrgID_, fbChannel, urn, map[string]string{"token1": "chestnut"}, "", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 	ts.NotNil(contact)
 
@@ -375,7 +395,7 @@ func (ts *BackendTestSuite) TestContactURN() {
 	ts.Equal(null.Map[string]{"token1": "chestnut"}, contactURNs[0].AuthTokens)
 
 	// now build a URN for our number with the kannel channel
-	knURN, err := getOrCreateContactURN(tx, knChannel, contact.ID_, urn, map[string]string{"token2": "sesame"})
+	knURN, err := getOrCreateContactURN(tx, knChannel, contact.ID_, urn, map[string]string{"token2": "sesame"}, false)
 	ts.NoError(err)
 	ts.NoError(tx.Commit())
 	ts.Equal(knURN.OrgID, knChannel.OrgID_)
@@ -385,7 +405,7 @@ func (ts *BackendTestSuite) TestContactURN() {
 	ts.NoError(err)
 
 	// then with our twilio channel
-	fbURN, err := getOrCreateContactURN(tx, fbChannel, contact.ID_, urn, nil)
+	fbURN, err := getOrCreateContactURN(tx, fbChannel, contact.ID_, urn, nil, false)
 	ts.NoError(err)
 	ts.NoError(tx.Commit())
 
@@ -405,7 +425,7 @@ func (ts *BackendTestSuite) TestContactURN() {
 	ts.NoError(err)
 
 	// again with different auth
-	fbURN, err = getOrCreateContactURN(tx, fbChannel, contact.ID_, urn, map[string]string{"token3": "peanut"})
+	fbURN, err = getOrCreateContactURN(tx, fbChannel, contact.ID_, urn, map[string]string{"token3": "peanut"}, false)
 	ts.NoError(err)
 	ts.NoError(tx.Commit())
 	ts.Equal(null.Map[string]{"token1": "chestnut", "token2": "sesame", "token3": "peanut"}, fbURN.AuthTokens)
@@ -414,11 +434,11 @@ func (ts *BackendTestSuite) TestContactURN() {
 	tgChannel := ts.getChannel("TG", "dbc126ed-66bc-4e28-b67b-81dc3327c98a")
 	tgURN := urns.URN("telegram:12345")
 
-	tgContact, err := contactForURN(ctx, ts.b, tgChannel.OrgID_, tgChannel, tgURN, nil, "", true, clog)
+	tgContact, err := contactForURN(ctx, ts.b, tgChannel.OrgID_, tgChannel, tgURN, nil, "", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 
 	tgURNDisplay := urns.URN("telegram:12345#Jane")
-	displayContact, err := contactForURN(ctx, ts.b, tgChannel.OrgID_, tgChannel, tgURNDisplay, nil, "", true, clog)
+	displayContact, err := contactForURN(ctx, ts.b, tgChannel.OrgID_, tgChannel, tgURNDisplay, nil, "", i18n.NilLanguage, true, clog)
 
 	ts.NoError(err)
 	ts.Equal(tgContact.URNID_, displayContact.URNID_)
@@ -427,12 +447,30 @@ func (ts *BackendTestSuite) TestContactURN() {
 	tx, err = ts.b.db.Beginx()
 	ts.NoError(err)
 
-	tgContactURN, err := getOrCreateContactURN(tx, tgChannel, tgContact.ID_, tgURNDisplay, nil)
+	tgContactURN, err := getOrCreateContactURN(tx, tgChannel, tgContact.ID_, tgURNDisplay, nil, false)
 	ts.NoError(err)
 	ts.NoError(tx.Commit())
 	ts.Equal(tgContact.URNID_, tgContactURN.ID)
 	ts.Equal(null.String("Jane"), tgContactURN.Display)
 
+	// a URN without a display doesn't clear the one we have if we're keeping displays, e.g. one described by its channel
+	tx, err = ts.b.db.Beginx()
+	ts.NoError(err)
+
+	tgContactURN, err = getOrCreateContactURN(tx, tgChannel, tgContact.ID_, tgURN, nil, true)
+	ts.NoError(err)
+	ts.NoError(tx.Commit())
+	ts.Equal(null.String("Jane"), tgContactURN.Display)
+
+	// but otherwise does
+	tx, err = ts.b.db.Beginx()
+	ts.NoError(err)
+
+	tgContactURN, err = getOrCreateContactURN(tx, tgChannel, tgContact.ID_, tgURN, nil, false)
+	ts.NoError(err)
+	ts.NoError(tx.Commit())
+	ts.Equal(null.NullString, tgContactURN.Display)
+
 	// try to create two contacts at the same time in goroutines, this tests our transaction rollbacks
 	urn2 := urns.URN("tel:+12065551616")
 	var wait sync.WaitGroup
@@ -440,13 +478,13 @@ func (ts *BackendTestSuite) TestContactURN() {
 	wait.Add(2)
 	go func() {
 		var err2 error
-		contact2, err2 = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn2, nil, "", true, clog)
+		contact2, err2 = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn2, nil, "", i18n.NilLanguage, true, clog)
 		ts.NoError(err2)
 		wait.Done()
 	}()
 	go func() {
 		var err3 error
-		contact3, err3 = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn2, nil, "", true, clog)
+		contact3, err3 = contactForURN(ctx, ts.b, knChannel.OrgID(), knChannel, urn2, nil, "", i18n.NilLanguage, true, clog)
 		ts.NoError(err3)
 		wait.Done()
 	}()
@@ -466,19 +504,19 @@ func (ts *BackendTestSuite) TestContactURNPriority() {
 
 	ctx := context.Background()
 
-	knContact, err := contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, knURN, nil, "", true, clog)
+	knContact, err := contactForURN(ctx, ts.b, knChannel.OrgID_, knChannel, knURN, nil, "", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 
 	tx, err := ts.b.db.Beginx()
 	ts.NoError(err)
 
-	_, err = getOrCreateContactURN(tx, fbChannel, knContact.ID_, fbURN, nil)
+	_, err = getOrCreateContactURN(tx, fbChannel, knContact.ID_, fbURN, nil, false)
 	ts.NoError(err)
 	ts.NoError(tx.Commit())
 
 	// ok, now looking up our contact should reset our URNs and their affinity..
 	// FacebookURN should be first all all URNs should now use Facebook channel
-	fbContact, err := contactForURN(ctx, ts.b, fbChannel.OrgID_, fbChannel, fbURN, nil, "", true, clog)
+	fbContact, err := contactForURN(ctx, ts.b, fbChannel.OrgID_, fbChannel, fbURN, nil, "", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 
 	ts.Equal(fbContact.ID_, knContact.ID_)
@@ -585,6 +623,17 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.Nil(m.SentOn_)
 	ts.Equal(pq.StringArray([]string{string(clog5.UUID)}), m.LogUUIDs)
 
+	// failed is final so a late WIRED is ignored
+	updateStatusByExtID("ext1", courier.MsgStatusWired)
+
+	m = readMsgFromDB(ts.b, 10000)
+	ts.Equal(courier.MsgStatusFailed, m.Status_)
+	ts.Nil(m.SentOn_)
+	ts.Equal(pq.StringArray([]string{string(clog5.UUID)}), m.LogUUIDs)
+
+	// unless the message is resent which puts it back into queued state
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'Q' WHERE id = $1`, 10000)
+
 	now = time.Now().In(time.UTC)
 	time.Sleep(2 * time.Millisecond)
 
@@ -620,12 +669,19 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.Equal(courier.MsgStatusDelivered, m.Status_)
 	ts.NotNil(m.SentOn_)
 
-	// reset our status to sent
-	status := ts.b.NewStatusUpdateByExternalID(channel, "ext1", courier.MsgStatusSent, clog6)
+	// a msg can't error once it's been sent
+	status := ts.b.NewStatusUpdateByExternalID(channel, "ext1", courier.MsgStatusErrored, clog6)
 	err := ts.b.WriteStatusUpdate(ctx, status)
 	ts.NoError(err)
 	time.Sleep(time.Second)
 
+	m = readMsgFromDB(ts.b, 10000)
+	ts.Equal(courier.MsgStatusSent, m.Status_)
+	ts.Equal(0, m.ErrorCount_)
+
+	// so put it back to wired
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'W' WHERE id = $1`, 10000)
+
 	// error our msg
 	now = time.Now().In(time.UTC)
 	time.Sleep(2 * time.Millisecond)
@@ -666,6 +722,19 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.Equal(m.ErrorCount_, 3)
 	ts.Equal(null.String("E"), m.FailedReason_)
 
+	// expired msgs are recorded as failed due to being too old
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'Q', failed_reason = NULL WHERE id = $1`, 10000)
+
+	status = ts.b.NewStatusUpdate(channel, courier.MsgID(10000), courier.MsgStatusFailed, clog6)
+	status.SetFailedReason(courier.MsgFailedReasonTooOld)
+	ts.NoError(ts.b.WriteStatusUpdate(ctx, status))
+
+	time.Sleep(time.Second) // give committer time to write this
+
+	m = readMsgFromDB(ts.b, 10000)
+	ts.Equal(courier.MsgStatusFailed, m.Status_)
+	ts.Equal(null.String("O"), m.FailedReason_)
+
 	// update URN when the new doesn't exist
 	tx, _ := ts.b.db.BeginTxx(ctx, nil)
 	oldURN := urns.URN("whatsapp:55988776655")
@@ -690,7 +759,7 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	oldURN = urns.URN("whatsapp:55999887766")
 	newURN = urns.URN("whatsapp:5599887766")
 	tx, _ = ts.b.db.BeginTxx(ctx, nil)
-	contact, _ := contactForURN(ctx, ts.b, channel.OrgID_, channel, oldURN, nil, "", true, clog6)
+	contact, _ := contactForURN(ctx, ts.b, channel.OrgID_, channel, oldURN, nil, "", i18n.NilLanguage, true, clog6)
 	_ = insertContactURN(tx, newContactURN(channel.OrgID_, channel.ID_, NilContactID, newURN, nil))
 
 	ts.NoError(tx.Commit())
@@ -712,8 +781,8 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	oldURN = urns.URN("whatsapp:55988776655")
 	newURN = urns.URN("whatsapp:5588776655")
 	tx, _ = ts.b.db.BeginTxx(ctx, nil)
-	_, _ = contactForURN(ctx, ts.b, channel.OrgID_, channel, oldURN, nil, "", true, clog6)
-	otherContact, _ := contactForURN(ctx, ts.b, channel.OrgID_, channel, newURN, nil, "", true, clog6)
+	_, _ = contactForURN(ctx, ts.b, channel.OrgID_, channel, oldURN, nil, "", i18n.NilLanguage, true, clog6)
+	otherContact, _ := contactForURN(ctx, ts.b, channel.OrgID_, channel, newURN, nil, "", i18n.NilLanguage, true, clog6)
 
 	ts.NoError(tx.Commit())
 
@@ -731,6 +800,86 @@ func (ts *BackendTestSuite) TestMsgStatus() {
 	ts.NoError(tx.Commit())
 }
 
+func (ts *BackendTestSuite) TestUnresolvedStatuses() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgStatus, channel, nil)
+
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'Q', sent_on = NULL WHERE id = $1`, 10001)
+
+	// a delivery receipt arrives before we've written the external id of the msg so is parked
+	ts.NoError(ts.b.WriteStatusUpdate(ctx, ts.b.NewStatusUpdateByExternalID(channel, "ext-early", courier.MsgStatusDelivered, clog)))
+	time.Sleep(600 * time.Millisecond) // give committer time to write this
+
+	assertvk.LLen(ts.T(), rc, "unresolved-statuses:10|ext-early", 1)
+	assertvk.ZCard(ts.T(), rc, "unresolved-statuses", 1)
+
+	m := readMsgFromDB(ts.b, 10001)
+	ts.Equal(courier.MsgStatusQueued, m.Status_)
+
+	// once our own status with the external id is written, the parked status is retried
+	status := ts.b.NewStatusUpdate(channel, 10001, courier.MsgStatusWired, clog)
+	status.SetExternalID("ext-early")
+	ts.NoError(ts.b.WriteStatusUpdate(ctx, status))
+	time.Sleep(1200 * time.Millisecond) // give committer time to write this and then the retried status
+
+	assertvk.NotExists(ts.T(), rc, "unresolved-statuses:10|ext-early")
+	assertvk.ZCard(ts.T(), rc, "unresolved-statuses", 0)
+
+	m = readMsgFromDB(ts.b, 10001)
+	ts.Equal(courier.MsgStatusDelivered, m.Status_)
+	ts.Equal(null.String("ext-early"), m.ExternalID_)
+
+	// statuses can also be retried periodically
+	ts.NoError(ts.b.WriteStatusUpdate(ctx, ts.b.NewStatusUpdateByExternalID(channel, "ext-later", courier.MsgStatusRead, clog)))
+	time.Sleep(600 * time.Millisecond)
+
+	assertvk.LLen(ts.T(), rc, "unresolved-statuses:10|ext-later", 1)
+
+	ts.b.db.MustExec(`UPDATE msgs_msg SET external_id = 'ext-later' WHERE id = $1`, 10001)
+
+	retried, err := ts.b.retryUnresolvedStatuses(rc)
+	ts.NoError(err)
+	ts.Equal(1, retried)
+	time.Sleep(600 * time.Millisecond)
+
+	assertvk.ZCard(ts.T(), rc, "unresolved-statuses", 0)
+
+	m = readMsgFromDB(ts.b, 10001)
+	ts.Equal(courier.MsgStatusRead, m.Status_)
+
+	// statuses that are still unresolved are parked again, until they've been parked for too long
+	ts.NoError(ts.b.WriteStatusUpdate(ctx, ts.b.NewStatusUpdateByExternalID(channel, "ext-never", courier.MsgStatusDelivered, clog)))
+	time.Sleep(600 * time.Millisecond)
+
+	retried, err = ts.b.retryUnresolvedStatuses(rc)
+	ts.NoError(err)
+	ts.Equal(1, retried)
+	time.Sleep(600 * time.Millisecond)
+
+	assertvk.LLen(ts.T(), rc, "unresolved-statuses:10|ext-never", 1)
+
+	parkedOn := time.Now().Add(-unresolvedStatusTTL)
+	expired := ts.b.NewStatusUpdateByExternalID(channel, "ext-expired", courier.MsgStatusDelivered, clog).(*StatusUpdate)
+	expired.ParkedOn_ = &parkedOn
+	ts.NoError(ts.b.parkUnresolvedStatuses(rc, []*StatusUpdate{expired}))
+
+	assertvk.NotExists(ts.T(), rc, "unresolved-statuses:10|ext-expired")
+
+	// errors replied to any of the commands which park statuses are returned
+	_, err = rc.Do("SET", "unresolved-statuses:10|ext-wrongtype", "x")
+	ts.NoError(err)
+
+	wrongType := ts.b.NewStatusUpdateByExternalID(channel, "ext-wrongtype", courier.MsgStatusDelivered, clog).(*StatusUpdate)
+	err = ts.b.parkUnresolvedStatuses(rc, []*StatusUpdate{wrongType})
+	ts.ErrorContains(err, "error parking unresolved status: WRONGTYPE")
+}
+
 func (ts *BackendTestSuite) TestSentExternalIDCaching() {
 	rc := ts.b.rp.Get()
 	defer rc.Close()
@@ -771,6 +920,299 @@ func (ts *BackendTestSuite) TestSentExternalIDCaching() {
 	assertdb.Query(ts.T(), ts.b.db, `SELECT status FROM msgs_msg WHERE id = 10000`).Returns("D")
 }
 
+func (ts *BackendTestSuite) TestMultiPartStatuses() {
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, channel, nil)
+
+	ts.clearValkey()
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'Q', external_id = NULL WHERE id = 10000`)
+
+	// create a status update from a send in 3 parts
+	status1 := ts.b.NewStatusUpdate(channel, 10000, courier.MsgStatusWired, clog)
+	status1.SetExternalIDs([]string{"ex1", "ex2", "ex3"})
+	ts.Equal("ex1", status1.ExternalID())
+	ts.NoError(ts.b.WriteStatusUpdate(ctx, status1))
+
+	time.Sleep(time.Millisecond * 600)
+
+	keys, err := redis.Strings(rc.Do("KEYS", "sent-part-external-ids:*"))
+	ts.NoError(err)
+	ts.Len(keys, 1)
+	assertvk.HGetAll(ts.T(), rc, keys[0], map[string]string{"10|ex1": "10000|0", "10|ex2": "10000|1", "10|ex3": "10000|2"})
+	assertdb.Query(ts.T(), ts.b.db, `SELECT status, external_id FROM msgs_msg WHERE id = 10000`).Columns(map[string]any{"status": "W", "external_id": "ex1"})
+
+	// parts are also written to dynamo
+	assertParts := func(statuses ...any) {
+		item, err := ts.b.dynamo.GetItem(ctx, GetMsgPartsKey(channel.UUID(), 10000))
+		ts.NoError(err)
+		if ts.NotNil(item) {
+			ts.Equal([]any{"ex1", "ex2", "ex3"}, item.Data["external_ids"])
+			ts.Equal(statuses, item.Data["statuses"])
+		}
+	}
+	assertParts("W", "W", "W")
+
+	item, err := ts.b.dynamo.GetItem(ctx, GetMsgPartKey(channel.UUID(), "ex2"))
+	ts.NoError(err)
+	if ts.NotNil(item) {
+		ts.Equal(map[string]any{"msg_id": float64(10000), "index": float64(1)}, item.Data)
+	}
+
+	writeStatus := func(extID string, status courier.MsgStatus) {
+		clog := courier.NewChannelLog(courier.ChannelLogTypeMsgStatus, channel, nil)
+		ts.NoError(ts.b.WriteStatusUpdate(ctx, ts.b.NewStatusUpdateByExternalID(channel, extID, status, clog)))
+		time.Sleep(time.Millisecond * 600)
+	}
+
+	// a later part being delivered doesn't make the msg delivered
+	writeStatus("ex3", courier.MsgStatusDelivered)
+	assertdb.Query(ts.T(), ts.b.db, `SELECT status, external_id FROM msgs_msg WHERE id = 10000`).Columns(map[string]any{"status": "W", "external_id": "ex1"})
+	assertParts("W", "W", "D")
+
+	writeStatus("ex1", courier.MsgStatusDelivered)
+	writeStatus("ex2", courier.MsgStatusRead)
+	assertdb.Query(ts.T(), ts.b.db, `SELECT status FROM msgs_msg WHERE id = 10000`).Returns("D")
+	assertParts("D", "R", "D")
+
+	// later parts can still be resolved once our caches of sent external ids have expired
+	ts.NoError(ts.b.sentExternalIDs.Clear(ctx, rc))
+	ts.NoError(ts.b.sentPartExternalIDs.Clear(ctx, rc))
+
+	writeStatus("ex3", courier.MsgStatusRead)
+	assertParts("D", "R", "R")
+
+	// and delivered can't be replaced by a failure of any part
+	writeStatus("ex2", courier.MsgStatusFailed)
+	assertdb.Query(ts.T(), ts.b.db, `SELECT status, external_id FROM msgs_msg WHERE id = 10000`).Columns(map[string]any{"status": "D", "external_id": "ex1"})
+
+	// if rolling up the status of a part fails, it isn't written as the status of the whole msg but left unresolved to
+	// be retried
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'W' WHERE id = 10000`)
+
+	partsKey, _ := attributevalue.MarshalMap(GetMsgPartsKey(channel.UUID(), 10000))
+	_, err = ts.b.dynamo.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(ts.b.dynamo.Name()), Key: partsKey})
+	ts.NoError(err)
+
+	status2 := ts.b.NewStatusUpdateByExternalID(channel, "ex3", courier.MsgStatusDelivered, clog).(*StatusUpdate)
+	unresolved, err := ts.b.writeStatusUpdatesToDB(ctx, []*StatusUpdate{status2})
+	ts.NoError(err)
+	ts.Equal([]*StatusUpdate{status2}, unresolved)
+	ts.Equal(courier.NilMsgID, status2.MsgID())
+	ts.Equal("ex3", status2.ExternalID())
+	assertdb.Query(ts.T(), ts.b.db, `SELECT status, external_id FROM msgs_msg WHERE id = 10000`).Columns(map[string]any{"status": "W", "external_id": "ex1"})
+}
+
+func (ts *BackendTestSuite) TestStatusTimeline() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	ts.clearValkey()
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'Q', sent_on = NULL WHERE id = 10001`)
+
+	writeStatus := func(status courier.MsgStatus, extID string) *courier.ChannelLog {
+		clog := courier.NewChannelLog(courier.ChannelLogTypeMsgStatus, channel, nil)
+		s := ts.b.NewStatusUpdate(channel, 10001, status, clog)
+		s.SetExternalID(extID)
+		ts.NoError(ts.b.WriteStatusUpdate(ctx, s))
+		time.Sleep(time.Millisecond * 600)
+		return clog
+	}
+
+	clog1 := writeStatus(courier.MsgStatusWired, "ext1")
+	clog2 := writeStatus(courier.MsgStatusDelivered, "")
+	writeStatus(courier.MsgStatusSent, "") // late so not applied
+	clog3 := writeStatus(courier.MsgStatusRead, "")
+
+	time.Sleep(time.Millisecond * 600) // give dynamo writer time to write
+
+	m := readMsgFromDB(ts.b, 10001)
+	ts.Equal(courier.MsgStatusRead, m.Status_)
+
+	timeline, err := ts.b.MsgStatusTimeline(ctx, m.UUID_)
+	ts.NoError(err)
+	if ts.Len(timeline, 3) {
+		ts.Equal(courier.MsgStatusWired, timeline[0].Status)
+		ts.Equal("ext1", timeline[0].ExternalID)
+		ts.Equal(clog1.UUID, timeline[0].LogUUID)
+		ts.Equal(courier.MsgStatusDelivered, timeline[1].Status)
+		ts.Equal("", timeline[1].ExternalID)
+		ts.Equal(clog2.UUID, timeline[1].LogUUID)
+		ts.Equal(courier.MsgStatusRead, timeline[2].Status)
+		ts.Equal(clog3.UUID, timeline[2].LogUUID)
+		ts.True(timeline[1].CreatedOn.After(timeline[0].CreatedOn))
+	}
+
+	// updates for the same msg in the same batch are coalesced before they're applied, but every valid one is recorded
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'Q', sent_on = NULL WHERE id = 10001`)
+
+	newStatus := func(status courier.MsgStatus) *StatusUpdate {
+		clog := courier.NewChannelLog(courier.ChannelLogTypeMsgStatus, channel, nil)
+		return ts.b.NewStatusUpdate(channel, 10001, status, clog).(*StatusUpdate)
+	}
+	batch := []*StatusUpdate{newStatus(courier.MsgStatusWired), newStatus(courier.MsgStatusSent), newStatus(courier.MsgStatusDelivered), newStatus(courier.MsgStatusWired)}
+	_, err = ts.b.writeStatusUpdatesToDB(ctx, batch)
+	ts.NoError(err)
+
+	time.Sleep(time.Millisecond * 600) // give dynamo writer time to write
+
+	m = readMsgFromDB(ts.b, 10001)
+	ts.Equal(courier.MsgStatusDelivered, m.Status_)
+
+	timeline, err = ts.b.MsgStatusTimeline(ctx, m.UUID_)
+	ts.NoError(err)
+	if ts.Len(timeline, 6) {
+		ts.Equal(courier.MsgStatusWired, timeline[3].Status)
+		ts.Equal(batch[0].LogUUID, timeline[3].LogUUID)
+		ts.Equal(courier.MsgStatusSent, timeline[4].Status)
+		ts.Equal(courier.MsgStatusDelivered, timeline[5].Status)
+	}
+
+	// if the update a msg is coalesced to is rejected, its other updates are tried again
+	batch = []*StatusUpdate{newStatus(courier.MsgStatusWired), newStatus(courier.MsgStatusFailed), newStatus(courier.MsgStatusRead)}
+	_, err = ts.b.writeStatusUpdatesToDB(ctx, batch)
+	ts.NoError(err)
+
+	time.Sleep(time.Millisecond * 600) // give dynamo writer time to write
+
+	m = readMsgFromDB(ts.b, 10001)
+	ts.Equal(courier.MsgStatusRead, m.Status_)
+
+	timeline, err = ts.b.MsgStatusTimeline(ctx, m.UUID_)
+	ts.NoError(err)
+	if ts.Len(timeline, 7) {
+		ts.Equal(courier.MsgStatusRead, timeline[6].Status)
+		ts.Equal(batch[2].LogUUID, timeline[6].LogUUID)
+	}
+
+	// and updates are recorded with the status they were stored as
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'Q', sent_on = NULL, error_count = 2 WHERE id = 10001`)
+
+	batch = []*StatusUpdate{newStatus(courier.MsgStatusErrored)}
+	_, err = ts.b.writeStatusUpdatesToDB(ctx, batch)
+	ts.NoError(err)
+
+	time.Sleep(time.Millisecond * 600) // give dynamo writer time to write
+
+	m = readMsgFromDB(ts.b, 10001)
+	ts.Equal(courier.MsgStatusFailed, m.Status_)
+
+	timeline, err = ts.b.MsgStatusTimeline(ctx, m.UUID_)
+	ts.NoError(err)
+	if ts.Len(timeline, 8) {
+		ts.Equal(courier.MsgStatusFailed, timeline[7].Status)
+		ts.Equal(batch[0].LogUUID, timeline[7].LogUUID)
+	}
+
+	// no timeline for a msg that hasn't had any status updates
+	timeline, err = ts.b.MsgStatusTimeline(ctx, "0199df12-4b1a-7c1c-9d4e-2a7b1f3c9e10")
+	ts.NoError(err)
+	ts.Len(timeline, 0)
+}
+
+func (ts *BackendTestSuite) TestContactRefresh() {
+	ctx := context.Background()
+	channel := ts.getChannel("TG", "dbc126ed-66bc-4e28-b67b-81dc3327c98a")
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgReceive, channel, nil)
+	urn := urns.URN("telegram:56789")
+
+	ts.clearValkey()
+	defer func() { ts.b.config.ContactRefreshDays = 0 }()
+
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	contact, err := contactForURN(ctx, ts.b, channel.OrgID_, channel, urn, nil, "Bob", i18n.NilLanguage, true, clog)
+	ts.NoError(err)
+
+	assertRefreshed := func(expected bool) {
+		refreshed, err := ts.b.refreshedContacts.IsMember(ctx, rc, string(contact.UUID_))
+		ts.NoError(err)
+		ts.Equal(expected, refreshed)
+	}
+
+	// nothing to do for new contacts
+	ts.b.config.ContactRefreshDays = 7
+	ts.NoError(queueContactRefresh(ctx, ts.b, channel, contact, urn, "Robert"))
+	assertRefreshed(false)
+
+	contact, err = contactForURN(ctx, ts.b, channel.OrgID_, channel, urn, nil, "Robert", i18n.NilLanguage, true, clog)
+	ts.NoError(err)
+	ts.False(contact.IsNew_)
+
+	// or if refreshing isn't enabled
+	ts.b.config.ContactRefreshDays = 0
+	ts.NoError(queueContactRefresh(ctx, ts.b, channel, contact, urn, "Robert"))
+	assertRefreshed(false)
+
+	// otherwise the contact is refreshed in the background
+	ts.b.config.ContactRefreshDays = 7
+	ts.NoError(queueContactRefresh(ctx, ts.b, channel, contact, urn, "Robert"))
+	assertRefreshed(true)
+
+	time.Sleep(time.Millisecond * 100)
+
+	assertdb.Query(ts.T(), ts.b.db, `SELECT name FROM contacts_contact WHERE id = $1`, contact.ID_).Returns("Robert")
+
+	// but only once within that number of days
+	ts.NoError(queueContactRefresh(ctx, ts.b, channel, contact, urn, "Bobby"))
+
+	time.Sleep(time.Millisecond * 100)
+
+	assertdb.Query(ts.T(), ts.b.db, `SELECT name FROM contacts_contact WHERE id = $1`, contact.ID_).Returns("Robert")
+
+	// channel events also refresh contacts
+	ts.clearValkey()
+
+	event := ts.b.NewChannelEvent(channel, courier.EventTypeNewConversation, urn, clog).WithContactName("Bobby")
+	ts.NoError(ts.b.WriteChannelEvent(ctx, event, clog))
+	assertRefreshed(true)
+
+	time.Sleep(time.Millisecond * 100)
+
+	assertdb.Query(ts.T(), ts.b.db, `SELECT name FROM contacts_contact WHERE id = $1`, contact.ID_).Returns("Bobby")
+
+	// other attributes are saved as the language, URN display and text contact fields
+	err = updateContactAttrs(ctx, ts.b, contact, map[string]string{
+		"language":   "fra",
+		"username":   "bobby",
+		"avatar_url": "https://example.com/bob.jpg",
+		"timezone":   "Africa/Kigali", // inactive field
+		"age":        "33",            // numeric field
+		"nickname":   "B",             // no such field
+	})
+	ts.NoError(err)
+
+	assertdb.Query(ts.T(), ts.b.db, `SELECT language, fields::text FROM contacts_contact WHERE id = $1`, contact.ID_).
+		Columns(map[string]any{"language": "fra", "fields": `{"3b8d8e8e-6b34-4f4c-a1d4-2b6e1e4ffb8a": {"text": "https://example.com/bob.jpg"}}`})
+	assertdb.Query(ts.T(), ts.b.db, `SELECT display FROM contacts_contacturn WHERE id = $1`, contact.URNID_).Returns("bobby")
+
+	// language isn't changed once set, and invalid languages are ignored
+	ts.NoError(updateContactAttrs(ctx, ts.b, contact, map[string]string{"language": "spa"}))
+	ts.NoError(updateContactAttrs(ctx, ts.b, contact, map[string]string{"language": "xx"}))
+	assertdb.Query(ts.T(), ts.b.db, `SELECT language FROM contacts_contact WHERE id = $1`, contact.ID_).Returns("fra")
+
+	// names and usernames are truncated to the same length, and changing only the URN display still modifies the contact
+	ts.b.db.MustExec(`UPDATE contacts_contact SET modified_on = '2020-01-01T00:00:00Z' WHERE id = $1`, contact.ID_)
+
+	longName := strings.Repeat("x", 200)
+	ts.NoError(updateContactAttrs(ctx, ts.b, contact, map[string]string{"username": longName}))
+	assertdb.Query(ts.T(), ts.b.db, `SELECT display FROM contacts_contacturn WHERE id = $1`, contact.URNID_).Returns(longName[:128])
+	assertdb.Query(ts.T(), ts.b.db, `SELECT count(*) FROM contacts_contact WHERE id = $1 AND modified_on > '2020-01-01T00:00:00Z'`, contact.ID_).Returns(1)
+
+	ts.NoError(updateContactAttrs(ctx, ts.b, contact, map[string]string{"name": longName}))
+	assertdb.Query(ts.T(), ts.b.db, `SELECT name FROM contacts_contact WHERE id = $1`, contact.ID_).Returns(longName[:128])
+
+	// but describing it with the same attributes again doesn't
+	ts.b.db.MustExec(`UPDATE contacts_contact SET modified_on = '2020-01-01T00:00:00Z' WHERE id = $1`, contact.ID_)
+
+	ts.NoError(updateContactAttrs(ctx, ts.b, contact, map[string]string{"name": longName, "username": longName}))
+	assertdb.Query(ts.T(), ts.b.db, `SELECT count(*) FROM contacts_contact WHERE id = $1 AND modified_on > '2020-01-01T00:00:00Z'`, contact.ID_).Returns(0)
+}
+
 func (ts *BackendTestSuite) TestHealth() {
 	// all should be well in test land
 	ts.Equal(ts.b.Health(), "")
@@ -872,7 +1314,13 @@ func (ts *BackendTestSuite) TestStatus() {
 	ts.NoError(err)
 
 	// status should now contain that channel
-	ts.True(strings.Contains(ts.b.Status(), "1           0         0    10     KN   dbc126ed-66bc-4e28-b67b-81dc3327c95d"), ts.b.Status())
+	ts.True(strings.Contains(ts.b.Status(), "1           0         0    10     -     KN   dbc126ed-66bc-4e28-b67b-81dc3327c95d"), ts.b.Status())
+
+	// and should include any concurrency limit for that channel type
+	ts.b.config.MaxInFlightByType = "KN:5"
+	defer func() { ts.b.config.MaxInFlightByType = "" }()
+
+	ts.True(strings.Contains(ts.b.Status(), "1           0         0    10     5     KN   dbc126ed-66bc-4e28-b67b-81dc3327c95d"), ts.b.Status())
 }
 
 func (ts *BackendTestSuite) TestOutgoingQueue() {
@@ -892,11 +1340,18 @@ func (ts *BackendTestSuite) TestOutgoingQueue() {
 	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
 	ts.NoError(err)
 
+	ts.b.stats.Extract()
+
 	// pop a message off our queue
 	msg, err := ts.b.PopNextOutgoingMsg(ctx)
 	ts.NoError(err)
 	ts.NotNil(msg)
 
+	// its wait in the queue should have been recorded
+	stats := ts.b.stats.Extract()
+	ts.Equal(CountByType{"KN": 1}, stats.QueuePops)
+	ts.Less(stats.QueueWait["KN"], time.Second)
+
 	clog := courier.NewChannelLog(courier.ChannelLogTypeUnknown, msg.Channel(), nil)
 
 	// make sure it is the message we just added
@@ -934,6 +1389,587 @@ func (ts *BackendTestSuite) TestOutgoingQueue() {
 	ts.False(sent)
 }
 
+func (ts *BackendTestSuite) TestChannelTPS() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	ts.b.db.MustExec(`UPDATE channels_channel SET config = config || '{"tps": 5}'::jsonb WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`)
+	defer ts.b.db.MustExec(`UPDATE channels_channel SET config = config - 'tps' WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`)
+
+	// loading a channel applies its configured TPS to its queue
+	_, err := ts.b.loadChannelByUUID(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	assertvk.HGetAll(ts.T(), rc, "msgs:tps", map[string]string{"dbc126ed-66bc-4e28-b67b-81dc3327c95d": "5"})
+
+	// as does updating its queue, even if something else has changed it since
+	_, err = rc.Do("HDEL", "msgs:tps", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+
+	ch, err := ts.b.UpdateChannelQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	ts.Equal(courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d"), ch.UUID())
+	ts.Equal(5, ch.IntConfigForKey(courier.ConfigTPS, 0))
+	assertvk.HGetAll(ts.T(), rc, "msgs:tps", map[string]string{"dbc126ed-66bc-4e28-b67b-81dc3327c95d": "5"})
+
+	// and replaces the cached channel
+	cached, err := ts.b.GetChannel(ctx, courier.AnyChannelType, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	ts.Equal(5, cached.IntConfigForKey(courier.ConfigTPS, 0))
+
+	// removing the config and updating again clears the TPS on its queue
+	ts.b.db.MustExec(`UPDATE channels_channel SET config = config - 'tps' WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`)
+
+	_, err = ts.b.UpdateChannelQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	assertvk.HGetAll(ts.T(), rc, "msgs:tps", map[string]string{})
+
+	// can't update the queue of a channel which doesn't exist
+	_, err = ts.b.UpdateChannelQueue(ctx, "8a3e3b6e-bba6-4c52-9c4d-a3a0c7c0b4a1")
+	ts.ErrorIs(err, courier.ErrChannelNotFound)
+}
+
+func (ts *BackendTestSuite) TestMigrateQueueTPS() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	ts.b.db.MustExec(`UPDATE channels_channel SET config = config || '{"tps": 5}'::jsonb WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`)
+	defer ts.b.db.MustExec(`UPDATE channels_channel SET config = config - 'tps' WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`)
+
+	// split the queues of a channel with a TPS, a channel without and a channel which doesn't exist
+	for _, uuid := range []string{"dbc126ed-66bc-4e28-b67b-81dc3327c95d", "dbc126ed-66bc-4e28-b67b-81dc3327c96a", "8a3e3b6e-bba6-4c52-9c4d-a3a0c7c0b4a1"} {
+		ts.NoError(queue.PushOntoQueue(rc, msgQueueName, uuid, 10, `[{"id":1}]`, queue.HighPriority))
+		time.Sleep(time.Millisecond)
+		ts.NoError(queue.PushOntoQueue(rc, msgQueueName, uuid, 3, `[{"id":2}]`, queue.HighPriority))
+	}
+
+	// migrating them replaces the TPS they're given with that of their channels rather than leaving it behind
+	migrated, err := ts.b.migrateQueueTPS(ctx)
+	ts.NoError(err)
+	ts.Equal(3, migrated)
+	assertvk.HGetAll(ts.T(), rc, "msgs:tps", map[string]string{"dbc126ed-66bc-4e28-b67b-81dc3327c95d": "5"})
+	assertvk.Exists(ts.T(), rc, "msgs:tps:migrated")
+
+	// and only happens once
+	migrated, err = ts.b.migrateQueueTPS(ctx)
+	ts.NoError(err)
+	ts.Equal(0, migrated)
+}
+
+func (ts *BackendTestSuite) TestChannelRateLimits() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+	ts.b.channelsByUUID.Clear()
+	defer ts.b.channelsByUUID.Clear()
+
+	ts.b.db.MustExec(`UPDATE channels_channel SET config = config || '{"rate_limits": "30/s+10,1000/d"}'::jsonb WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`)
+	defer ts.b.db.MustExec(`UPDATE channels_channel SET config = config - 'rate_limits' WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`)
+
+	// getting the channel for the first time loads it and applies its limits to its queue
+	_, err := ts.b.GetChannel(ctx, courier.AnyChannelType, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	assertvk.HGetAll(ts.T(), rc, "msgs:limits", map[string]string{
+		"dbc126ed-66bc-4e28-b67b-81dc3327c95d": `[{"count":30,"window":1,"burst":10},{"count":1000,"window":86400,"burst":0}]`,
+	})
+
+	// changing the config and reloading the channel, as happens when its cache entry expires, applies the new limits
+	ts.b.db.MustExec(`UPDATE channels_channel SET config = config || '{"rate_limits": "5/s"}'::jsonb WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`)
+
+	_, err = ts.b.loadChannelByUUID(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	assertvk.HGetAll(ts.T(), rc, "msgs:limits", map[string]string{
+		"dbc126ed-66bc-4e28-b67b-81dc3327c95d": `[{"count":5,"window":1,"burst":0}]`,
+	})
+
+	// reloading it without changes doesn't apply them again
+	_, err = rc.Do("HDEL", "msgs:limits", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+
+	_, err = ts.b.loadChannelByUUID(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	assertvk.HGetAll(ts.T(), rc, "msgs:limits", map[string]string{})
+
+	// but updating its queue does
+	_, err = ts.b.UpdateChannelQueue(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	assertvk.HGetAll(ts.T(), rc, "msgs:limits", map[string]string{
+		"dbc126ed-66bc-4e28-b67b-81dc3327c95d": `[{"count":5,"window":1,"burst":0}]`,
+	})
+
+	// removing the config and reloading the channel removes the limits
+	ts.b.db.MustExec(`UPDATE channels_channel SET config = config - 'rate_limits' WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`)
+
+	_, err = ts.b.loadChannelByUUID(ctx, "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.NoError(err)
+	assertvk.HGetAll(ts.T(), rc, "msgs:limits", map[string]string{})
+}
+
+func (ts *BackendTestSuite) TestDuplicateMsg() {
+	ctx := context.Background()
+
+	ts.clearValkey()
+
+	msg1 := readMsgFromDB(ts.b, 10000)
+	msg2 := readMsgFromDB(ts.b, 10000)
+	msg2.ID_ = 10001
+	msg2.channel = msg1.channel
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, msg1.Channel(), nil)
+
+	// channel doesn't have a dedup window so content isn't tracked
+	ts.b.OnSendComplete(ctx, msg1, ts.b.NewStatusUpdate(msg1.Channel(), msg1.ID(), courier.MsgStatusWired, clog), clog)
+
+	dup, err := ts.b.WasMsgDuplicate(ctx, msg2)
+	ts.NoError(err)
+	ts.False(dup)
+
+	// give it a window
+	msg1.channel.Config_[courier.ConfigDedupWindow] = 1.0
+
+	dup, err = ts.b.WasMsgDuplicate(ctx, msg2)
+	ts.NoError(err)
+	ts.False(dup)
+
+	// failed sends don't count
+	ts.b.OnSendComplete(ctx, msg1, ts.b.NewStatusUpdate(msg1.Channel(), msg1.ID(), courier.MsgStatusFailed, clog), clog)
+
+	dup, err = ts.b.WasMsgDuplicate(ctx, msg2)
+	ts.NoError(err)
+	ts.False(dup)
+
+	ts.b.OnSendComplete(ctx, msg1, ts.b.NewStatusUpdate(msg1.Channel(), msg1.ID(), courier.MsgStatusWired, clog), clog)
+
+	dup, err = ts.b.WasMsgDuplicate(ctx, msg2)
+	ts.NoError(err)
+	ts.True(dup)
+
+	// but different content isn't a duplicate
+	msg2.Text_ = "something else"
+
+	dup, err = ts.b.WasMsgDuplicate(ctx, msg2)
+	ts.NoError(err)
+	ts.False(dup)
+
+	// and once the window has passed neither is the same content
+	msg2.Text_ = msg1.Text_
+	time.Sleep(time.Millisecond * 1100)
+
+	dup, err = ts.b.WasMsgDuplicate(ctx, msg2)
+	ts.NoError(err)
+	ts.False(dup)
+
+	delete(msg1.channel.Config_, courier.ConfigDedupWindow)
+
+	// duplicates are recorded as failed due to looping
+	status := ts.b.NewStatusUpdate(msg2.Channel(), msg2.ID(), courier.MsgStatusFailed, clog)
+	status.SetFailedReason(courier.MsgFailedReasonLooping)
+	ts.NoError(ts.b.WriteStatusUpdate(ctx, status))
+	time.Sleep(time.Millisecond * 600)
+
+	assertdb.Query(ts.T(), ts.b.db, `SELECT status, failed_reason FROM msgs_msg WHERE id = 10001`).Columns(map[string]any{"status": "F", "failed_reason": "L"})
+}
+
+func (ts *BackendTestSuite) TestRequeueMsg() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	dbMsg := readMsgFromDB(ts.b, 10000)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	msgJSON, err := json.Marshal([]any{dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
+	ts.NoError(err)
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(0, msg.Retries())
+
+	// requeue it to be retried in the future and mark this attempt complete
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, msg.Channel(), nil)
+	ts.NoError(ts.b.RequeueMsg(ctx, msg, time.Second))
+	ts.b.OnSendComplete(ctx, msg, ts.b.NewStatusUpdate(msg.Channel(), msg.ID(), courier.MsgStatusQueued, clog), clog)
+
+	// shouldn't be considered sent
+	sent, err := ts.b.WasMsgSent(ctx, msg.ID())
+	ts.NoError(err)
+	ts.False(sent)
+
+	// and can't be popped yet
+	msg2, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg2)
+
+	time.Sleep(time.Millisecond * 1100)
+
+	msg2, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg2)
+	ts.Equal(msg.ID(), msg2.ID())
+	ts.Equal(1, msg2.Retries())
+	ts.True(msg2.HighPriority())
+}
+
+func (ts *BackendTestSuite) TestOriginPriority() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	ts.NoError(queue.SetOriginPriorities(rc, msgQueueName, map[string]queue.Priority{"ticket": 3, "flow": 2}))
+	defer queue.SetOriginPriorities(rc, msgQueueName, nil)
+
+	flowMsg := readMsgFromDB(ts.b, 10000)
+	flowMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	flowMsg.Origin_ = courier.MsgOriginFlow
+
+	ticketMsg := readMsgFromDB(ts.b, 10001)
+	ticketMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ticketMsg.Origin_ = courier.MsgOriginTicket
+
+	// flow msg is queued before the ticket msg, both as high priority like mailroom would
+	for _, m := range []*Msg{flowMsg, ticketMsg} {
+		msgJSON, err := json.Marshal([]any{m})
+		ts.NoError(err)
+		ts.NoError(queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority))
+	}
+
+	assertvk.ZCard(ts.T(), rc, "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10/1", 2)
+
+	// but each is moved to the priority of its origin when popped so the ticket msg overtakes the flow msg
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg)
+	ts.Equal(ticketMsg.ID(), msg.ID())
+	ts.Equal(queue.Priority(3), msg.(*Msg).workerToken.Priority())
+	ts.Equal(queue.Priority(3), msg.(*Msg).priority)
+
+	// and if returned, stays at that priority
+	ts.NoError(ts.b.ReturnMsg(ctx, msg))
+	assertvk.ZCard(ts.T(), rc, "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10/3", 1)
+	assertvk.ZCard(ts.T(), rc, "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10/2", 1)
+	assertvk.ZCard(ts.T(), rc, "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10/1", 0)
+
+	// as it does if requeued for a retry
+	msg, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg)
+	ts.Equal(ticketMsg.ID(), msg.ID())
+	ts.NoError(ts.b.RequeueMsg(ctx, msg, time.Second))
+	assertvk.ZCard(ts.T(), rc, "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10/3", 1)
+}
+
+func (ts *BackendTestSuite) TestScheduledMsg() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	dbMsg := readMsgFromDB(ts.b, 10000)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	sendAfter := time.Now().Add(time.Second)
+	dbMsg.SendAfter_ = &sendAfter
+
+	msgJSON, err := json.Marshal([]any{dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
+	ts.NoError(err)
+
+	// popping parks the msg until it's due
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+
+	assertvk.ZCard(ts.T(), rc, "msgs:scheduled", 1)
+	assertvk.ZGetAll(ts.T(), rc, "msgs:active", map[string]float64{"msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10": 0})
+
+	time.Sleep(time.Second)
+
+	released, err := queue.ReleaseScheduled(rc, msgQueueName)
+	ts.NoError(err)
+	ts.Equal(1, released)
+
+	msg, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg)
+	ts.Equal(courier.MsgID(10000), msg.ID())
+	ts.True(msg.HighPriority())
+	ts.WithinDuration(sendAfter, *msg.SendAfter(), time.Millisecond)
+}
+
+func (ts *BackendTestSuite) TestSendWindowPause() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	// give our channel a send window which opens in an hour
+	opens := time.Now().UTC().Add(time.Hour).Truncate(time.Minute)
+	window := fmt.Sprintf("%02d:%02d-%02d:%02d", opens.Hour(), opens.Minute(), opens.Add(time.Hour).Hour(), opens.Minute())
+	ts.b.db.MustExec(`UPDATE channels_channel SET config = config || jsonb_build_object('send_window', $1::text, 'timezone', 'UTC') WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`, window)
+	defer ts.b.db.MustExec(`UPDATE channels_channel SET config = config - 'send_window' - 'timezone' WHERE uuid = 'dbc126ed-66bc-4e28-b67b-81dc3327c95d'`)
+	ts.b.channelsByUUID.Clear()
+	defer ts.b.channelsByUUID.Clear()
+
+	dbMsg := readMsgFromDB(ts.b, 10000)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	dbMsg.HighPriority_ = true // whether a msg is bulk depends on the priority it's queued with, not this
+
+	msgJSON, err := json.Marshal([]any{dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.LowPriority)
+	ts.NoError(err)
+
+	// popping a bulk msg puts it back and pauses the channel's bulk msgs until the window opens
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+
+	assertvk.ZCard(ts.T(), rc, "msgs:scheduled", 0)
+	assertvk.ZCard(ts.T(), rc, "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10/0", 1)
+	assertvk.Exists(ts.T(), rc, "rate_limit_bulk:dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	assertvk.NotExists(ts.T(), rc, "rate_limit:dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	ttl, err := redis.Int(rc.Do("TTL", "rate_limit_bulk:dbc126ed-66bc-4e28-b67b-81dc3327c95d"))
+	ts.NoError(err)
+	ts.InDelta(time.Until(opens).Seconds(), ttl, 2)
+
+	// and it isn't popped again while the pause lasts
+	msg, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+	assertvk.ZCard(ts.T(), rc, "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10/0", 1)
+
+	// but a msg queued with a higher priority is sent, even if it isn't flagged as high priority
+	dbMsg2 := readMsgFromDB(ts.b, 10001)
+	dbMsg2.ChannelUUID_ = dbMsg.ChannelUUID_
+	dbMsg2.HighPriority_ = false
+
+	msgJSON, err = json.Marshal([]any{dbMsg2})
+	ts.NoError(err)
+	ts.NoError(queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority))
+
+	msg, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg)
+	ts.Equal(courier.MsgID(10001), msg.ID())
+}
+
+func (ts *BackendTestSuite) TestReturnMsg() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	dbMsg := readMsgFromDB(ts.b, 10000)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	msgJSON, err := json.Marshal([]any{dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority)
+	ts.NoError(err)
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	assertvk.ZGetAll(ts.T(), rc, "msgs:active", map[string]float64{"msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10": 1})
+
+	// return it unsent, which also releases our worker
+	ts.NoError(ts.b.ReturnMsg(ctx, msg))
+	assertvk.ZGetAll(ts.T(), rc, "msgs:active", map[string]float64{"msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10": 0})
+
+	// can be popped again straight away without it counting as a retry
+	msg2, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg2)
+	ts.Equal(msg.ID(), msg2.ID())
+	ts.Equal(0, msg2.Retries())
+	ts.True(msg2.HighPriority())
+}
+
+func (ts *BackendTestSuite) TestURNLock() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	dbMsg1 := readMsgFromDB(ts.b, 10000)
+	dbMsg1.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	dbMsg2 := readMsgFromDB(ts.b, 10001)
+	dbMsg2.ChannelUUID_ = dbMsg1.ChannelUUID_
+	dbMsg2.URN_ = dbMsg1.URN_
+
+	for _, m := range []*Msg{dbMsg1, dbMsg2} {
+		msgJSON, err := json.Marshal([]any{m})
+		ts.NoError(err)
+		ts.NoError(queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority))
+	}
+
+	msg1, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg1)
+	ts.Equal(courier.MsgID(10000), msg1.ID())
+
+	// second msg is to the same URN so has to wait until the first has been sent
+	msg2, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg2)
+	assertvk.LLen(ts.T(), rc, fmt.Sprintf("msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d:urn:%s:waiting", dbMsg1.URN_.Identity()), 1)
+
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, msg1.Channel(), nil)
+	ts.b.OnSendComplete(ctx, msg1, ts.b.NewStatusUpdate(msg1.Channel(), msg1.ID(), courier.MsgStatusWired, clog), clog)
+
+	msg2, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg2)
+	ts.Equal(courier.MsgID(10001), msg2.ID())
+
+	ts.b.OnSendComplete(ctx, msg2, ts.b.NewStatusUpdate(msg2.Channel(), msg2.ID(), courier.MsgStatusWired, clog), clog)
+	assertvk.NotExists(ts.T(), rc, fmt.Sprintf("msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d:urn:%s", dbMsg1.URN_.Identity()))
+}
+
+func (ts *BackendTestSuite) TestURNLockRetry() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	dbMsg1 := readMsgFromDB(ts.b, 10000)
+	dbMsg1.ChannelUUID_ = courier.ChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	dbMsg2 := readMsgFromDB(ts.b, 10001)
+	dbMsg2.ChannelUUID_ = dbMsg1.ChannelUUID_
+	dbMsg2.URN_ = dbMsg1.URN_
+
+	for _, m := range []*Msg{dbMsg1, dbMsg2} {
+		msgJSON, err := json.Marshal([]any{m})
+		ts.NoError(err)
+		ts.NoError(queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, string(msgJSON), queue.HighPriority))
+	}
+
+	msg1, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg1)
+	ts.Equal(courier.MsgID(10000), msg1.ID())
+
+	msg2, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg2)
+
+	// first msg fails and is requeued to be retried, which shouldn't let the second msg overtake it
+	clog := courier.NewChannelLog(courier.ChannelLogTypeMsgSend, msg1.Channel(), nil)
+	ts.NoError(ts.b.RequeueMsg(ctx, msg1, 0))
+	ts.b.OnSendComplete(ctx, msg1, ts.b.NewStatusUpdate(msg1.Channel(), msg1.ID(), courier.MsgStatusQueued, clog), clog)
+	assertvk.Get(ts.T(), rc, fmt.Sprintf("msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d:urn:%s", dbMsg1.URN_.Identity()), "handoff:10000")
+
+	msg1, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg1)
+	ts.Equal(courier.MsgID(10000), msg1.ID())
+
+	msg2, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg2)
+
+	// once it's sent, the second msg can be
+	ts.b.OnSendComplete(ctx, msg1, ts.b.NewStatusUpdate(msg1.Channel(), msg1.ID(), courier.MsgStatusWired, clog), clog)
+
+	msg2, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg2)
+	ts.Equal(courier.MsgID(10001), msg2.ID())
+
+	ts.b.OnSendComplete(ctx, msg2, ts.b.NewStatusUpdate(msg2.Channel(), msg2.ID(), courier.MsgStatusWired, clog), clog)
+}
+
+func (ts *BackendTestSuite) TestDeadLetters() {
+	ctx := context.Background()
+	rc := ts.b.rp.Get()
+	defer rc.Close()
+
+	ts.clearValkey()
+
+	// push a msg which can't be unmarshalled and one for a channel which doesn't exist
+	dbMsg := readMsgFromDB(ts.b, 10000)
+	dbMsg.ChannelUUID_ = courier.ChannelUUID("6b6f2a8a-ef5b-4a39-8d68-3e3e3b2c3d7c")
+	msgJSON, err := json.Marshal([]any{dbMsg})
+	ts.NoError(err)
+
+	ts.NoError(queue.PushOntoQueue(rc, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 10, `[{"id": "abc", "high_priority": true}]`, queue.HighPriority))
+	ts.NoError(queue.PushOntoQueue(rc, msgQueueName, "6b6f2a8a-ef5b-4a39-8d68-3e3e3b2c3d7c", 10, string(msgJSON), queue.LowPriority))
+
+	for range 2 {
+		msg, err := ts.b.PopNextOutgoingMsg(ctx)
+		ts.Error(err)
+		ts.Nil(msg)
+	}
+
+	dls, err := ts.b.DeadLetters(ctx)
+	ts.NoError(err)
+	ts.Require().Len(dls, 2)
+
+	byChannel := make(map[courier.ChannelUUID]*courier.DeadLetter)
+	for _, dl := range dls {
+		byChannel[dl.ChannelUUID] = dl
+	}
+	unreadable := byChannel["dbc126ed-66bc-4e28-b67b-81dc3327c95d"]
+	noChannel := byChannel["6b6f2a8a-ef5b-4a39-8d68-3e3e3b2c3d7c"]
+	ts.Require().NotNil(unreadable)
+	ts.Require().NotNil(noChannel)
+	ts.JSONEq(`{"id":"abc","high_priority":true}`, unreadable.Msg)
+	ts.Contains(unreadable.Error, "unable to unmarshal message")
+	ts.Contains(noChannel.Error, "unable to load channel")
+
+	// replay the unreadable one which puts it back on its queue with its original priority
+	replayed, err := ts.b.ReplayDeadLetter(ctx, unreadable.ID)
+	ts.NoError(err)
+	ts.True(replayed)
+	assertvk.ZCard(ts.T(), rc, "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|10/1", 1)
+
+	// and discard the other
+	discarded, err := ts.b.DiscardDeadLetter(ctx, noChannel.ID)
+	ts.NoError(err)
+	ts.True(discarded)
+
+	discarded, err = ts.b.DiscardDeadLetter(ctx, noChannel.ID)
+	ts.NoError(err)
+	ts.False(discarded)
+
+	// msgs whose channel can't be loaded because of a temporary error are put back on their queue instead
+	ts.NoError(queue.PushOntoQueue(rc, msgQueueName, "6b6f2a8a-ef5b-4a39-8d68-3e3e3b2c3d7c", 10, string(msgJSON), queue.LowPriority))
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	msg, skipped, err := ts.b.popOutgoingMsg(cancelledCtx)
+	ts.Error(err)
+	ts.False(skipped)
+	ts.Nil(msg)
+	assertvk.ZCard(ts.T(), rc, "msgs:6b6f2a8a-ef5b-4a39-8d68-3e3e3b2c3d7c|10/0", 1)
+
+	dls, err = ts.b.DeadLetters(ctx)
+	ts.NoError(err)
+	ts.Len(dls, 0)
+}
+
 func (ts *BackendTestSuite) TestChannel() {
 	noAddress := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c99a")
 	ts.Equal(i18n.Country("US"), noAddress.Country())
@@ -1167,7 +2203,7 @@ func (ts *BackendTestSuite) TestWriteMsg() {
 	ts.NoError(err)
 
 	// load our URN
-	contactURN, err := getOrCreateContactURN(tx, m.channel, m.ContactID_, urn, nil)
+	contactURN, err := getOrCreateContactURN(tx, m.channel, m.ContactID_, urn, nil, false)
 	if !ts.NoError(err) || !ts.NoError(tx.Commit()) {
 		ts.FailNow("failed writing contact urn")
 	}
@@ -1191,7 +2227,7 @@ func (ts *BackendTestSuite) TestWriteMsg() {
 	ts.NotNil(m.CreatedOn_)
 	ts.NotNil(m.ModifiedOn_)
 
-	contact, err := contactForURN(ctx, ts.b, m.OrgID_, knChannel, urn, nil, "", true, clog)
+	contact, err := contactForURN(ctx, ts.b, m.OrgID_, knChannel, urn, nil, "", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 	ts.Equal(null.String("test contact"), contact.Name_)
 	ts.Equal(m.OrgID_, contact.OrgID_)
@@ -1304,7 +2340,7 @@ func (ts *BackendTestSuite) TestPreferredChannelCheckRole() {
 	ts.NoError(err)
 
 	// load our URN
-	exContactURN, err := getOrCreateContactURN(tx, m.channel, m.ContactID_, urn, nil)
+	exContactURN, err := getOrCreateContactURN(tx, m.channel, m.ContactID_, urn, nil, false)
 	if !ts.NoError(err) || !ts.NoError(tx.Commit()) {
 		ts.FailNow("failed writing contact urn")
 	}
@@ -1322,7 +2358,7 @@ func (ts *BackendTestSuite) TestChannelEvent() {
 	err := ts.b.WriteChannelEvent(ctx, event, clog)
 	ts.NoError(err)
 
-	contact, err := contactForURN(ctx, ts.b, channel.OrgID_, channel, urn, nil, "", true, clog)
+	contact, err := contactForURN(ctx, ts.b, channel.OrgID_, channel, urn, nil, "", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 	ts.Equal(null.String("kermit frog"), contact.Name_)
 
@@ -1411,7 +2447,7 @@ func (ts *BackendTestSuite) TestMailroomEvents() {
 	err := ts.b.WriteChannelEvent(ctx, event, clog)
 	ts.NoError(err)
 
-	contact, err := contactForURN(ctx, ts.b, channel.OrgID_, channel, urn, nil, "", true, clog)
+	contact, err := contactForURN(ctx, ts.b, channel.OrgID_, channel, urn, nil, "", i18n.NilLanguage, true, clog)
 	ts.NoError(err)
 	ts.Equal(null.String("kermit frog"), contact.Name_)
 	ts.False(contact.IsNew_)
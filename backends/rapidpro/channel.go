@@ -3,11 +3,14 @@ package rapidpro
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/queue"
 	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/null/v3"
@@ -172,8 +175,94 @@ func (b *backend) loadChannelByUUID(ctx context.Context, uuid courier.ChannelUUI
 
 	if err == sql.ErrNoRows {
 		return nil, courier.ErrChannelNotFound
+	} else if err != nil {
+		return nil, err
 	}
-	return channel, err
+
+	// loading happens when a channel is first used and every time its cache entry expires, so config changes to its
+	// TPS and rate limits reach its queue without waiting for them to be pushed again
+	if err := b.syncChannelQueueConfig(channel); err != nil {
+		slog.Error("error applying channel config to queue", "channel_uuid", uuid, "error", err)
+	}
+
+	return channel, nil
+}
+
+// applies the TPS and rate limits configured for the given channel to its queue if they've changed since we last did
+func (b *backend) syncChannelQueueConfig(channel *Channel) error {
+	b.queueConfigsMutex.Lock()
+	applied := b.queueConfigs[channel.UUID()]
+	b.queueConfigsMutex.Unlock()
+
+	if applied == channelQueueConfig(channel) {
+		return nil
+	}
+	return b.setChannelQueueConfig(channel)
+}
+
+// applies the TPS and rate limits configured for the given channel to its queue so that they apply to msgs already
+// queued, clearing them if they've been removed so msgs are sent with the TPS they were queued with
+func (b *backend) setChannelQueueConfig(channel *Channel) error {
+	tps := channel.IntConfigForKey(courier.ConfigTPS, 0)
+	if tps <= 0 {
+		tps = -1
+	}
+	if err := b.msgQueue.SetTPS(string(channel.UUID()), tps); err != nil {
+		return fmt.Errorf("error setting queue TPS: %w", err)
+	}
+
+	limits, err := queue.ParseLimits(channel.StringConfigForKey(courier.ConfigRateLimits, ""))
+	if err != nil {
+		return fmt.Errorf("error parsing channel rate limits: %w", err)
+	}
+	if err := b.msgQueue.SetLimits(string(channel.UUID()), limits); err != nil {
+		return fmt.Errorf("error setting queue rate limits: %w", err)
+	}
+
+	b.queueConfigsMutex.Lock()
+	b.queueConfigs[channel.UUID()] = channelQueueConfig(channel)
+	b.queueConfigsMutex.Unlock()
+	return nil
+}
+
+// gets the config of the given channel which applies to its queue, for checking whether it's changed
+func channelQueueConfig(channel *Channel) string {
+	return fmt.Sprintf("%d|%s", channel.IntConfigForKey(courier.ConfigTPS, 0), channel.StringConfigForKey(courier.ConfigRateLimits, ""))
+}
+
+// gives queues whose msgs were pushed with different TPS a single TPS, and then replaces that with the TPS of their
+// channels so it isn't left behind when they don't have one, or don't exist anymore
+func (b *backend) migrateQueueTPS(ctx context.Context) (int, error) {
+	rc := b.rp.Get()
+	migrated, err := queue.MigrateTPS(rc, msgQueueName)
+	rc.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range migrated {
+		channel, err := b.loadChannelByUUID(ctx, courier.ChannelUUID(name))
+		if err == courier.ErrChannelNotFound {
+			err = b.msgQueue.SetTPS(name, -1)
+		} else if err == nil {
+			// loading it will have applied its config unless that failed, in which case this tries again
+			err = b.syncChannelQueueConfig(channel)
+		}
+		if err != nil {
+			return len(migrated), fmt.Errorf("error replacing migrated TPS of queue %s: %w", name, err)
+		}
+	}
+
+	return len(migrated), nil
+}
+
+// loads the type of the channel with the passed in UUID
+func (b *backend) loadChannelType(ctx context.Context, uuid courier.ChannelUUID) (courier.ChannelType, error) {
+	ch, err := b.GetChannel(ctx, courier.AnyChannelType, uuid)
+	if err != nil {
+		return "", err
+	}
+	return ch.ChannelType(), nil
 }
 
 const sqlLookupChannelFromAddress = `
@@ -13,6 +13,7 @@ import (
 
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/null/v3"
@@ -55,8 +56,9 @@ type ChannelEvent struct {
 	ContactURNID_ ContactURNID `json:"-"               db:"contact_urn_id"`
 
 	// used to update contact
-	ContactName_   string            `json:"contact_name"`
-	URNAuthTokens_ map[string]string `json:"auth_tokens"`
+	ContactName_     string            `json:"contact_name"`
+	ContactLanguage_ i18n.Language     `json:"contact_language,omitempty"`
+	URNAuthTokens_   map[string]string `json:"auth_tokens"`
 
 	channel *Channel
 }
@@ -95,6 +97,11 @@ func (e *ChannelEvent) WithContactName(name string) courier.ChannelEvent {
 	return e
 }
 
+func (e *ChannelEvent) WithContactLanguage(locale string) courier.ChannelEvent {
+	e.ContactLanguage_ = courier.ParseContactLanguage(locale)
+	return e
+}
+
 func (e *ChannelEvent) WithURNAuthTokens(tokens map[string]string) courier.ChannelEvent {
 	e.URNAuthTokens_ = tokens
 	return e
@@ -145,11 +152,16 @@ RETURNING id, created_on`
 // writeChannelEventToDB writes the passed in channel event to our db
 func writeChannelEventToDB(ctx context.Context, b *backend, e *ChannelEvent, clog *courier.ChannelLog) error {
 	// grab the contact for this event
-	contact, err := contactForURN(ctx, b, e.OrgID_, e.channel, e.URN_, e.URNAuthTokens_, e.ContactName_, true, clog)
+	contact, err := contactForURN(ctx, b, e.OrgID_, e.channel, e.URN_, e.URNAuthTokens_, e.ContactName_, e.ContactLanguage_, true, clog)
 	if err != nil {
 		return err
 	}
 
+	// an existing contact may be due to have its name and other attributes refreshed
+	if err := queueContactRefresh(ctx, b, e.channel, contact, e.URN_, e.ContactName_); err != nil {
+		slog.Error("error refreshing contact", "error", err, "contact_uuid", contact.UUID_)
+	}
+
 	// set our contact and urn id
 	e.ContactID_ = contact.ID_
 	e.ContactURNID_ = contact.URNID_
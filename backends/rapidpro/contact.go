@@ -6,13 +6,17 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"log/slog"
+	"maps"
 	"strconv"
 	"time"
 	"unicode/utf8"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/gocommon/dbutil"
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/null/v3"
@@ -21,6 +25,17 @@ import (
 // used by unit tests to slow down urn operations to test races
 var urnSleep bool
 
+// the maximum length of contact names and URN displays, which are both limited to this by their columns
+const maxNameLength = 128
+
+// truncates the given contact name or URN display to the maximum length its column allows
+func truncateName(name string) string {
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return string([]rune(name)[:maxNameLength])
+	}
+	return name
+}
+
 // ContactID is our representation of our database contact id
 type ContactID null.Int
 
@@ -47,6 +62,8 @@ type Contact struct {
 	UUID_  courier.ContactUUID `db:"uuid"`
 	Name_  null.String         `db:"name"`
 
+	Language_ i18n.Language `db:"language"`
+
 	URNID_ ContactURNID `db:"urn_id"`
 
 	CreatedOn_  time.Time `db:"created_on"`
@@ -64,8 +81,8 @@ func (c *Contact) UUID() courier.ContactUUID { return c.UUID_ }
 
 const sqlInsertContact = `
 INSERT INTO 
-	contacts_contact(org_id, is_active, status, uuid, created_on, modified_on, created_by_id, modified_by_id, name, ticket_count) 
-              VALUES(:org_id, TRUE, 'A', :uuid, :created_on, :modified_on, :created_by_id, :modified_by_id, :name, 0)
+	contacts_contact(org_id, is_active, status, uuid, created_on, modified_on, created_by_id, modified_by_id, name, language, ticket_count) 
+              VALUES(:org_id, TRUE, 'A', :uuid, :created_on, :modified_on, :created_by_id, :modified_by_id, :name, :language, 0)
 RETURNING id
 `
 
@@ -102,8 +119,9 @@ WHERE
 	c.is_active = TRUE
 `
 
-// contactForURN first tries to look up a contact for the passed in URN, if not finding one then creating one
-func contactForURN(ctx context.Context, b *backend, org OrgID, channel *Channel, urn urns.URN, authTokens map[string]string, name string, allowCreate bool, clog *courier.ChannelLog) (*Contact, error) {
+// contactForURN first tries to look up a contact for the passed in URN, if not finding one then creating one with the
+// passed in name and language
+func contactForURN(ctx context.Context, b *backend, org OrgID, channel *Channel, urn urns.URN, authTokens map[string]string, name string, lang i18n.Language, allowCreate bool, clog *courier.ChannelLog) (*Contact, error) {
 	log := slog.With("org_id", org, "urn", urn.Identity(), "channel_uuid", channel.UUID(), "log_uuid", clog.UUID)
 
 	// try to look up our contact by URN
@@ -123,7 +141,7 @@ func contactForURN(ctx context.Context, b *backend, org OrgID, channel *Channel,
 		}
 
 		// update contact's URNs so this URN has priority
-		err = setDefaultURN(tx, channel, contact, urn, authTokens)
+		err = setDefaultURN(tx, channel, contact, urn, authTokens, b.config.KeepURNDisplays)
 		if err != nil {
 			log.Error("error updating default URN for contact", "error", err)
 			tx.Rollback()
@@ -143,34 +161,27 @@ func contactForURN(ctx context.Context, b *backend, org OrgID, channel *Channel,
 	contact.CreatedBy_ = b.systemUserID
 	contact.ModifiedOn_ = time.Now()
 	contact.ModifiedBy_ = b.systemUserID
+	contact.Language_ = lang
 	contact.IsNew_ = true
 
+	var attrs map[string]string
+
 	// if we aren't an anonymous org, we want to look up a name if possible and set it
 	if !channel.OrgIsAnon() {
 		// no name was passed in, see if our handler can look up information for this URN
 		if name == "" {
-			handler := courier.GetHandler(channel.ChannelType())
-			if handler != nil {
-				describer, isDescriber := handler.(courier.URNDescriber)
-				if isDescriber {
-					attrs, err := describer.DescribeURN(ctx, channel, urn, clog)
-
-					// in the case of errors, we log the error but move onwards anyways
-					if err != nil {
-						log.Error("unable to describe URN", "error", err)
-					} else {
-						name = attrs["name"]
-					}
-				}
+			attrs, err = describeURN(ctx, channel, urn, clog)
+
+			// in the case of errors, we log the error but move onwards anyways
+			if err != nil {
+				log.Error("unable to describe URN", "error", err)
+			} else {
+				name = attrs[courier.URNAttrName]
 			}
 		}
 
 		if name != "" {
-			if utf8.RuneCountInString(name) > 128 {
-				name = string([]rune(name)[:127])
-			}
-
-			contact.Name_ = null.String(dbutil.ToValidUTF8(name))
+			contact.Name_ = null.String(dbutil.ToValidUTF8(truncateName(name)))
 		}
 	}
 
@@ -194,13 +205,13 @@ func contactForURN(ctx context.Context, b *backend, org OrgID, channel *Channel,
 	// associate our URN
 	// If we've inserted a duplicate URN then we'll get a uniqueness violation.
 	// That means this contact URN was written by someone else after we tried to look it up.
-	contactURN, err := getOrCreateContactURN(tx, channel, contact.ID_, urn, authTokens)
+	contactURN, err := getOrCreateContactURN(tx, channel, contact.ID_, urn, authTokens, b.config.KeepURNDisplays)
 	if err != nil {
 		tx.Rollback()
 
 		if dbutil.IsUniqueViolation(err) {
 			// if this was a duplicate URN, start over with a contact lookup
-			return contactForURN(ctx, b, org, channel, urn, authTokens, name, true, clog)
+			return contactForURN(ctx, b, org, channel, urn, authTokens, name, lang, true, clog)
 		}
 		return nil, fmt.Errorf("error getting URN for contact: %w", err)
 	}
@@ -208,7 +219,7 @@ func contactForURN(ctx context.Context, b *backend, org OrgID, channel *Channel,
 	// we stole the URN from another contact, roll back and start over
 	if contactURN.PrevContactID != NilContactID {
 		tx.Rollback()
-		return contactForURN(ctx, b, org, channel, urn, authTokens, name, true, clog)
+		return contactForURN(ctx, b, org, channel, urn, authTokens, name, lang, true, clog)
 	}
 
 	// all is well, we created the new contact, commit and move forward
@@ -222,5 +233,178 @@ func contactForURN(ctx context.Context, b *backend, org OrgID, channel *Channel,
 
 	b.stats.RecordContactCreated()
 
+	// save any other attributes our handler described the URN with
+	if len(attrs) > 0 {
+		if err := updateContactAttrs(ctx, b, contact, attrs); err != nil {
+			log.Error("error saving contact attributes", "error", err)
+		}
+	}
+
 	return contact, nil
 }
+
+// describes the URN using the handler for the channel if it's a URN describer, otherwise returns no attributes
+func describeURN(ctx context.Context, channel *Channel, urn urns.URN, clog *courier.ChannelLog) (map[string]string, error) {
+	if describer, isDescriber := courier.GetHandler(channel.ChannelType()).(courier.URNDescriber); isDescriber {
+		return describer.DescribeURN(ctx, channel, urn, clog)
+	}
+	return nil, nil
+}
+
+// queueContactRefresh starts refreshing an existing contact in the background if that hasn't been done within the
+// configured number of days, so that describing its URN doesn't hold up the request
+func queueContactRefresh(ctx context.Context, b *backend, channel *Channel, contact *Contact, urn urns.URN, name string) error {
+	if b.config.ContactRefreshDays <= 0 || contact.IsNew_ || channel.OrgIsAnon() {
+		return nil
+	}
+
+	rc := b.rp.Get()
+	defer rc.Close()
+
+	refreshed, err := b.refreshedContacts.IsMember(ctx, rc, string(contact.UUID_))
+	if err != nil {
+		return fmt.Errorf("error checking if contact was refreshed: %w", err)
+	}
+	if refreshed {
+		return nil
+	}
+
+	// record the refresh first so that a URN we can't describe isn't tried again for every msg
+	if err := b.refreshedContacts.Add(ctx, rc, string(contact.UUID_)); err != nil {
+		return fmt.Errorf("error recording contact refresh: %w", err)
+	}
+
+	c := *contact
+
+	b.waitGroup.Add(1)
+	go func() {
+		defer b.waitGroup.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		if err := refreshContact(ctx, b, channel, &c, urn, name); err != nil {
+			slog.Error("error refreshing contact", "error", err, "contact_uuid", c.UUID_)
+		}
+	}()
+
+	return nil
+}
+
+// refreshContact describes the URN of an existing contact again, if its channel can describe URNs, and updates the
+// contact with its name and other attributes
+func refreshContact(ctx context.Context, b *backend, channel *Channel, contact *Contact, urn urns.URN, name string) error {
+	attrs := make(map[string]string, 1)
+
+	handler := courier.GetHandler(channel.ChannelType())
+
+	// only log describing the URN for channels which can
+	if describer, isDescriber := handler.(courier.URNDescriber); isDescriber {
+		clog := courier.NewChannelLog(courier.ChannelLogTypeContactRefresh, channel, handler.RedactValues(channel))
+
+		described, err := describer.DescribeURN(ctx, channel, urn, clog)
+		b.WriteChannelLog(ctx, clog)
+
+		if err != nil {
+			return fmt.Errorf("unable to describe URN: %w", err)
+		}
+		maps.Copy(attrs, described)
+	}
+
+	// a name passed in by the handler is as good as a described one
+	if attrs[courier.URNAttrName] == "" && name != "" {
+		attrs[courier.URNAttrName] = name
+	}
+
+	return updateContactAttrs(ctx, b, contact, attrs)
+}
+
+const sqlSelectContactFields = `
+SELECT key, uuid
+  FROM contacts_contactfield
+ WHERE org_id = $1 AND key = ANY($2) AND value_type = 'T' AND is_active = TRUE`
+
+const sqlUpdateContactAttrs = `
+UPDATE contacts_contact
+   SET name = COALESCE($2, name), language = COALESCE(language, $3), fields = COALESCE(fields, '{}'::jsonb) || $4::jsonb, modified_on = NOW()
+ WHERE id = $1`
+
+const sqlUpdateURNDisplay = `
+UPDATE contacts_contacturn
+   SET display = $2
+ WHERE id = $1 AND display IS DISTINCT FROM $2`
+
+// updateContactAttrs updates a contact with the attributes its URN was described with. The name replaces the contact's
+// name, the language is only set if the contact doesn't have one, the username is saved as the display of the URN,
+// and any other attributes are saved as the values of the org's text contact fields with the same keys.
+//
+// These are written directly rather than via mailroom, like the names of contacts we create, so that describing URNs
+// doesn't depend on mailroom. This means that changes to fields don't trigger anything in mailroom, such as campaign
+// events, but we bump the contact's modified_on so that anything which syncs contacts by that picks them up.
+func updateContactAttrs(ctx context.Context, b *backend, contact *Contact, attrs map[string]string) error {
+	var name, lang null.String
+	var displayChanged bool
+	fieldKeys := make([]string, 0, len(attrs))
+
+	for key, value := range attrs {
+		if value == "" {
+			continue
+		}
+
+		switch key {
+		case courier.URNAttrName:
+			if value = dbutil.ToValidUTF8(truncateName(value)); value != string(contact.Name_) {
+				name = null.String(value)
+			}
+		case courier.URNAttrLanguage:
+			if l, err := i18n.ParseLanguage(value); err == nil {
+				lang = null.String(l)
+			}
+		case courier.URNAttrUsername:
+			res, err := b.db.ExecContext(ctx, sqlUpdateURNDisplay, contact.URNID_, dbutil.ToValidUTF8(truncateName(value)))
+			if err != nil {
+				return fmt.Errorf("error updating URN display: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				displayChanged = true
+			}
+		default:
+			fieldKeys = append(fieldKeys, key)
+		}
+	}
+
+	fields := make(map[string]map[string]string, len(fieldKeys))
+	if len(fieldKeys) > 0 {
+		rows, err := b.db.QueryContext(ctx, sqlSelectContactFields, contact.OrgID_, pq.Array(fieldKeys))
+		if err != nil {
+			return fmt.Errorf("error looking up contact fields: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var key, uuid string
+			if err := rows.Scan(&key, &uuid); err != nil {
+				return fmt.Errorf("error scanning contact field: %w", err)
+			}
+			fields[uuid] = map[string]string{"text": dbutil.ToValidUTF8(attrs[key])}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error looking up contact fields: %w", err)
+		}
+	}
+
+	// nothing to update, though a changed URN display still counts as a change to the contact
+	if name == "" && lang == "" && len(fields) == 0 && !displayChanged {
+		return nil
+	}
+
+	if _, err := b.db.ExecContext(ctx, sqlUpdateContactAttrs, contact.ID_, name, lang, jsonx.MustMarshal(fields)); err != nil {
+		return fmt.Errorf("error updating contact: %w", err)
+	}
+
+	if name != "" {
+		contact.Name_ = name
+	}
+
+	return nil
+}
@@ -10,9 +10,11 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/buger/jsonparser"
 	filetype "github.com/h2non/filetype"
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
@@ -23,6 +25,9 @@ import (
 	"github.com/nyaruka/null/v3"
 )
 
+// how long a msg can hold the lock on sending to its URN, which should be longer than it takes to send it
+const urnLockLease = 2 * time.Minute
+
 // MsgDirection is the direction of a message
 type MsgDirection string
 
@@ -83,12 +88,19 @@ type Msg struct {
 	Origin_               courier.MsgOrigin       `json:"origin"`
 	ContactLastSeenOn_    *time.Time              `json:"contact_last_seen_on"`
 	Session_              *courier.Session        `json:"session"`
-
-	ContactName_   string            `json:"contact_name"`
-	URNAuthTokens_ map[string]string `json:"auth_tokens"`
-	channel        *Channel
-	workerToken    queue.WorkerToken
-	alreadyWritten bool
+	Retries_              int                     `json:"retry_count"`
+	SendAfter_            *time.Time              `json:"send_after"`
+	ExpiresOn_            *time.Time              `json:"expires_on"`
+
+	ContactName_     string            `json:"contact_name"`
+	ContactLanguage_ i18n.Language     `json:"contact_language,omitempty"`
+	URNAuthTokens_   map[string]string `json:"auth_tokens"`
+	channel          *Channel
+	workerToken      queue.WorkerToken
+	priority         queue.Priority // the priority this msg should be queued with
+	urnLock          string         // token of our lock on sending to our URN
+	payload          []byte         // the JSON this msg was popped from the queue as
+	alreadyWritten   bool
 }
 
 // newMsg creates a new DBMsg object with the passed in parameters
@@ -141,6 +153,9 @@ func (m *Msg) ContactLastSeenOn() *time.Time      { return m.ContactLastSeenOn_
 func (m *Msg) ResponseToExternalID() string       { return m.ResponseToExternalID_ }
 func (m *Msg) SentOn() *time.Time                 { return m.SentOn_ }
 func (m *Msg) IsResend() bool                     { return m.IsResend_ }
+func (m *Msg) Retries() int                       { return m.Retries_ }
+func (m *Msg) SendAfter() *time.Time              { return m.SendAfter_ }
+func (m *Msg) ExpiresOn() *time.Time              { return m.ExpiresOn_ }
 func (m *Msg) Flow() *courier.FlowReference       { return m.Flow_ }
 func (m *Msg) OptIn() *courier.OptInReference     { return m.OptIn_ }
 func (m *Msg) UserID() courier.UserID             { return m.UserID_ }
@@ -154,6 +169,10 @@ func (m *Msg) WithAttachment(url string) courier.MsgIn {
 	return m
 }
 func (m *Msg) WithContactName(name string) courier.MsgIn { m.ContactName_ = name; return m }
+func (m *Msg) WithContactLanguage(locale string) courier.MsgIn {
+	m.ContactLanguage_ = courier.ParseContactLanguage(locale)
+	return m
+}
 func (m *Msg) WithURNAuthTokens(tokens map[string]string) courier.MsgIn {
 	m.URNAuthTokens_ = tokens
 	return m
@@ -165,6 +184,24 @@ func (m *Msg) hash() string {
 	return hex.EncodeToString(hash[:])
 }
 
+// msgContentFingerprint returns the key used to dedup sends of the same content to the same URN
+func msgContentFingerprint(m *Msg) string {
+	return fmt.Sprintf("%s|%s|%s", m.Channel().UUID(), m.URN().Identity(), m.hash())
+}
+
+// msgDedupWindow returns the window within which sends of the same content to the same URN are suppressed, which is
+// limited by how long we keep track of sent content
+func msgDedupWindow(m courier.MsgOut) time.Duration {
+	window := time.Duration(m.Channel().IntConfigForKey(courier.ConfigDedupWindow, 0)) * time.Second
+	return min(max(window, 0), time.Hour)
+}
+
+// gets the minimum time between sends to the same URN for the given msg's channel
+func msgSendGap(m courier.MsgOut) time.Duration {
+	gap := time.Duration(m.Channel().IntConfigForKey(courier.ConfigURNSendGap, 0)) * time.Second
+	return min(max(gap, 0), time.Hour)
+}
+
 // WriteMsg creates a message given the passed in arguments
 func writeMsg(ctx context.Context, b *backend, m *Msg, clog *courier.ChannelLog) error {
 	// this msg has already been written (we received it twice), we are a no op
@@ -234,13 +271,18 @@ INSERT INTO
 RETURNING id`
 
 func writeMsgToDB(ctx context.Context, b *backend, m *Msg, clog *courier.ChannelLog) (*Contact, error) {
-	contact, err := contactForURN(ctx, b, m.OrgID_, m.channel, m.URN_, m.URNAuthTokens_, m.ContactName_, true, clog)
+	contact, err := contactForURN(ctx, b, m.OrgID_, m.channel, m.URN_, m.URNAuthTokens_, m.ContactName_, m.ContactLanguage_, true, clog)
 
 	if err != nil {
 		// our db is down, write to the spool, we will write/queue this later
 		return nil, fmt.Errorf("error getting contact for message: %w", err)
 	}
 
+	// an existing contact may be due to have its name and other attributes refreshed
+	if err := queueContactRefresh(ctx, b, m.channel, contact, m.URN_, m.ContactName_); err != nil {
+		slog.Error("error refreshing contact", "error", err, "contact_uuid", contact.UUID_)
+	}
+
 	// set our contact and urn id
 	m.ContactID_ = contact.ID_
 	m.ContactURNID_ = contact.URNID_
@@ -260,6 +302,27 @@ func writeMsgToDB(ctx context.Context, b *backend, m *Msg, clog *courier.Channel
 	return contact, nil
 }
 
+// hands the lock on the URN of the given msg, if it was locked, back to the msg itself because it's been put back on
+// the queue to be sent after the given delay, so that the msgs in line behind it stay there
+func holdMsgURN(q queue.Queue, m *Msg, delay time.Duration) error {
+	if m.urnLock == "" {
+		return nil
+	}
+
+	queueName, _, err := parseWorkerToken(m.workerToken)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.HoldURN(queueName, m.URN().Identity().String(), m.urnLock, m.ID().String(), delay); err != nil {
+		return err
+	}
+
+	// we no longer hold the lock so there's nothing to unlock when the send completes
+	m.urnLock = ""
+	return nil
+}
+
 //-----------------------------------------------------------------------------
 // Msg flusher for flushing failed writes
 //-----------------------------------------------------------------------------
@@ -303,6 +366,125 @@ func (b *backend) flushMsgFile(filename string, contents []byte) error {
 	return nil
 }
 
+// parses a worker token like msgs:<channel uuid>|<tps>/<priority>@<lease> into the queue and tps that its msg was pushed with
+func parseWorkerToken(token queue.WorkerToken) (string, int, error) {
+	name, tps, found := strings.Cut(strings.TrimPrefix(token.Key(), msgQueueName+":"), "|")
+	if !found {
+		return "", 0, fmt.Errorf("invalid worker token '%s'", token)
+	}
+
+	tpsInt, err := strconv.Atoi(tps)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid worker token '%s'", token)
+	}
+
+	return name, tpsInt, nil
+}
+
+// requeues the given msg on the queue it was popped from so that it isn't popped again until the given time
+func requeueMsg(q queue.Queue, m *Msg, at time.Time) error {
+	queueName, tps, err := parseWorkerToken(m.workerToken)
+	if err != nil {
+		return err
+	}
+
+	payload, err := jsonparser.Set(m.payload, []byte(strconv.Itoa(m.Retries_+1)), "retry_count")
+	if err != nil {
+		return fmt.Errorf("error updating msg payload: %w", err)
+	}
+
+	if err := q.Push(queueName, tps, "["+string(payload)+"]", m.priority, at); err != nil {
+		return err
+	}
+
+	// keep our place in line for our URN so later msgs to it aren't sent before us
+	return holdMsgURN(q, m, time.Until(at))
+}
+
+// parks the given msg popped with the given worker token until the given time, when it will be released back onto the
+// queue it was popped from
+func scheduleMsg(q queue.Queue, token queue.WorkerToken, msgJSON string, m *Msg, at time.Time) error {
+	queueName, tps, err := parseWorkerToken(token)
+	if err != nil {
+		return err
+	}
+
+	return q.Schedule(queueName, tps, "["+msgJSON+"]", m.priority, at)
+}
+
+// returns the given msg to the queue it was popped from exactly as it was popped, refunds its pop and marks its queue
+// task complete
+func returnMsg(q queue.Queue, m *Msg) error {
+	queueName, tps, err := parseWorkerToken(m.workerToken)
+	if err != nil {
+		return err
+	}
+
+	if err := q.Push(queueName, tps, "["+string(m.payload)+"]", m.priority, time.Now()); err != nil {
+		return err
+	}
+
+	// it wasn't sent so shouldn't count against the channel's TPS or rate limits
+	if err := q.Refund(m.workerToken); err != nil {
+		return err
+	}
+
+	if err := q.MarkComplete(m.workerToken); err != nil {
+		return err
+	}
+
+	return holdMsgURN(q, m, 0)
+}
+
+// puts the given msg JSON popped with the given worker token back on the queue it was popped from to be tried again at
+// the given time, e.g. when it couldn't be read because of a temporary error
+func retryMsgJSON(q queue.Queue, token queue.WorkerToken, msgJSON string, at time.Time) error {
+	queueName, tps, err := parseWorkerToken(token)
+	if err != nil {
+		return err
+	}
+
+	return q.Push(queueName, tps, "["+msgJSON+"]", token.Priority(), at)
+}
+
+// adds the given msg JSON popped with the given worker token to our dead letters because it couldn't be sent
+func deadLetterMsg(q queue.Queue, token queue.WorkerToken, msgJSON string, cause error) error {
+	queueName, tps, err := parseWorkerToken(token)
+	if err != nil {
+		return err
+	}
+
+	// msg may not be readable so use the priority it was popped from
+	_, err = q.AddDeadLetter(queueName, tps, token.Priority(), msgJSON, cause)
+	return err
+}
+
+// locks sending to the URN of the given msg popped with the given worker token, returning an empty lock if another msg
+// to the same URN is being sent and this one has been put in line behind it
+func lockMsgURN(q queue.Queue, token queue.WorkerToken, msgJSON string, pushedOn time.Time, m *Msg) (string, error) {
+	queueName, tps, err := parseWorkerToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	return q.LockURN(queueName, tps, m.priority, m.URN().Identity().String(), m.ID().String(), msgJSON, pushedOn, urnLockLease)
+}
+
+// unlocks sending to the URN of the given msg, if it was locked, so that the next msg in line for it can be sent
+func unlockMsgURN(q queue.Queue, m *Msg, gap time.Duration) error {
+	if m.urnLock == "" {
+		return nil
+	}
+
+	queueName, _, err := parseWorkerToken(m.workerToken)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.UnlockURN(queueName, m.URN().Identity().String(), m.urnLock, gap)
+	return err
+}
+
 //-----------------------------------------------------------------------------
 // Deduping utility methods
 //-----------------------------------------------------------------------------
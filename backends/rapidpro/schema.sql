@@ -43,11 +43,23 @@ CREATE TABLE contacts_contact (
     uuid character varying(36) NOT NULL,
     name character varying(128),
     language character varying(3),
+    fields jsonb,
     created_by_id integer references users_user(id) NOT NULL,
     modified_by_id integer references users_user(id) NOT NULL,
     org_id integer references orgs_org(id) on delete cascade
 );
 
+DROP TABLE IF EXISTS contacts_contactfield CASCADE;
+CREATE TABLE contacts_contactfield (
+    id serial primary key,
+    uuid uuid NOT NULL,
+    key character varying(36) NOT NULL,
+    name character varying(36) NOT NULL,
+    value_type character varying(1) NOT NULL,
+    is_active boolean NOT NULL,
+    org_id integer NOT NULL references orgs_org(id) on delete cascade
+);
+
 DROP TABLE IF EXISTS contacts_contacturn CASCADE;
 CREATE TABLE contacts_contacturn (
     id serial primary key,
@@ -43,6 +43,11 @@ type Stats struct {
 	OutgoingErrors   CountByType    // number of sends that errored
 	OutgoingDuration DurationByType // total time spent sending messages
 
+	QueuePops      CountByType    // number of messages popped from queues
+	QueueWait      DurationByType // total time popped messages waited in queues
+	QueueDepth     CountByType    // number of values in queues
+	QueueThrottled CountByType    // number of times queues were throttled by their TPS or rate limits
+
 	ContactsCreated int
 }
 
@@ -59,6 +64,11 @@ func newStats() *Stats {
 		OutgoingErrors:   make(CountByType),
 		OutgoingDuration: make(DurationByType),
 
+		QueuePops:      make(CountByType),
+		QueueWait:      make(DurationByType),
+		QueueDepth:     make(CountByType),
+		QueueThrottled: make(CountByType),
+
 		ContactsCreated: 0,
 	}
 }
@@ -76,6 +86,11 @@ func (s *Stats) ToMetrics() []types.MetricDatum {
 	metrics = append(metrics, s.OutgoingErrors.metrics("OutgoingErrors")...)
 	metrics = append(metrics, s.OutgoingDuration.metrics("OutgoingDuration", func(typ courier.ChannelType) int { return s.OutgoingSends[typ] + s.OutgoingErrors[typ] })...)
 
+	metrics = append(metrics, s.QueuePops.metrics("QueuePops")...)
+	metrics = append(metrics, s.QueueWait.metrics("QueueWait", func(typ courier.ChannelType) int { return s.QueuePops[typ] })...)
+	metrics = append(metrics, s.QueueDepth.metrics("QueueDepth")...)
+	metrics = append(metrics, s.QueueThrottled.metrics("QueueThrottled")...)
+
 	metrics = append(metrics, cwatch.Datum("ContactsCreated", float64(s.ContactsCreated), types.StandardUnitCount))
 	return metrics
 }
@@ -124,6 +139,28 @@ func (c *StatsCollector) RecordOutgoing(typ courier.ChannelType, success bool, d
 	c.mutex.Unlock()
 }
 
+func (c *StatsCollector) RecordQueuePop(typ courier.ChannelType, wait time.Duration) {
+	c.mutex.Lock()
+	c.stats.QueuePops[typ]++
+	c.stats.QueueWait[typ] += wait
+	c.mutex.Unlock()
+}
+
+// RecordQueue records the current depth of a queue
+func (c *StatsCollector) RecordQueue(typ courier.ChannelType, depth int) {
+	c.mutex.Lock()
+	c.stats.QueueDepth[typ] += depth
+	c.stats.QueueThrottled[typ] += 0 // so that types without throttled queues are reported as zero
+	c.mutex.Unlock()
+}
+
+// RecordQueueThrottles records how many times a queue was throttled
+func (c *StatsCollector) RecordQueueThrottles(typ courier.ChannelType, count int) {
+	c.mutex.Lock()
+	c.stats.QueueThrottled[typ] += count
+	c.mutex.Unlock()
+}
+
 func (c *StatsCollector) RecordContactCreated() {
 	c.mutex.Lock()
 	c.stats.ContactsCreated++
@@ -21,6 +21,12 @@ func TestStats(t *testing.T) {
 	sc.RecordOutgoing("FBA", true, time.Second)
 	sc.RecordOutgoing("FBA", true, time.Second)
 	sc.RecordOutgoing("FBA", true, time.Second)
+	sc.RecordQueuePop("T", time.Second*2)
+	sc.RecordQueuePop("T", time.Second*4)
+	sc.RecordQueue("T", 5)
+	sc.RecordQueue("T", 3)
+	sc.RecordQueue("FBA", 2)
+	sc.RecordQueueThrottles("T", 4)
 
 	stats := sc.Extract()
 
@@ -33,9 +39,14 @@ func TestStats(t *testing.T) {
 	assert.Equal(t, rapidpro.CountByType{"T": 2, "FBA": 3}, stats.OutgoingSends)
 	assert.Equal(t, rapidpro.CountByType{}, stats.OutgoingErrors)
 	assert.Equal(t, rapidpro.DurationByType{"T": time.Second * 2, "FBA": time.Second * 3}, stats.OutgoingDuration)
+	assert.Equal(t, rapidpro.CountByType{"T": 2}, stats.QueuePops)
+	assert.Equal(t, rapidpro.DurationByType{"T": time.Second * 6}, stats.QueueWait)
+	assert.Equal(t, rapidpro.CountByType{"T": 8, "FBA": 2}, stats.QueueDepth)
+	assert.Equal(t, rapidpro.CountByType{"T": 4, "FBA": 0}, stats.QueueThrottled)
 
 	metrics := stats.ToMetrics()
-	assert.Len(t, metrics, 8)
+	assert.Len(t, metrics, 14)
+	assert.Contains(t, metrics, cwatch.Datum("QueueWait", 3, "Seconds", cwatch.Dimension("ChannelType", "T")))
 
 	sc.RecordOutgoing("FBA", true, time.Second)
 	sc.RecordOutgoing("FBA", true, time.Second)
@@ -6,29 +6,45 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils/clogs"
 	"github.com/nyaruka/gocommon/dbutil"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/syncx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/vkutil"
 )
 
 // StatusUpdate represents a status update on a message
 type StatusUpdate struct {
-	ChannelUUID_ courier.ChannelUUID `json:"channel_uuid"             db:"channel_uuid"`
-	ChannelID_   courier.ChannelID   `json:"channel_id"               db:"channel_id"`
-	MsgID_       courier.MsgID       `json:"msg_id,omitempty"         db:"msg_id"`
-	OldURN_      urns.URN            `json:"old_urn"                  db:"old_urn"`
-	NewURN_      urns.URN            `json:"new_urn"                  db:"new_urn"`
-	ExternalID_  string              `json:"external_id,omitempty"    db:"external_id"`
-	Status_      courier.MsgStatus   `json:"status"                   db:"status"`
-	ModifiedOn_  time.Time           `json:"modified_on"              db:"modified_on"`
-	LogUUID      clogs.UUID          `json:"log_uuid"                 db:"log_uuid"`
+	ChannelUUID_ courier.ChannelUUID     `json:"channel_uuid"             db:"channel_uuid"`
+	ChannelID_   courier.ChannelID       `json:"channel_id"               db:"channel_id"`
+	MsgID_       courier.MsgID           `json:"msg_id,omitempty"         db:"msg_id"`
+	OldURN_      urns.URN                `json:"old_urn"                  db:"old_urn"`
+	NewURN_      urns.URN                `json:"new_urn"                  db:"new_urn"`
+	ExternalID_  string                  `json:"external_id,omitempty"    db:"external_id"`
+	ExtraIDs_    []string                `json:"extra_ids,omitempty"      db:"-"`
+	Status_      courier.MsgStatus       `json:"status"                   db:"status"`
+	Reason_      courier.MsgFailedReason `json:"failed_reason,omitempty"  db:"failed_reason"`
+	ModifiedOn_  time.Time               `json:"modified_on"              db:"modified_on"`
+	LogUUID      clogs.UUID              `json:"log_uuid"                 db:"log_uuid"`
+	ParkedOn_    *time.Time              `json:"parked_on,omitempty"      db:"-"`
+
+	isPart    bool // whether this is for a part of a msg which was sent in multiple parts
+	partIndex int
 }
 
 // creates a new message status update
@@ -48,8 +64,9 @@ func newStatusUpdate(channel courier.Channel, id courier.MsgID, externalID strin
 	}
 }
 
-// the craziness below lets us update our status to 'F' and schedule retries without knowing anything about the message
-const sqlUpdateMsgByID = `
+// the craziness below lets us update our status to 'F' and schedule retries without knowing anything about the message,
+// but never moves a message to a status it can't transition to
+var sqlUpdateMsgByID = fmt.Sprintf(`
 UPDATE msgs_msg SET 
 	status = CASE 
 		WHEN 
@@ -82,6 +99,10 @@ UPDATE msgs_msg SET
 			next_attempt 
 		END,
 	failed_reason = CASE
+		WHEN
+			s.status = 'F' AND s.failed_reason != ''
+		THEN
+			s.failed_reason
 		WHEN
 			error_count >= 2
 		THEN
@@ -108,14 +129,37 @@ UPDATE msgs_msg SET
 	modified_on = NOW(),
 	log_uuids = array_append(log_uuids, s.log_uuid::uuid)
 FROM
-	(VALUES(:msg_id, :channel_id, :status, :external_id, :log_uuid)) 
+	(VALUES(:msg_id, :channel_id, :status, :failed_reason, :external_id, :log_uuid)) 
 AS 
-	s(msg_id, channel_id, status, external_id, log_uuid) 
+	s(msg_id, channel_id, status, failed_reason, external_id, log_uuid),
+	msgs_msg AS old
 WHERE 
 	msgs_msg.id = s.msg_id::bigint AND
+	old.id = msgs_msg.id AND
 	msgs_msg.channel_id = s.channel_id::int AND 
-	msgs_msg.direction = 'O'
-`
+	msgs_msg.direction = 'O' AND
+	(msgs_msg.status, s.status) NOT IN (%s)
+RETURNING
+	msgs_msg.id, msgs_msg.uuid, msgs_msg.org_id, old.status AS old_status, msgs_msg.status
+`, sqlInvalidStatusTransitions())
+
+// builds the list of current and new status pairs which aren't valid status transitions for the update above
+func sqlInvalidStatusTransitions() string {
+	statuses := []courier.MsgStatus{
+		courier.MsgStatusPending, courier.MsgStatusQueued, courier.MsgStatusErrored, courier.MsgStatusWired,
+		courier.MsgStatusSent, courier.MsgStatusDelivered, courier.MsgStatusRead, courier.MsgStatusFailed,
+	}
+
+	invalid := make([]string, 0, len(statuses)*len(statuses))
+	for _, from := range statuses {
+		for _, to := range statuses {
+			if !from.CanTransitionTo(to) {
+				invalid = append(invalid, fmt.Sprintf("('%s', '%s')", from, to))
+			}
+		}
+	}
+	return strings.Join(invalid, ", ")
+}
 
 func (b *backend) flushStatusFile(filename string, contents []byte) error {
 	ctx := context.Background()
@@ -160,9 +204,25 @@ func (s *StatusUpdate) URNUpdate() (urns.URN, urns.URN) {
 func (s *StatusUpdate) ExternalID() string      { return s.ExternalID_ }
 func (s *StatusUpdate) SetExternalID(id string) { s.ExternalID_ = id }
 
+func (s *StatusUpdate) ExternalIDs() []string {
+	if s.ExternalID_ == "" {
+		return nil
+	}
+	return append([]string{s.ExternalID_}, s.ExtraIDs_...)
+}
+func (s *StatusUpdate) SetExternalIDs(ids []string) {
+	s.ExternalID_, s.ExtraIDs_ = "", nil
+	if len(ids) > 0 {
+		s.ExternalID_, s.ExtraIDs_ = ids[0], ids[1:]
+	}
+}
+
 func (s *StatusUpdate) Status() courier.MsgStatus          { return s.Status_ }
 func (s *StatusUpdate) SetStatus(status courier.MsgStatus) { s.Status_ = status }
 
+func (s *StatusUpdate) FailedReason() courier.MsgFailedReason          { return s.Reason_ }
+func (s *StatusUpdate) SetFailedReason(reason courier.MsgFailedReason) { s.Reason_ = reason }
+
 // StatusWriter handles batched writes of status updates to the database
 type StatusWriter struct {
 	*syncx.Batcher[*StatusUpdate]
@@ -208,8 +268,19 @@ func (b *backend) writeStatuseUpdates(ctx context.Context, spoolDir string, batc
 			}
 		}
 	} else {
-		for _, s := range unresolved {
-			log.Warn(fmt.Sprintf("unable to find message with channel_id=%d and external_id=%s", s.ChannelID_, s.ExternalID_))
+		rc := b.rp.Get()
+		defer rc.Close()
+
+		// msgs may not have their external ids yet so park these to be retried later
+		if len(unresolved) > 0 {
+			if err := b.parkUnresolvedStatuses(rc, unresolved); err != nil {
+				log.Error("error parking unresolved statuses", "error", err)
+			}
+		}
+
+		// and retry any parked statuses for the external ids of the msgs we've just written
+		if err := b.retryUnresolvedStatusesFor(rc, batch); err != nil {
+			log.Error("error retrying unresolved statuses", "error", err)
 		}
 	}
 }
@@ -230,6 +301,8 @@ func (b *backend) writeStatusUpdatesToDB(ctx context.Context, statuses []*Status
 		if err := b.resolveStatusUpdateMsgIDs(ctx, missingID); err != nil {
 			return nil, err
 		}
+
+		b.rollUpPartStatuses(ctx, missingID)
 	}
 
 	resolved := make([]*StatusUpdate, 0, len(statuses))
@@ -243,14 +316,240 @@ func (b *backend) writeStatusUpdatesToDB(ctx context.Context, statuses []*Status
 		}
 	}
 
-	err := dbutil.BulkQuery(ctx, b.db, sqlUpdateMsgByID, resolved)
-	if err != nil {
+	if err := b.applyStatusUpdates(ctx, resolved); err != nil {
 		return nil, fmt.Errorf("error updating status: %w", err)
 	}
 
 	return unresolved, nil
 }
 
+// applies a batch of resolved status updates to their msgs, coalesced to one per msg. The update a msg is coalesced to
+// can still be rejected, e.g. if the msg was updated since, in which case we try again with its other updates as they
+// may still be valid transitions from its status.
+func (b *backend) applyStatusUpdates(ctx context.Context, statuses []*StatusUpdate) error {
+	for len(statuses) > 0 {
+		coalesced := coalesceStatusUpdates(slices.Clone(statuses))
+
+		updated, err := b.updateMsgStatuses(ctx, coalesced)
+		if err != nil {
+			return err
+		}
+
+		b.queueStatusTimelineEntries(statuses, coalesced, updated)
+
+		applied := make(map[courier.MsgID]bool, len(updated))
+		for _, m := range updated {
+			applied[m.ID] = true
+		}
+
+		remaining := make([]*StatusUpdate, 0, len(statuses))
+		for _, s := range statuses {
+			if !applied[s.MsgID_] && !slices.Contains(coalesced, s) {
+				remaining = append(remaining, s)
+			}
+		}
+		statuses = remaining
+	}
+
+	return nil
+}
+
+// applies status updates to their msgs, returning the msgs which were updated. We scan the returned rows ourselves
+// rather than have them mapped back onto the updates because not every update will update a msg.
+func (b *backend) updateMsgStatuses(ctx context.Context, statuses []*StatusUpdate) ([]*updatedMsg, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+
+	query, args, err := dbutil.BulkSQL(b.db, sqlUpdateMsgByID, statuses)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := b.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbutil.QueryErrorWrapf(err, query, args, "error making bulk query")
+	}
+	defer rows.Close()
+
+	updated := make([]*updatedMsg, 0, len(statuses))
+	for rows.Next() {
+		m := &updatedMsg{}
+		if err := rows.StructScan(m); err != nil {
+			return nil, dbutil.QueryErrorWrapf(err, query, args, "error scanning updated msg")
+		}
+		updated = append(updated, m)
+	}
+
+	return updated, rows.Err()
+}
+
+// coalesces a batch of resolved status updates so there's one per msg, as a msg can only be updated once per query, with
+// later updates only replacing earlier ones for the same msg if they're a valid transition from them
+func coalesceStatusUpdates(statuses []*StatusUpdate) []*StatusUpdate {
+	coalesced := make([]*StatusUpdate, 0, len(statuses))
+	byMsg := make(map[courier.MsgID]int, len(statuses))
+
+	for _, s := range statuses {
+		i, seen := byMsg[s.MsgID_]
+		if !seen {
+			byMsg[s.MsgID_] = len(coalesced)
+			coalesced = append(coalesced, s)
+			continue
+		}
+
+		winner, loser := coalesced[i], s
+		if winner.Status_.CanTransitionTo(s.Status_) {
+			winner, loser = s, winner
+		}
+
+		// don't lose an external id that only the other update has
+		if winner.ExternalID_ == "" {
+			winner.ExternalID_ = loser.ExternalID_
+		}
+		coalesced[i] = winner
+	}
+
+	return coalesced
+}
+
+// how long we keep retrying statuses that we can't find msgs for, before giving up on them
+const unresolvedStatusTTL = 15 * time.Minute
+
+// key of the sorted set of channel ID + external ID pairs which have unresolved statuses, scored by when last parked
+const unresolvedStatusesKey = "unresolved-statuses"
+
+func unresolvedStatusesKeyFor(channelID courier.ChannelID, externalID string) string {
+	return fmt.Sprintf("%s:%d|%s", unresolvedStatusesKey, channelID, externalID)
+}
+
+// parks the given unresolved statuses so that they can be retried when we learn the external ids of their msgs, or
+// periodically, until they've been parked for unresolvedStatusTTL
+func (b *backend) parkUnresolvedStatuses(rc redis.Conn, statuses []*StatusUpdate) error {
+	now := time.Now().In(time.UTC)
+
+	for _, s := range statuses {
+		if s.ParkedOn_ == nil {
+			s.ParkedOn_ = &now
+		}
+
+		ttl := s.ParkedOn_.Add(unresolvedStatusTTL).Sub(now)
+		if ttl <= 0 {
+			slog.Warn(fmt.Sprintf("unable to find message with channel_id=%d and external_id=%s", s.ChannelID_, s.ExternalID_))
+			continue
+		}
+
+		key := unresolvedStatusesKeyFor(s.ChannelID_, s.ExternalID_)
+		rc.Send("RPUSH", key, jsonx.MustMarshal(s))
+		rc.Send("PEXPIRE", key, ttl.Milliseconds())
+		rc.Send("ZADD", unresolvedStatusesKey, now.Unix(), key)
+	}
+
+	reply, err := rc.Do("")
+	if err != nil {
+		return err
+	}
+
+	// replies to pipelined commands aren't returned as errors so check them ourselves
+	replies, _ := reply.([]any)
+	for _, r := range replies {
+		if err, ok := r.(redis.Error); ok {
+			return fmt.Errorf("error parking unresolved status: %w", err)
+		}
+	}
+	return nil
+}
+
+// claims the statuses parked under the given keys so that they can be retried
+func (b *backend) claimUnresolvedStatuses(rc redis.Conn, keys []string) ([]*StatusUpdate, error) {
+	statuses := make([]*StatusUpdate, 0, len(keys))
+	if len(keys) == 0 {
+		return statuses, nil
+	}
+
+	for _, key := range keys {
+		rc.Send("MULTI")
+		rc.Send("LRANGE", key, 0, -1)
+		rc.Send("DEL", key)
+		rc.Send("ZREM", unresolvedStatusesKey, key)
+		rc.Send("EXEC")
+	}
+	if err := rc.Flush(); err != nil {
+		return nil, fmt.Errorf("error claiming unresolved statuses: %w", err)
+	}
+
+	for range keys {
+		// skip the replies to MULTI and the queued commands
+		for range 4 {
+			if _, err := rc.Receive(); err != nil {
+				return nil, fmt.Errorf("error claiming unresolved statuses: %w", err)
+			}
+		}
+
+		results, err := redis.Values(rc.Receive())
+		if err != nil {
+			return nil, fmt.Errorf("error claiming unresolved statuses: %w", err)
+		}
+
+		values, err := redis.ByteSlices(results[0], nil)
+		if err != nil {
+			return nil, fmt.Errorf("error claiming unresolved statuses: %w", err)
+		}
+
+		for _, v := range values {
+			s := &StatusUpdate{}
+			if err := json.Unmarshal(v, s); err != nil {
+				slog.Error("error unmarshalling unresolved status", "error", err)
+				continue
+			}
+			statuses = append(statuses, s)
+		}
+	}
+
+	return statuses, nil
+}
+
+// claims any statuses parked for the external ids of the given written statuses and queues them to be written again
+func (b *backend) retryUnresolvedStatusesFor(rc redis.Conn, written []*StatusUpdate) error {
+	keys := make([]string, 0, len(written))
+	for _, s := range written {
+		if s.MsgID_ != courier.NilMsgID {
+			for _, extID := range s.ExternalIDs() {
+				keys = append(keys, unresolvedStatusesKeyFor(s.ChannelID_, extID))
+			}
+		}
+	}
+
+	statuses, err := b.claimUnresolvedStatuses(rc, keys)
+	if err != nil {
+		return err
+	}
+
+	for _, parked := range statuses {
+		b.statusWriter.Queue(parked)
+	}
+	return nil
+}
+
+// claims all parked statuses and queues them to be written again, returning how many were retried
+func (b *backend) retryUnresolvedStatuses(rc redis.Conn) (int, error) {
+	keys, err := redis.Strings(rc.Do("ZRANGE", unresolvedStatusesKey, 0, -1))
+	if err != nil {
+		return 0, fmt.Errorf("error reading unresolved statuses: %w", err)
+	}
+
+	// keys which have expired will just be removed from our set
+	statuses, err := b.claimUnresolvedStatuses(rc, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, s := range statuses {
+		b.statusWriter.Queue(s)
+	}
+	return len(statuses), nil
+}
+
 const sqlResolveStatusMsgIDs = `
 SELECT id, channel_id, external_id 
   FROM msgs_msg 
@@ -262,24 +561,18 @@ func (b *backend) resolveStatusUpdateMsgIDs(ctx context.Context, statuses []*Sta
 	rc := b.rp.Get()
 	defer rc.Close()
 
-	chAndExtKeys := make([]string, len(statuses))
-	for i, s := range statuses {
-		chAndExtKeys[i] = fmt.Sprintf("%d|%s", s.ChannelID_, s.ExternalID_)
-	}
-	cachedIDs, err := b.sentExternalIDs.MGet(ctx, rc, chAndExtKeys...)
-	if err != nil {
-		// log error but we continue and try to get ids from the database
-		slog.Error("error looking up sent message ids in valkey", "error", err)
+	// look in the cache of external ids of parts of multi-part msgs first, so that we know which statuses are for parts,
+	// then the cache of recently sent external ids
+	notInCache := b.resolveStatusUpdatePartsFromCache(ctx, rc, statuses)
+	if len(notInCache) > 0 {
+		notInCache = b.resolveStatusUpdateMsgIDsFromCache(ctx, rc, b.sentExternalIDs, notInCache)
 	}
 
-	// collect the statuses that couldn't be resolved from cache, update the ones that could
-	notInCache := make([]*StatusUpdate, 0, len(statuses))
-	for i := range cachedIDs {
-		id, err := strconv.Atoi(cachedIDs[i])
-		if err != nil {
-			notInCache = append(notInCache, statuses[i])
-		} else {
-			statuses[i].MsgID_ = courier.MsgID(id)
+	// then the parts of multi-part msgs in DynamoDB, since only the first part's external id is saved in the database
+	if len(notInCache) > 0 {
+		var err error
+		if notInCache, err = b.resolveStatusUpdatePartsFromDynamo(ctx, notInCache); err != nil {
+			return err
 		}
 	}
 
@@ -324,3 +617,240 @@ func (b *backend) resolveStatusUpdateMsgIDs(ctx context.Context, statuses []*Sta
 
 	return rows.Err()
 }
+
+// tries to resolve msg IDs for the given statuses from the given cache of external ids, returning those it couldn't
+func (b *backend) resolveStatusUpdateMsgIDsFromCache(ctx context.Context, rc redis.Conn, cache *vkutil.IntervalHash, statuses []*StatusUpdate) []*StatusUpdate {
+	chAndExtKeys := make([]string, len(statuses))
+	for i, s := range statuses {
+		chAndExtKeys[i] = fmt.Sprintf("%d|%s", s.ChannelID_, s.ExternalID_)
+	}
+	cachedIDs, err := cache.MGet(ctx, rc, chAndExtKeys...)
+	if err != nil {
+		// log error but we continue and try to get ids from the database
+		slog.Error("error looking up sent message ids in valkey", "error", err)
+		return statuses
+	}
+
+	// collect the statuses that couldn't be resolved from cache, update the ones that could
+	notInCache := make([]*StatusUpdate, 0, len(statuses))
+	for i := range cachedIDs {
+		id, err := strconv.Atoi(cachedIDs[i])
+		if err != nil {
+			notInCache = append(notInCache, statuses[i])
+		} else {
+			statuses[i].MsgID_ = courier.MsgID(id)
+		}
+	}
+
+	return notInCache
+}
+
+// how long the parts of multi-part msgs are kept for, which is longer than our caches of sent external ids because
+// statuses can be reported long after a msg was sent
+const dynamoMsgPartsTTL = 30 * 24 * time.Hour
+
+// how far along a part is, with the least progressed part determining the status of the msg
+var partProgress = map[courier.MsgStatus]int{
+	courier.MsgStatusWired:     1,
+	courier.MsgStatusSent:      2,
+	courier.MsgStatusDelivered: 3,
+	courier.MsgStatusRead:      4,
+}
+
+// GetMsgPartKey gets the key of the item which records which msg the part with the given external id belongs to
+func GetMsgPartKey(channelUUID courier.ChannelUUID, externalID string) DynamoKey {
+	return DynamoKey{PK: fmt.Sprintf("cha#%s#ext#%s", channelUUID, externalID), SK: "prt"}
+}
+
+// GetMsgPartsKey gets the key of the item which records the statuses of all the parts of the given msg
+func GetMsgPartsKey(channelUUID courier.ChannelUUID, msgID courier.MsgID) DynamoKey {
+	return DynamoKey{PK: fmt.Sprintf("cha#%s#msg#%d", channelUUID, msgID), SK: "prts"}
+}
+
+// records the external ids of a msg we've just sent, and if it was sent in multiple parts, the ids of those parts so
+// that we can resolve and roll up status updates for any of them. Parts are written to DynamoDB, so that statuses which
+// arrive after our caches expire can still be resolved, and cached for quicker lookups.
+func (b *backend) recordSentExternalIDs(ctx context.Context, rc redis.Conn, s *StatusUpdate) error {
+	if err := b.sentExternalIDs.Set(ctx, rc, fmt.Sprintf("%d|%s", s.ChannelID_, s.ExternalID_), s.MsgID_.String()); err != nil {
+		return err
+	}
+
+	if len(s.ExtraIDs_) == 0 {
+		return nil
+	}
+
+	channel, err := b.GetChannel(ctx, courier.AnyChannelType, s.ChannelUUID_)
+	if err != nil {
+		return fmt.Errorf("error loading channel: %w", err)
+	}
+	orgID := int(channel.(*Channel).OrgID())
+	expiresOn := time.Now().Add(dynamoMsgPartsTTL)
+
+	extIDs := s.ExternalIDs()
+	items := make([]*DynamoItem, 0, len(extIDs)+1)
+	items = append(items, &DynamoItem{
+		DynamoKey: GetMsgPartsKey(s.ChannelUUID_, s.MsgID_),
+		OrgID:     orgID,
+		TTL:       expiresOn,
+		Data:      map[string]any{"external_ids": extIDs, "statuses": slices.Repeat([]courier.MsgStatus{s.Status_}, len(extIDs))},
+	})
+
+	for i, extID := range extIDs {
+		if err := b.sentPartExternalIDs.Set(ctx, rc, fmt.Sprintf("%d|%s", s.ChannelID_, extID), fmt.Sprintf("%d|%d", s.MsgID_, i)); err != nil {
+			return err
+		}
+
+		items = append(items, &DynamoItem{
+			DynamoKey: GetMsgPartKey(s.ChannelUUID_, extID),
+			OrgID:     orgID,
+			TTL:       expiresOn,
+			Data:      map[string]any{"msg_id": s.MsgID_, "index": i},
+		})
+	}
+
+	// written directly rather than by our dynamo writer so that they exist before any status updates for the parts
+	for batch := range slices.Chunk(items, 25) {
+		if err := writeDynamoBatch(ctx, b.dynamo, batch); err != nil {
+			return fmt.Errorf("error writing msg parts to dynamo: %w", err)
+		}
+	}
+	return nil
+}
+
+// tries to resolve msg IDs for the given statuses from the cache of external ids of parts of multi-part msgs, returning
+// those it couldn't
+func (b *backend) resolveStatusUpdatePartsFromCache(ctx context.Context, rc redis.Conn, statuses []*StatusUpdate) []*StatusUpdate {
+	chAndExtKeys := make([]string, len(statuses))
+	for i, s := range statuses {
+		chAndExtKeys[i] = fmt.Sprintf("%d|%s", s.ChannelID_, s.ExternalID_)
+	}
+	cachedParts, err := b.sentPartExternalIDs.MGet(ctx, rc, chAndExtKeys...)
+	if err != nil {
+		slog.Error("error looking up sent message part ids in valkey", "error", err)
+		return statuses
+	}
+
+	notInCache := make([]*StatusUpdate, 0, len(statuses))
+	for i := range cachedParts {
+		var msgID courier.MsgID
+		var index int
+		if _, err := fmt.Sscanf(cachedParts[i], "%d|%d", &msgID, &index); err != nil {
+			notInCache = append(notInCache, statuses[i])
+		} else {
+			statuses[i].MsgID_, statuses[i].isPart, statuses[i].partIndex = msgID, true, index
+		}
+	}
+
+	return notInCache
+}
+
+// tries to resolve msg IDs for the given statuses from the parts of multi-part msgs we've written to DynamoDB,
+// returning those it couldn't
+func (b *backend) resolveStatusUpdatePartsFromDynamo(ctx context.Context, statuses []*StatusUpdate) ([]*StatusUpdate, error) {
+	byKey := make(map[DynamoKey][]*StatusUpdate, len(statuses))
+	for _, s := range statuses {
+		key := GetMsgPartKey(s.ChannelUUID_, s.ExternalID_)
+		byKey[key] = append(byKey[key], s)
+	}
+
+	keys := slices.Collect(maps.Keys(byKey))
+
+	for batch := range slices.Chunk(keys, 100) {
+		keyAttrs := make([]map[string]types.AttributeValue, len(batch))
+		for i, key := range batch {
+			keyAttrs[i], _ = attributevalue.MarshalMap(key)
+		}
+
+		resp, err := b.dynamo.Client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]types.KeysAndAttributes{b.dynamo.Name(): {Keys: keyAttrs}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error looking up msg parts in dynamo: %w", err)
+		}
+		if len(resp.UnprocessedKeys) > 0 {
+			slog.Error("unprocessed keys looking up msg parts in dynamo", "count", len(resp.UnprocessedKeys[b.dynamo.Name()].Keys))
+		}
+
+		for _, attrs := range resp.Responses[b.dynamo.Name()] {
+			item := &DynamoItem{}
+			if err := attributevalue.UnmarshalMap(attrs, item); err != nil {
+				return nil, fmt.Errorf("error unmarshalling msg part from dynamo: %w", err)
+			}
+
+			msgID, _ := item.Data["msg_id"].(float64)
+			index, _ := item.Data["index"].(float64)
+			for _, s := range byKey[item.DynamoKey] {
+				s.MsgID_, s.isPart, s.partIndex = courier.MsgID(msgID), true, int(index)
+			}
+		}
+	}
+
+	notFound := make([]*StatusUpdate, 0, len(statuses))
+	for _, s := range statuses {
+		if s.MsgID_ == courier.NilMsgID {
+			notFound = append(notFound, s)
+		}
+	}
+	return notFound, nil
+}
+
+// for statuses of parts of msgs which were sent in multiple parts, records the status against the part it's for and
+// changes it to the status of the least progressed part, e.g. a msg is only delivered once all its parts have been
+// delivered
+func (b *backend) rollUpPartStatuses(ctx context.Context, statuses []*StatusUpdate) {
+	for _, s := range statuses {
+		if s.isPart {
+			if err := b.rollUpPartStatus(ctx, s); err != nil {
+				slog.Error("error rolling up msg part statuses", "msg_id", s.MsgID_, "error", err)
+
+				// rather than writing the status of this part as that of the whole msg, leave it unresolved so that it's
+				// parked and retried
+				s.MsgID_, s.isPart = courier.NilMsgID, false
+			}
+		}
+	}
+}
+
+func (b *backend) rollUpPartStatus(ctx context.Context, s *StatusUpdate) error {
+	// errors and failures of any part apply to the whole msg
+	if partProgress[s.Status_] == 0 {
+		s.ExternalID_ = ""
+		return nil
+	}
+
+	// update the status of this part and read back those of all the parts in one atomic operation, so that concurrent
+	// updates for other parts can't be lost
+	key, _ := attributevalue.MarshalMap(GetMsgPartsKey(s.ChannelUUID_, s.MsgID_))
+	resp, err := b.dynamo.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(b.dynamo.Name()),
+		Key:                      key,
+		UpdateExpression:         aws.String(fmt.Sprintf("SET #data.#statuses[%d] = :status", s.partIndex)),
+		ConditionExpression:      aws.String("attribute_exists(PK)"),
+		ExpressionAttributeNames: map[string]string{"#data": "Data", "#statuses": "statuses"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: string(s.Status_)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return fmt.Errorf("error updating msg part status in dynamo: %w", err)
+	}
+
+	var parts struct {
+		Statuses []courier.MsgStatus `dynamodbav:"statuses"`
+	}
+	if err := attributevalue.Unmarshal(resp.Attributes["Data"], &parts); err != nil {
+		return fmt.Errorf("error unmarshalling msg parts: %w", err)
+	}
+
+	for _, status := range parts.Statuses {
+		if partProgress[status] < partProgress[s.Status_] {
+			s.Status_ = status
+		}
+	}
+
+	// don't let the external id of a later part replace the one the msg was saved with, which we only clear once rolled
+	// up as we need it to resolve the msg again if we fail
+	s.ExternalID_ = ""
+	return nil
+}
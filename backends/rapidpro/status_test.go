@@ -0,0 +1,54 @@
+package rapidpro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalesceStatusUpdates(t *testing.T) {
+	newStatus := func(id courier.MsgID, status courier.MsgStatus, extID string) *StatusUpdate {
+		return &StatusUpdate{MsgID_: id, Status_: status, ExternalID_: extID}
+	}
+
+	assert.Len(t, coalesceStatusUpdates([]*StatusUpdate{}), 0)
+
+	s1 := newStatus(1, courier.MsgStatusWired, "ext1")
+	s2 := newStatus(2, courier.MsgStatusWired, "ext2")
+	s3 := newStatus(1, courier.MsgStatusDelivered, "")
+	s4 := newStatus(2, courier.MsgStatusRead, "")
+	s5 := newStatus(1, courier.MsgStatusSent, "") // late so ignored
+	s6 := newStatus(2, courier.MsgStatusDelivered, "")
+	s7 := newStatus(3, courier.MsgStatusFailed, "")
+	s8 := newStatus(3, courier.MsgStatusWired, "ext3")
+
+	coalesced := coalesceStatusUpdates([]*StatusUpdate{s1, s2, s3, s4, s5, s6, s7, s8})
+
+	assert.Equal(t, []*StatusUpdate{s3, s4, s7}, coalesced)
+	assert.Equal(t, courier.MsgStatusDelivered, coalesced[0].Status_)
+	assert.Equal(t, "ext1", coalesced[0].ExternalID_) // taken from the wired status
+	assert.Equal(t, courier.MsgStatusRead, coalesced[1].Status_)
+	assert.Equal(t, "ext2", coalesced[1].ExternalID_)
+	assert.Equal(t, courier.MsgStatusFailed, coalesced[2].Status_)
+	assert.Equal(t, "ext3", coalesced[2].ExternalID_)
+}
+
+func TestStatusTimelineEntry(t *testing.T) {
+	s1 := &StatusUpdate{MsgID_: 1, Status_: courier.MsgStatusWired, ExternalID_: "ext1", LogUUID: "0199df10-10dc-7e6d-834b-3d959ece93b2", ModifiedOn_: time.Date(2025, 10, 14, 9, 30, 0, 0, time.UTC)}
+	s2 := &StatusUpdate{MsgID_: 1, Status_: courier.MsgStatusDelivered, LogUUID: "0199df10-3af5-7d34-9f7e-ff3cd2b36fa6", ModifiedOn_: time.Date(2025, 10, 14, 9, 30, 0, 123000, time.UTC)}
+
+	item1 := NewDynamoStatusTimelineEntry(s1, courier.MsgStatusWired, "0199df0f-9f82-7689-b02d-f34105991321", 1)
+	item2 := NewDynamoStatusTimelineEntry(s2, courier.MsgStatusDelivered, "0199df0f-9f82-7689-b02d-f34105991321", 1)
+	item3 := NewDynamoStatusTimelineEntry(&StatusUpdate{MsgID_: 1, Status_: courier.MsgStatusErrored, LogUUID: s2.LogUUID, ModifiedOn_: s2.ModifiedOn_}, courier.MsgStatusFailed, "0199df0f-9f82-7689-b02d-f34105991321", 1)
+
+	assert.Equal(t, DynamoKey{PK: "msg#0199df0f-9f82-7689-b02d-f34105991321", SK: "sts#2025-10-14T09:30:00.000000Z#0199df10-10dc-7e6d-834b-3d959ece93b2"}, item1.DynamoKey)
+	assert.Equal(t, DynamoKey{PK: "msg#0199df0f-9f82-7689-b02d-f34105991321", SK: "sts#2025-10-14T09:30:00.000123Z#0199df10-3af5-7d34-9f7e-ff3cd2b36fa6"}, item2.DynamoKey)
+	assert.Less(t, item1.SK, item2.SK)
+	assert.Equal(t, 1, item1.OrgID)
+	assert.Equal(t, time.Date(2025, 10, 21, 9, 30, 0, 0, time.UTC), item1.TTL)
+	assert.Equal(t, map[string]any{"status": courier.MsgStatusWired, "external_id": "ext1", "log_uuid": s1.LogUUID, "created_on": s1.ModifiedOn_}, item1.Data)
+	assert.Equal(t, map[string]any{"status": courier.MsgStatusDelivered, "log_uuid": s2.LogUUID, "created_on": s2.ModifiedOn_}, item2.Data)
+	assert.Equal(t, map[string]any{"status": courier.MsgStatusFailed, "log_uuid": s2.LogUUID, "created_on": s2.ModifiedOn_}, item3.Data) // as stored
+}
@@ -0,0 +1,123 @@
+package rapidpro
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/nyaruka/courier"
+)
+
+const (
+	dynamoStatusTimelineTTL = 7 * 24 * time.Hour // 1 week, same as the channel logs entries refer to
+)
+
+// updatedMsg is a msg which was updated by a status update
+type updatedMsg struct {
+	ID        courier.MsgID     `db:"id"`
+	UUID      courier.MsgUUID   `db:"uuid"`
+	OrgID     OrgID             `db:"org_id"`
+	OldStatus courier.MsgStatus `db:"old_status"`
+	Status    courier.MsgStatus `db:"status"`
+}
+
+// queues the status updates for msgs which were updated to be appended to the status timelines of those msgs. Updates
+// are coalesced to one per msg before they're applied, but every update in the batch up to the applied one which was a
+// valid transition from the status before it, starting from the msg's status before the batch, is part of its timeline.
+// The applied update is recorded with the status it was stored as, e.g. failed rather than errored if the msg has
+// errored too many times.
+func (b *backend) queueStatusTimelineEntries(statuses, coalesced []*StatusUpdate, updated []*updatedMsg) {
+	byID := make(map[courier.MsgID]*updatedMsg, len(updated))
+	current := make(map[courier.MsgID]courier.MsgStatus, len(updated))
+	for _, m := range updated {
+		byID[m.ID] = m
+		current[m.ID] = m.OldStatus
+	}
+
+	applied := make(map[*StatusUpdate]bool, len(coalesced))
+	for _, s := range coalesced {
+		applied[s] = true
+	}
+
+	for _, s := range statuses {
+		m := byID[s.MsgID_]
+		if m == nil || (!applied[s] && !current[m.ID].CanTransitionTo(s.Status_)) {
+			continue
+		}
+
+		status := s.Status_
+		if applied[s] {
+			status = m.Status
+			delete(byID, m.ID) // nothing after the applied update was applied
+		}
+		current[m.ID] = s.Status_
+
+		if b.dynamoWriter.Queue(NewDynamoStatusTimelineEntry(s, status, m.UUID, m.OrgID)) <= 0 {
+			slog.With("storage", "dynamo").Error("status timeline writer buffer full", "msg_id", s.MsgID_)
+		}
+	}
+}
+
+// NewDynamoStatusTimelineEntry creates the status timeline entry for a status update applied to the given msg, with
+// the status that the msg was updated to
+func NewDynamoStatusTimelineEntry(s *StatusUpdate, status courier.MsgStatus, msgUUID courier.MsgUUID, orgID OrgID) *DynamoItem {
+	data := map[string]any{
+		"status":     status,
+		"log_uuid":   s.LogUUID,
+		"created_on": s.ModifiedOn_,
+	}
+	if s.ExternalID_ != "" {
+		data["external_id"] = s.ExternalID_
+	}
+
+	return &DynamoItem{
+		DynamoKey: GetStatusTimelineKey(msgUUID, s),
+		OrgID:     int(orgID),
+		TTL:       s.ModifiedOn_.Add(dynamoStatusTimelineTTL),
+		Data:      data,
+	}
+}
+
+// GetStatusTimelineKey gets the key of the status timeline entry for a status update applied to the given msg, which
+// sort by when the update was created
+func GetStatusTimelineKey(msgUUID courier.MsgUUID, s *StatusUpdate) DynamoKey {
+	pk := fmt.Sprintf("msg#%s", msgUUID)
+	sk := fmt.Sprintf("sts#%s#%s", s.ModifiedOn_.UTC().Format("2006-01-02T15:04:05.000000Z"), s.LogUUID)
+	return DynamoKey{PK: pk, SK: sk}
+}
+
+// MsgStatusTimeline returns the status updates which were applied to the outgoing message with the given UUID
+func (b *backend) MsgStatusTimeline(ctx context.Context, uuid courier.MsgUUID) ([]*courier.StatusTimelineEntry, error) {
+	paginator := dynamodb.NewQueryPaginator(b.dynamo.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(b.dynamo.Name()),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :sk)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("msg#%s", uuid)},
+			":sk": &types.AttributeValueMemberS{Value: "sts#"},
+		},
+	})
+
+	timeline := make([]*courier.StatusTimelineEntry, 0, 5)
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error querying status timeline from dynamo: %w", err)
+		}
+
+		for _, item := range page.Items {
+			entry := &courier.StatusTimelineEntry{}
+			if err := attributevalue.UnmarshalWithOptions(item["Data"], entry, func(o *attributevalue.DecoderOptions) { o.TagKey = "json" }); err != nil {
+				return nil, fmt.Errorf("error unmarshalling status timeline entry: %w", err)
+			}
+			timeline = append(timeline, entry)
+		}
+	}
+
+	return timeline, nil
+}
@@ -34,6 +34,13 @@ DELETE FROM contacts_contact;
 INSERT INTO contacts_contact("id", "is_active", "status", "created_on", "modified_on", "uuid", "language", "ticket_count", "created_by_id", "modified_by_id", "org_id")
                       VALUES(100, True, 'A', now(), now(), 'a984069d-0008-4d8c-a772-b14a8a6acccc', 'eng', 0, 1, 1, 1);
 
+/* Contact fields with ids 1, 2, 3 */
+DELETE FROM contacts_contactfield;
+INSERT INTO contacts_contactfield(id, uuid, key, name, value_type, is_active, org_id) VALUES
+                                 (1, '3b8d8e8e-6b34-4f4c-a1d4-2b6e1e4ffb8a', 'avatar_url', 'Avatar URL', 'T', TRUE, 1),
+                                 (2, '9d2bcd5e-0e3e-4a31-9c43-c6e8d0f2d0d1', 'timezone', 'Timezone', 'T', FALSE, 1),
+                                 (3, 'e2b7ad9b-7c88-4e4a-8a52-4bd0c9fd0a4a', 'age', 'Age', 'N', TRUE, 1);
+
 /** ContactURN with id 1000 */
 DELETE FROM contacts_contacturn;
 INSERT INTO contacts_contacturn("id", "identity", "path", "scheme", "priority", "channel_id", "contact_id", "org_id")
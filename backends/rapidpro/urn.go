@@ -93,7 +93,7 @@ func getURNsForContact(db *sqlx.Tx, contactID ContactID) ([]*ContactURN, error)
 // that the passed in channel is the default one for that URN
 //
 // Note that the URN must be one of the contact's URN before calling this method
-func setDefaultURN(db *sqlx.Tx, channel *Channel, contact *Contact, urn urns.URN, authTokens map[string]string) error {
+func setDefaultURN(db *sqlx.Tx, channel *Channel, contact *Contact, urn urns.URN, authTokens map[string]string, keepDisplay bool) error {
 	scheme := urn.Scheme()
 	contactURNs, err := getURNsForContact(db, contact.ID_)
 	if err != nil {
@@ -111,8 +111,11 @@ func setDefaultURN(db *sqlx.Tx, channel *Channel, contact *Contact, urn urns.URN
 		display := urn.Display()
 
 		// if display, channel id or auth tokens changed, update them
-		if string(contactURNs[0].Display) != display || contactURNs[0].ChannelID != channel.ID() || (authTokens != nil && !utils.MapContains(contactURNs[0].AuthTokens, authTokens)) {
-			contactURNs[0].Display = null.String(display)
+		setDisplay := display != "" || !keepDisplay
+		if (setDisplay && string(contactURNs[0].Display) != display) || contactURNs[0].ChannelID != channel.ID() || (authTokens != nil && !utils.MapContains(contactURNs[0].AuthTokens, authTokens)) {
+			if setDisplay {
+				contactURNs[0].Display = null.String(display)
+			}
 
 			if channel.HasRole(courier.ChannelRoleSend) {
 				contactURNs[0].ChannelID = channel.ID()
@@ -169,8 +172,9 @@ func getContactURNByIdentity(db *sqlx.Tx, org OrgID, urn urns.URN) (*ContactURN,
 }
 
 // getOrCreateContactURN returns the ContactURN for the passed in org and URN, creating and associating
-// it with the passed in contact if necessary
-func getOrCreateContactURN(db *sqlx.Tx, channel *Channel, contactID ContactID, urn urns.URN, authTokens map[string]string) (*ContactURN, error) {
+// it with the passed in contact if necessary. If keepDisplay is set, a URN without a display doesn't clear the display
+// of the existing URN, e.g. because that was described by the channel.
+func getOrCreateContactURN(db *sqlx.Tx, channel *Channel, contactID ContactID, urn urns.URN, authTokens map[string]string, keepDisplay bool) (*ContactURN, error) {
 	contactURN := newContactURN(channel.OrgID(), courier.NilChannelID, contactID, urn, authTokens)
 	if channel.HasRole(courier.ChannelRoleSend) {
 		contactURN.ChannelID = channel.ID()
@@ -189,15 +193,18 @@ func getOrCreateContactURN(db *sqlx.Tx, channel *Channel, contactID ContactID, u
 	}
 
 	display := null.String(urn.Display())
+	setDisplay := display != "" || !keepDisplay
 
 	// make sure our contact URN is up to date
-	if (channel.HasRole(courier.ChannelRoleSend) && contactURN.ChannelID != channel.ID()) || contactURN.ContactID != contactID || contactURN.Display != display {
+	if (channel.HasRole(courier.ChannelRoleSend) && contactURN.ChannelID != channel.ID()) || contactURN.ContactID != contactID || (setDisplay && contactURN.Display != display) {
 		contactURN.PrevContactID = contactURN.ContactID
 		if channel.HasRole(courier.ChannelRoleSend) {
 			contactURN.ChannelID = channel.ID()
 		}
 		contactURN.ContactID = contactID
-		contactURN.Display = display
+		if setDisplay {
+			contactURN.Display = display
+		}
 		err = updateContactURN(db, contactURN)
 		if err != nil {
 			return nil, fmt.Errorf("error updating URN: %w", err)
@@ -0,0 +1,153 @@
+package courier
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+)
+
+// BreakerState is the state of a channel's circuit breaker
+type BreakerState string
+
+// Possible values for BreakerState
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+type breaker struct {
+	state    BreakerState
+	failures int
+	openedOn time.Time
+}
+
+// CircuitBreakers tracks consecutive connection failures for each channel so that sends to a channel whose
+// provider is down can fail fast instead of tying up a sender until they time out. After the threshold of
+// failures is reached the channel's breaker opens, and after the cooldown a single probe send is allowed
+// through which either closes the breaker again or re-opens it.
+type CircuitBreakers struct {
+	threshold int
+	cooldown  time.Duration
+
+	breakers map[ChannelUUID]*breaker
+	mutex    sync.Mutex
+}
+
+// NewCircuitBreakers creates a new set of circuit breakers, a threshold of zero disables them
+func NewCircuitBreakers(threshold int, cooldown time.Duration) *CircuitBreakers {
+	return &CircuitBreakers{
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[ChannelUUID]*breaker),
+	}
+}
+
+// Allow returns whether a send can be attempted on the given channel, and if not, how long until it might be
+func (c *CircuitBreakers) Allow(ch ChannelUUID) (bool, time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	b := c.breakers[ch]
+	if b == nil || b.state == BreakerClosed {
+		return true, 0
+	}
+
+	remaining := c.cooldown - dates.Since(b.openedOn)
+
+	// once cooldown has passed, let a single probe send through
+	if b.state == BreakerOpen && remaining <= 0 {
+		b.state = BreakerHalfOpen
+		return true, 0
+	}
+
+	return false, max(remaining, time.Second)
+}
+
+// Record records the outcome of a send on the given channel, returning the state of its breaker if that changed
+func (c *CircuitBreakers) Record(ch ChannelUUID, connectionFailed bool) (BreakerState, bool) {
+	if c.threshold <= 0 {
+		return BreakerClosed, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	b := c.breakers[ch]
+
+	if !connectionFailed {
+		if b == nil {
+			return BreakerClosed, false
+		}
+
+		delete(c.breakers, ch)
+		return BreakerClosed, b.state != BreakerClosed
+	}
+
+	if b == nil {
+		b = &breaker{state: BreakerClosed}
+		c.breakers[ch] = b
+	}
+
+	b.failures++
+
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= c.threshold) {
+		b.state = BreakerOpen
+		b.openedOn = dates.Now()
+		return BreakerOpen, true
+	}
+
+	return b.state, false
+}
+
+// Skip records that a send on the given channel didn't tell us whether its provider is up, e.g. because it was
+// throttled, so if it was the probe, the next send is let through as the probe instead
+func (c *CircuitBreakers) Skip(ch ChannelUUID) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if b := c.breakers[ch]; b != nil && b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+	}
+}
+
+// State returns the current state of the breaker for the given channel
+func (c *CircuitBreakers) State(ch ChannelUUID) BreakerState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if b := c.breakers[ch]; b != nil {
+		return b.state
+	}
+	return BreakerClosed
+}
+
+// Status returns a string describing any channels whose breakers aren't closed
+func (c *CircuitBreakers) Status() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	channels := make([]ChannelUUID, 0, len(c.breakers))
+	for ch, b := range c.breakers {
+		if b.state != BreakerClosed {
+			channels = append(channels, ch)
+		}
+	}
+	slices.Sort(channels)
+
+	status := bytes.Buffer{}
+	status.WriteString("------------------------------------------------------------------------------------\n")
+	status.WriteString("     State | Failures | Opened               | Channel              \n")
+	status.WriteString("------------------------------------------------------------------------------------\n")
+
+	for _, ch := range channels {
+		b := c.breakers[ch]
+		status.WriteString(fmt.Sprintf("% 10s   % 8d   %s   %s\n", b.state, b.failures, b.openedOn.UTC().Format(time.DateTime), ch))
+	}
+
+	return status.String()
+}
@@ -0,0 +1,98 @@
+package courier_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakers(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	dates.SetNowFunc(func() time.Time { return now })
+	defer dates.SetNowFunc(time.Now)
+
+	ch1 := courier.ChannelUUID("e4bb1578-29da-4fa5-a214-9da19dd24230")
+	ch2 := courier.ChannelUUID("53e5aafa-8155-449d-9009-fcb30d54bd26")
+
+	breakers := courier.NewCircuitBreakers(3, 30*time.Second)
+
+	assertAllowed := func(ch courier.ChannelUUID, expectedAllowed bool, expectedWait time.Duration) {
+		allowed, wait := breakers.Allow(ch)
+		assert.Equal(t, expectedAllowed, allowed)
+		assert.Equal(t, expectedWait, wait)
+	}
+
+	assertAllowed(ch1, true, 0)
+
+	// failures below our threshold don't change anything
+	breakers.Record(ch1, true)
+	state, changed := breakers.Record(ch1, true)
+	assert.Equal(t, courier.BreakerClosed, state)
+	assert.False(t, changed)
+	assertAllowed(ch1, true, 0)
+
+	// a success resets our count
+	state, changed = breakers.Record(ch1, false)
+	assert.Equal(t, courier.BreakerClosed, state)
+	assert.False(t, changed)
+
+	breakers.Record(ch1, true)
+	breakers.Record(ch1, true)
+	state, changed = breakers.Record(ch1, true)
+	assert.Equal(t, courier.BreakerOpen, state)
+	assert.True(t, changed)
+	assert.Equal(t, courier.BreakerOpen, breakers.State(ch1))
+
+	// channel 1 now fails fast, other channels are unaffected
+	assertAllowed(ch1, false, 30*time.Second)
+	assertAllowed(ch2, true, 0)
+	assert.Contains(t, breakers.Status(), "      open          3   2025-03-01 12:00:00   e4bb1578-29da-4fa5-a214-9da19dd24230")
+
+	now = now.Add(20 * time.Second)
+	assertAllowed(ch1, false, 10*time.Second)
+
+	// once cooldown has passed we let a single probe through
+	now = now.Add(10 * time.Second)
+	assertAllowed(ch1, true, 0)
+	assert.Equal(t, courier.BreakerHalfOpen, breakers.State(ch1))
+	assertAllowed(ch1, false, time.Second)
+
+	// probe fails so we re-open
+	state, changed = breakers.Record(ch1, true)
+	assert.Equal(t, courier.BreakerOpen, state)
+	assert.True(t, changed)
+	assertAllowed(ch1, false, 30*time.Second)
+
+	// next probe is throttled which tells us nothing so another probe is let through
+	now = now.Add(30 * time.Second)
+	assertAllowed(ch1, true, 0)
+	breakers.Skip(ch1)
+	assert.Equal(t, courier.BreakerOpen, breakers.State(ch1))
+
+	// which succeeds so we close
+	assertAllowed(ch1, true, 0)
+
+	state, changed = breakers.Record(ch1, false)
+	assert.Equal(t, courier.BreakerClosed, state)
+	assert.True(t, changed)
+	assertAllowed(ch1, true, 0)
+	assert.NotContains(t, breakers.Status(), string(ch1))
+
+	// throttling doesn't reset or add to the count of failures
+	breakers.Record(ch1, true)
+	breakers.Record(ch1, true)
+	breakers.Skip(ch1)
+	state, changed = breakers.Record(ch1, true)
+	assert.Equal(t, courier.BreakerOpen, state)
+	assert.True(t, changed)
+
+	// a threshold of zero disables breakers
+	breakers = courier.NewCircuitBreakers(0, 30*time.Second)
+	for range 10 {
+		breakers.Record(ch1, true)
+	}
+	assertAllowed(ch1, true, 0)
+}
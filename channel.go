@@ -29,6 +29,9 @@ const (
 	// ConfigMaxLength is the maximum size of a message in characters
 	ConfigMaxLength = "max_length"
 
+	// ConfigMaxInFlight is the maximum number of messages that can be sent concurrently on a channel
+	ConfigMaxInFlight = "max_in_flight"
+
 	// ConfigPassword is a constant key for channel configs
 	ConfigPassword = "password"
 
@@ -55,6 +58,36 @@ const (
 
 	// ConfigSendHeaders is a constant key for channel configs
 	ConfigSendHeaders = "headers"
+
+	// ConfigSendWindow is the daily window of local time, e.g. "08:00-20:00", during which bulk messages can be sent
+	ConfigSendWindow = "send_window"
+
+	// ConfigSendWindowAllPriorities is whether the send window also applies to high priority messages
+	ConfigSendWindowAllPriorities = "send_window_all_priorities"
+
+	// ConfigTimezone is the timezone of the channel, used instead of the timezone of its country, and required for send
+	// windows in countries with more than one timezone
+	ConfigTimezone = "timezone"
+
+	// ConfigDryRun is whether sends on the channel should be captured rather than made to the channel
+	ConfigDryRun = "dry_run"
+
+	// ConfigTPS is the maximum number of messages per second that can be sent on the channel, which once the channel is
+	// loaded or its queue is updated, applies to messages already queued as well as new ones
+	ConfigTPS = "tps"
+
+	// ConfigRateLimits is a comma separated list of limits on the number of messages that can be sent on the channel in
+	// any second, minute, hour or day, e.g. "30/s+10,1000/m,50000/d", where each can have a burst which spreads sends
+	// evenly across the window with no more than that many at once, and which apply to its queue like its TPS
+	ConfigRateLimits = "rate_limits"
+
+	// ConfigURNSendGap is the minimum number of seconds between sends to the same URN on the channel. Regardless of this,
+	// only one message is ever sent to a URN on a channel at a time.
+	ConfigURNSendGap = "urn_send_gap"
+
+	// ConfigDedupWindow is the number of seconds (max 3600) within which a message with the same content as one already
+	// sent to the same URN is suppressed
+	ConfigDedupWindow = "dedup_window"
 )
 
 // ChannelType is the 1-3 letter code used for channel types in the database
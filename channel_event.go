@@ -40,6 +40,7 @@ type ChannelEvent interface {
 	OccurredOn() time.Time
 
 	WithContactName(name string) ChannelEvent
+	WithContactLanguage(locale string) ChannelEvent
 	WithURNAuthTokens(tokens map[string]string) ChannelEvent
 	WithExtra(extra map[string]string) ChannelEvent
 	WithOccurredOn(time.Time) ChannelEvent
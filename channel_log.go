@@ -16,6 +16,7 @@ const (
 	ChannelLogTypeMultiReceive    clogs.Type = "multi_receive"
 	ChannelLogTypeAttachmentFetch clogs.Type = "attachment_fetch"
 	ChannelLogTypeTokenRefresh    clogs.Type = "token_refresh"
+	ChannelLogTypeContactRefresh  clogs.Type = "contact_refresh"
 	ChannelLogTypePageSubscribe   clogs.Type = "page_subscribe"
 	ChannelLogTypeWebhookVerify   clogs.Type = "webhook_verify"
 )
@@ -61,6 +62,7 @@ type ChannelLog struct {
 	*clogs.Log
 
 	channel Channel
+	dryRun  bool
 }
 
 // NewChannelLogForIncoming creates a new channel log for an incoming request, the type of which won't be known
@@ -100,6 +102,16 @@ func (l *ChannelLog) Channel() Channel {
 	return l.channel
 }
 
+// SetDryRun sets whether HTTP requests made for this log should be captured rather than made
+func (l *ChannelLog) SetDryRun(dryRun bool) {
+	l.dryRun = dryRun
+}
+
+// DryRun returns whether HTTP requests made for this log should be captured rather than made
+func (l *ChannelLog) DryRun() bool {
+	return l.dryRun
+}
+
 // if we have an error or a non 2XX/3XX http response then log is considered an error
 func (l *ChannelLog) IsError() bool {
 	if len(l.Errors) > 0 {
@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/nyaruka/courier/utils"
@@ -51,6 +52,20 @@ type Config struct {
 	DisallowedNetworks string     `help:"comma separated list of IP addresses and networks which we disallow fetching attachments from"`
 	MediaDomain        string     `help:"the domain on which we'll try to resolve outgoing media URLs"`
 	MaxWorkers         int        `help:"the maximum number of go routines that will be used for sending (set to 0 to disable sending)"`
+	MaxInFlight        int        `help:"the default maximum number of concurrent sends for a single channel (set to 0 for no limit)"`
+	MaxInFlightByType  string     `help:"comma separated list of channel_type:limit pairs which override the default maximum concurrent sends for a channel type"`
+	SendHoldTimeout    int        `help:"the number of seconds a msg for a channel at its maximum concurrent sends is held before it's returned to the queue"`
+	OriginPriorities   string     `help:"comma separated list of origin:priority pairs, from 0 for bulk to 3, which override the priority msgs of that origin are sent with"`
+	SendRetries        int        `help:"the number of times courier will requeue a msg whose send failed with a retryable error, rather than marking it errored for mailroom to retry (set to 0 to disable)"`
+	SendRetryDelay     int        `help:"the delay in seconds before the first retry of a failed send, doubled for each subsequent retry"`
+	SendRetryMaxDelay  int        `help:"the maximum delay in seconds before a retry of a failed send, which doubling the delay for each retry stops at"`
+	BreakerThreshold   int        `help:"the number of consecutive connection failures after which sending on a channel is paused (set to 0 to disable)"`
+	BreakerCooldown    int        `help:"the number of seconds sending on a channel is paused before a probe send is attempted"`
+	DrainTimeout       int        `help:"the number of seconds in-flight sends are given to complete when stopping, after which they are cancelled"`
+	RequeueExpired     bool       `help:"whether msgs whose send never completed, e.g. because an instance crashed, should be requeued once their lease expires"`
+	DryRun             bool       `help:"whether sends on all channels should be captured rather than made to the channels, for testing"`
+	ContactRefreshDays int        `help:"the number of days after which existing contacts are described again by their channel when they send a msg or event (set to 0 to disable)"`
+	KeepURNDisplays    bool       `help:"whether a URN received without a display keeps the display an existing contact URN has, e.g. a username described by its channel, rather than clearing it"`
 	LibratoUsername    string     `help:"the username that will be used to authenticate to Librato"`
 	LibratoToken       string     `help:"the token that will be used to authenticate to Librato"`
 	StatusUsername     string     `help:"the username that is needed to authenticate against the /status endpoint"`
@@ -101,6 +116,13 @@ func NewDefaultConfig() *Config {
 
 		DisallowedNetworks: `127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,169.254.0.0/16,fe80::/10`,
 		MaxWorkers:         32,
+		SendRetries:        0,
+		SendRetryDelay:     5,
+		SendRetryMaxDelay:  3600,
+		BreakerThreshold:   5,
+		BreakerCooldown:    30,
+		SendHoldTimeout:    30,
+		DrainTimeout:       15,
 		LogLevel:           slog.LevelWarn,
 		Version:            "Dev",
 	}
@@ -128,6 +150,12 @@ func (c *Config) Validate() error {
 	if _, _, err := c.ParseDisallowedNetworks(); err != nil {
 		return fmt.Errorf("unable to parse 'DisallowedNetworks': %w", err)
 	}
+	if _, err := c.ParseMaxInFlightByType(); err != nil {
+		return fmt.Errorf("unable to parse 'MaxInFlightByType': %w", err)
+	}
+	if _, err := c.ParseOriginPriorities(); err != nil {
+		return fmt.Errorf("unable to parse 'OriginPriorities': %w", err)
+	}
 	return nil
 }
 
@@ -140,3 +168,66 @@ func (c *Config) ParseDisallowedNetworks() ([]net.IP, []*net.IPNet, error) {
 
 	return httpx.ParseNetworks(addrs...)
 }
+
+// ParseMaxInFlightByType parses the list of channel type concurrent send limits, e.g. "WAC:10,TG:5"
+func (c *Config) ParseMaxInFlightByType() (map[ChannelType]int, error) {
+	limits := make(map[ChannelType]int)
+	if c.MaxInFlightByType == "" {
+		return limits, nil
+	}
+
+	for _, pair := range strings.Split(c.MaxInFlightByType, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid channel type limit '%s'", pair)
+		}
+		limit, err := strconv.Atoi(parts[1])
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit for channel type '%s'", parts[0])
+		}
+		limits[ChannelType(parts[0])] = limit
+	}
+
+	return limits, nil
+}
+
+// ParseOriginPriorities parses the list of msg origin priorities, e.g. "ticket:3,chat:3,flow:2,broadcast:0"
+func (c *Config) ParseOriginPriorities() (map[MsgOrigin]int, error) {
+	priorities := make(map[MsgOrigin]int)
+	if c.OriginPriorities == "" {
+		return priorities, nil
+	}
+
+	for _, pair := range strings.Split(c.OriginPriorities, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid origin priority '%s'", pair)
+		}
+		priority, err := strconv.Atoi(parts[1])
+		if err != nil || priority < 0 || priority > 3 {
+			return nil, fmt.Errorf("invalid priority for origin '%s'", parts[0])
+		}
+		priorities[MsgOrigin(parts[0])] = priority
+	}
+
+	return priorities, nil
+}
+
+// ChannelMaxInFlight returns the maximum number of concurrent sends for the given channel, taking into account
+// the default limit, any limit for the channel type, and any override in the channel's own config. Zero means
+// there is no limit.
+func (c *Config) ChannelMaxInFlight(ch Channel) int {
+	byType, _ := c.ParseMaxInFlightByType()
+
+	return channelMaxInFlight(ch, c.MaxInFlight, byType)
+}
+
+// channelMaxInFlight returns the maximum number of concurrent sends for the given channel from already parsed limits
+func channelMaxInFlight(ch Channel, defaultLimit int, byType map[ChannelType]int) int {
+	limit := defaultLimit
+	if typeLimit, found := byType[ch.ChannelType()]; found {
+		limit = typeLimit
+	}
+
+	return ch.IntConfigForKey(ConfigMaxInFlight, limit)
+}
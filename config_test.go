@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,3 +26,48 @@ func TestConfigValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestChannelMaxInFlight(t *testing.T) {
+	config := courier.NewDefaultConfig()
+	config.MaxInFlightByType = "FOO"
+
+	err := config.Validate()
+	assert.EqualError(t, err, "unable to parse 'MaxInFlightByType': invalid channel type limit 'FOO'")
+
+	config.MaxInFlightByType = "TG:x"
+	_, err = config.ParseMaxInFlightByType()
+	assert.EqualError(t, err, "invalid limit for channel type 'TG'")
+
+	config.MaxInFlight = 10
+	config.MaxInFlightByType = "TG:5, WAC:20"
+
+	byType, err := config.ParseMaxInFlightByType()
+	assert.NoError(t, err)
+	assert.Equal(t, map[courier.ChannelType]int{"TG": 5, "WAC": 20}, byType)
+
+	tg := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "TG", "2020", "US", []string{urns.Telegram.Prefix}, map[string]any{})
+	wac := test.NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "WAC", "2020", "US", []string{urns.WhatsApp.Prefix}, map[string]any{courier.ConfigMaxInFlight: 2})
+	ex := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "EX", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+
+	assert.Equal(t, 5, config.ChannelMaxInFlight(tg))  // from channel type
+	assert.Equal(t, 2, config.ChannelMaxInFlight(wac)) // overridden by channel config
+	assert.Equal(t, 10, config.ChannelMaxInFlight(ex)) // default
+}
+
+func TestOriginPriorities(t *testing.T) {
+	config := courier.NewDefaultConfig()
+	config.OriginPriorities = "FOO"
+
+	err := config.Validate()
+	assert.EqualError(t, err, "unable to parse 'OriginPriorities': invalid origin priority 'FOO'")
+
+	config.OriginPriorities = "ticket:4"
+	_, err = config.ParseOriginPriorities()
+	assert.EqualError(t, err, "invalid priority for origin 'ticket'")
+
+	config.OriginPriorities = "ticket:3, flow:2,broadcast:0"
+
+	priorities, err := config.ParseOriginPriorities()
+	assert.NoError(t, err)
+	assert.Equal(t, map[courier.MsgOrigin]int{"ticket": 3, "flow": 2, "broadcast": 0}, priorities)
+}
@@ -1,7 +1,11 @@
 package courier
 
 import (
+	"strings"
+
+	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/uuids"
+	"golang.org/x/text/language"
 )
 
 // ContactUUID is our typing of a contact's UUID
@@ -10,6 +14,28 @@ type ContactUUID uuids.UUID
 // NilContactUUID is our nil value for contact UUIDs
 var NilContactUUID = ContactUUID("")
 
+// ParseContactLanguage converts a locale or language code reported by a channel, e.g. "en", "pt_BR", "es-419" or "fra",
+// to an ISO-639-3 language, returning NilLanguage if it isn't recognized
+func ParseContactLanguage(locale string) i18n.Language {
+	code, _, _ := strings.Cut(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"), "-")
+	code = strings.ToLower(code)
+
+	// 2-letter codes need converting to their 3-letter equivalents
+	if len(code) == 2 {
+		base, err := language.ParseBase(code)
+		if err != nil {
+			return i18n.NilLanguage
+		}
+		code = base.ISO3()
+	}
+
+	lang, err := i18n.ParseLanguage(code)
+	if err != nil || lang == "und" {
+		return i18n.NilLanguage
+	}
+	return lang
+}
+
 //-----------------------------------------------------------------------------
 // Contact Interface
 //-----------------------------------------------------------------------------
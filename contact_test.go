@@ -0,0 +1,34 @@
+package courier_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/i18n"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseContactLanguage(t *testing.T) {
+	tcs := []struct {
+		locale   string
+		expected i18n.Language
+	}{
+		{"en", "eng"},
+		{"EN", "eng"},
+		{"en-US", "eng"},
+		{"pt_BR", "por"},
+		{"es-419", "spa"},
+		{"fra", "fra"},
+		{"zh-Hans", "zho"},
+		{" rw ", "kin"},
+		{"", i18n.NilLanguage},
+		{"und", i18n.NilLanguage},
+		{"xx", i18n.NilLanguage},
+		{"english", i18n.NilLanguage},
+		{"123", i18n.NilLanguage},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, courier.ParseContactLanguage(tc.locale), "language mismatch for locale '%s'", tc.locale)
+	}
+}
@@ -0,0 +1,53 @@
+package courier
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/nyaruka/courier/utils/clogs"
+)
+
+// DryRunClient returns a copy of the given client whose requests are never made but answered with an empty 200
+// response, so that they can still be traced to the channel log.
+func DryRunClient(client *http.Client) *http.Client {
+	c := *client
+	c.Transport = dryRunTransport{}
+	c.CheckRedirect = nil
+	return &c
+}
+
+type dryRunTransport struct{}
+
+func (t dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"X-Courier-Dry-Run": []string{"true"}, "Content-Length": []string{"0"}},
+		Body:          io.NopCloser(bytes.NewReader(nil)),
+		ContentLength: 0,
+		Request:       req,
+	}, nil
+}
+
+// dryRunError is the error added to the channel log of a dry run send so that it's clear the msg wasn't really sent.
+// If the handler returned an error, which will usually be from parsing the faked response, that's tagged instead so
+// it's not mistaken for a real failure to send.
+func dryRunError(err error) *clogs.Error {
+	var serr *SendError
+	if err == nil {
+		return &clogs.Error{Code: "dry_run", Message: "Dry run: message was captured rather than sent to the channel."}
+	} else if errors.As(err, &serr) {
+		return &clogs.Error{Code: "dry_run", ExtCode: serr.clogCode, Message: "Dry run: " + serr.clogMsg}
+	}
+	return &clogs.Error{Code: "dry_run", Message: "Dry run: " + err.Error()}
+}
@@ -35,11 +35,21 @@ type ChannelHandler interface {
 	WriteRequestIgnored(context.Context, http.ResponseWriter, string) error
 }
 
-// URNDescriber is the interface handlers which can look up URN metadata for new contacts should satisfy.
+// URNDescriber is the interface handlers which can look up URN metadata for new contacts, and for refreshing existing
+// contacts, should satisfy. Any returned attributes other than the name, username and language are saved as contact
+// field values, e.g. the avatar URL.
 type URNDescriber interface {
 	DescribeURN(context.Context, Channel, urns.URN, *ChannelLog) (map[string]string, error)
 }
 
+// attributes a URN describer can return
+const (
+	URNAttrName      = "name"       // the name of the contact
+	URNAttrUsername  = "username"   // the username of the URN which is saved as its display value
+	URNAttrLanguage  = "language"   // the ISO-639-3 code of the contact's language
+	URNAttrAvatarURL = "avatar_url" // the URL of the contact's profile picture
+)
+
 // AttachmentRequestBuilder is the interface handlers which can allow a custom way to download attachment media for messages should satisfy
 type AttachmentRequestBuilder interface {
 	BuildAttachmentRequest(context.Context, Backend, Channel, string, *ChannelLog) (*http.Request, error)
@@ -117,7 +117,14 @@ func (h *BaseHandler) RequestHTTPWithClient(client *http.Client, req *http.Reque
 
 	req.Header.Set("User-Agent", fmt.Sprintf("Courier/%s", h.server.Config().Version))
 
-	trace, err := httpx.DoTrace(client, req, nil, h.backend.HttpAccess(), 0)
+	access := h.backend.HttpAccess()
+
+	// in dry run mode the request is captured instead of being made
+	if clog.DryRun() {
+		client, access = courier.DryRunClient(client), nil
+	}
+
+	trace, err := httpx.DoTrace(client, req, nil, access, 0)
 	if trace != nil {
 		clog.HTTP(trace)
 		resp = trace.Response
@@ -2,6 +2,7 @@ package handlers_test
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/nyaruka/courier"
@@ -53,3 +54,30 @@ func TestRequestHTTP(t *testing.T) {
 	assert.Equal(t, 400, hlog2.StatusCode)
 	assert.Equal(t, "https://api.messages.com/send.json", hlog2.URL)
 }
+
+func TestRequestHTTPDryRun(t *testing.T) {
+	mb := test.NewMockBackend()
+	mc := test.NewMockChannel("7a8ff1d4-f211-4492-9d05-e1905f6da8c8", "NX", "1234", "EC", []string{urns.Phone.Prefix}, nil)
+	mm := mb.NewOutgoingMsg(mc, 123, urns.URN("tel:+1234"), "Hello World", false, nil, "", courier.MsgOriginChat, nil)
+	clog := courier.NewChannelLogForSend(mm, nil)
+	clog.SetDryRun(true)
+
+	server := test.NewMockServer(courier.NewDefaultConfig(), mb)
+
+	h := handlers.NewBaseHandler("NX", "Test")
+	h.SetServer(server)
+
+	// request is captured rather than made to the unresolvable host
+	req, _ := http.NewRequest("POST", "https://api.messages.invalid/send.json", strings.NewReader(`{"text":"Hello World"}`))
+	resp, respBody, err := h.RequestHTTP(req, clog)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("X-Courier-Dry-Run"))
+	assert.Len(t, respBody, 0)
+	assert.Len(t, clog.HttpLogs, 1)
+
+	hlog := clog.HttpLogs[0]
+	assert.Equal(t, 200, hlog.StatusCode)
+	assert.Equal(t, "https://api.messages.invalid/send.json", hlog.URL)
+	assert.Contains(t, hlog.Request, `{"text":"Hello World"}`)
+}
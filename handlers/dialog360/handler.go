@@ -301,6 +301,11 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	}
 
 	for _, payload := range requestPayloads {
+		// WhatsApp has no time to live for session msgs so don't send any more parts once the msg has expired
+		if handlers.MsgExpired(msg) {
+			return courier.ErrMsgExpired
+		}
+
 		err := h.requestD3C(payload, accessToken, res, sendURL, clog)
 		if err != nil {
 			return err
@@ -325,6 +330,8 @@ func (h *handler) requestD3C(payload whatsapp.SendRequest, accessToken string, r
 	resp, respBody, err := h.RequestHTTP(req, clog)
 	if err != nil || resp.StatusCode/100 == 5 {
 		return courier.ErrConnectionFailed
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		return courier.ErrRetryAfter(courier.ErrConnectionThrottled, handlers.RetryAfter(resp))
 	}
 	respPayload := &whatsapp.SendResponse{}
 	err = json.Unmarshal(respBody, respPayload)
@@ -316,6 +316,8 @@ func TestBuildAttachmentRequest(t *testing.T) {
 
 }
 
+var expiredOn = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
 var SendTestCasesD3C = []OutgoingTestCase{
 	{
 		Label:   "Plain Send",
@@ -740,6 +742,13 @@ var SendTestCasesD3C = []OutgoingTestCase{
 		},
 		ExpectedError: courier.ErrConnectionFailed,
 	},
+	{
+		Label:         "Expired",
+		MsgText:       "Your code is 1234",
+		MsgURN:        "whatsapp:250788123123",
+		MsgExpiresOn:  &expiredOn,
+		ExpectedError: courier.ErrMsgExpired,
+	},
 }
 
 func TestOutgoing(t *testing.T) {
@@ -15,6 +15,7 @@ import (
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -607,7 +608,7 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 	u := base.ResolveReference(path)
 
 	query := url.Values{}
-	query.Set("fields", "first_name,last_name")
+	query.Set("fields", "first_name,last_name,locale")
 	query.Set("access_token", accessToken)
 	u.RawQuery = query.Encode()
 	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
@@ -617,11 +618,16 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 		return nil, errors.New("unable to look up contact data")
 	}
 
-	// read our first and last name
+	// read our first and last name, and the language of the profile's locale
 	firstName, _ := jsonparser.GetString(respBody, "first_name")
 	lastName, _ := jsonparser.GetString(respBody, "last_name")
+	locale, _ := jsonparser.GetString(respBody, "locale")
 
-	return map[string]string{"name": utils.JoinNonEmpty(" ", firstName, lastName)}, nil
+	attrs := map[string]string{courier.URNAttrName: utils.JoinNonEmpty(" ", firstName, lastName)}
+	if lang := courier.ParseContactLanguage(locale); lang != i18n.NilLanguage {
+		attrs[courier.URNAttrLanguage] = string(lang)
+	}
+	return attrs, nil
 }
 
 func IsFacebookRef(u urns.URN) bool {
@@ -144,6 +144,7 @@ type mtPayload struct {
 		Token        string          `json:"token"`
 		Android      struct {
 			Priority string `json:"priority"`
+			TTL      string `json:"ttl,omitempty"`
 		} `json:"android,omitempty"`
 	} `json:"message"`
 }
@@ -161,6 +162,7 @@ type mtAPIKeyPayload struct {
 	ContentAvailable bool            `json:"content_available"`
 	To               string          `json:"to"`
 	Priority         string          `json:"priority"`
+	TimeToLive       int             `json:"time_to_live,omitempty"`
 }
 
 type mtNotification struct {
@@ -210,6 +212,7 @@ func (h *handler) sendWithAPIKey(msg courier.MsgOut, res *courier.SendResult, cl
 
 		payload.To = msg.URNAuth()
 		payload.Priority = "high"
+		payload.TimeToLive = int(handlers.MsgTTL(msg).Seconds())
 
 		if notification {
 			payload.Notification = &mtNotification{
@@ -295,6 +298,9 @@ func (h *handler) sendWithCredsJSON(msg courier.MsgOut, res *courier.SendResult,
 
 		payload.Message.Token = msg.URNAuth()
 		payload.Message.Android.Priority = "high"
+		if ttl := handlers.MsgTTL(msg); ttl > 0 {
+			payload.Message.Android.TTL = fmt.Sprintf("%ds", int(ttl.Seconds()))
+		}
 
 		if notification {
 			payload.Message.Notification = &mtNotification{
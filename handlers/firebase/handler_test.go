@@ -7,6 +7,7 @@ import (
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -135,6 +136,8 @@ func BenchmarkHandler(b *testing.B) {
 	RunChannelBenchmarks(b, testChannels, newHandler(), testCases)
 }
 
+var msgExpiresOn = time.Date(2025, 3, 1, 12, 5, 0, 0, time.UTC)
+
 var notificationSendAPIkeyTestCases = []OutgoingTestCase{
 	{
 		Label:      "Plain Send",
@@ -171,6 +174,23 @@ var sendAPIkeyTestCases = []OutgoingTestCase{
 		}},
 		ExpectedExtIDs: []string{"123456"},
 	},
+	{
+		Label:        "Send with expiry",
+		MsgText:      "Simple Message",
+		MsgURN:       "fcm:250788123123",
+		MsgURNAuth:   "auth1",
+		MsgExpiresOn: &msgExpiresOn,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://fcm.googleapis.com/fcm/send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"success":1, "multicast_id": 123456}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Authorization": "key=FCMKey"},
+			Body:    `{"data":{"type":"rapidpro","title":"FCMTitle","message":"Simple Message","message_id":10,"session_status":""},"content_available":false,"to":"auth1","priority":"high","time_to_live":300}`,
+		}},
+		ExpectedExtIDs: []string{"123456"},
+	},
 	{
 		Label:      "Long Message",
 		MsgText:    longMsg,
@@ -298,6 +318,23 @@ var sendTestCases = []OutgoingTestCase{
 		}},
 		ExpectedExtIDs: []string{"123456-a"},
 	},
+	{
+		Label:        "Send with expiry",
+		MsgText:      "Simple Message",
+		MsgURN:       "fcm:250788123123",
+		MsgURNAuth:   "auth1",
+		MsgExpiresOn: &msgExpiresOn,
+		MockResponses: map[string][]*httpx.MockResponse{
+			"https://fcm.googleapis.com/v1/projects/foo-project-id/messages:send": {
+				httpx.NewMockResponse(200, nil, []byte(`{"name":"projects/foo-project-id/messages/123456-a"}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Headers: map[string]string{"Authorization": "Bearer FCMToken"},
+			Body:    `{"message":{"data":{"type":"rapidpro","title":"FCMTitle","message":"Simple Message","message_id":"10","session_status":""},"token":"auth1","android":{"priority":"high","ttl":"300s"}}}`,
+		}},
+		ExpectedExtIDs: []string{"123456-a"},
+	},
 	{
 		Label:      "Long Message",
 		MsgText:    longMsg,
@@ -445,6 +482,9 @@ func setupBackend(mb *test.MockBackend) {
 }
 
 func TestOutgoing(t *testing.T) {
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)))
+	defer dates.SetNowFunc(time.Now)
+
 	RunOutgoingTestCases(t, testChannels[0], newHandler(), sendAPIkeyTestCases, []string{"FCMKey"}, setupBackend)
 	RunOutgoingTestCases(t, testChannels[1], newHandler(), notificationSendAPIkeyTestCases, []string{"FCMKey"}, setupBackend)
 
@@ -309,7 +309,8 @@ func TestFacebookDescribeURN(t *testing.T) {
 		urn              urns.URN
 		expectedMetadata map[string]string
 	}{
-		{"facebook:1337", map[string]string{"name": "John Doe"}},
+		{"facebook:1337", map[string]string{"name": "John Doe", "language": "por"}},
+		{"facebook:2468", map[string]string{"name": "Jane"}},
 		{"facebook:4567", map[string]string{"name": ""}},
 		{"facebook:ref:1337", map[string]string{}},
 	}
@@ -743,9 +744,14 @@ func buildMockFBGraphFBA(testCases []IncomingTestCase) *httptest.Server {
 			http.Error(w, "invalid auth token", http.StatusForbidden)
 		}
 
-		// user has a name
+		// user has a name and a locale
 		if strings.HasSuffix(r.URL.Path, "1337") {
-			w.Write([]byte(`{ "first_name": "John", "last_name": "Doe"}`))
+			w.Write([]byte(`{ "first_name": "John", "last_name": "Doe", "locale": "pt_BR"}`))
+			return
+		}
+		// user has a locale we don't recognize
+		if strings.HasSuffix(r.URL.Path, "2468") {
+			w.Write([]byte(`{ "first_name": "Jane", "last_name": "", "locale": "zz_ZZ"}`))
 			return
 		}
 		// no name
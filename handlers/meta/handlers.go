@@ -22,6 +22,7 @@ import (
 	"github.com/nyaruka/courier/handlers/meta/messenger"
 	"github.com/nyaruka/courier/handlers/meta/whatsapp"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -880,6 +881,8 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.MsgO
 		resp, respBody, err := h.RequestHTTP(req, clog)
 		if err != nil || resp.StatusCode/100 == 5 {
 			return courier.ErrConnectionFailed
+		} else if resp.StatusCode == http.StatusTooManyRequests {
+			return courier.ErrRetryAfter(courier.ErrConnectionThrottled, handlers.RetryAfter(resp))
 		} else if resp.StatusCode/100 != 2 {
 			return courier.ErrResponseStatus
 		}
@@ -954,6 +957,11 @@ func (h *handler) sendWhatsAppMsg(ctx context.Context, msg courier.MsgOut, res *
 	}
 
 	for _, payload := range requestPayloads {
+		// WhatsApp has no time to live for session msgs so don't send any more parts once the msg has expired
+		if handlers.MsgExpired(msg) {
+			return courier.ErrMsgExpired
+		}
+
 		err := h.requestWAC(payload, accessToken, res, wacPhoneURL, clog)
 		if err != nil {
 			return err
@@ -986,8 +994,8 @@ func (h *handler) requestWAC(payload whatsapp.SendRequest, accessToken string, r
 		return courier.ErrResponseUnparseable
 	}
 
-	if slices.Contains(wacThrottlingErrorCodes, respPayload.Error.Code) {
-		return courier.ErrConnectionThrottled
+	if resp.StatusCode == http.StatusTooManyRequests || slices.Contains(wacThrottlingErrorCodes, respPayload.Error.Code) {
+		return courier.ErrRetryAfter(courier.ErrConnectionThrottled, handlers.RetryAfter(resp))
 	}
 
 	if respPayload.Error.Code != 0 {
@@ -1025,7 +1033,7 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 	var name string
 
 	if fmt.Sprint(channel.ChannelType()) == "FBA" {
-		query.Set("fields", "first_name,last_name")
+		query.Set("fields", "first_name,last_name,locale")
 	}
 
 	query.Set("access_token", accessToken)
@@ -1037,17 +1045,24 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 		return nil, errors.New("unable to look up contact data")
 	}
 
-	// read our first and last name	or complete name
+	attrs := make(map[string]string, 2)
+
+	// read our first and last name	or complete name, and for facebook, the language of the profile's locale
 	if fmt.Sprint(channel.ChannelType()) == "FBA" {
 		firstName, _ := jsonparser.GetString(respBody, "first_name")
 		lastName, _ := jsonparser.GetString(respBody, "last_name")
 		name = utils.JoinNonEmpty(" ", firstName, lastName)
+
+		locale, _ := jsonparser.GetString(respBody, "locale")
+		if lang := courier.ParseContactLanguage(locale); lang != i18n.NilLanguage {
+			attrs[courier.URNAttrLanguage] = string(lang)
+		}
 	} else {
 		name, _ = jsonparser.GetString(respBody, "name")
 	}
 
-	return map[string]string{"name": name}, nil
-
+	attrs[courier.URNAttrName] = name
+	return attrs, nil
 }
 
 // see https://developers.facebook.com/docs/messenger-platform/webhook#security
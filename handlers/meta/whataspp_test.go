@@ -283,6 +283,8 @@ func TestWhatsAppIncoming(t *testing.T) {
 	RunIncomingTestCases(t, whatsappTestChannels, newHandler("WAC", "Cloud API WhatsApp"), whatsappIncomingTests)
 }
 
+var expiredOn = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
 var whatsappOutgoingTests = []OutgoingTestCase{
 	{
 		Label:   "Plain Send",
@@ -741,6 +743,17 @@ var whatsappOutgoingTests = []OutgoingTestCase{
 		},
 		ExpectedError: courier.ErrConnectionThrottled,
 	},
+	{
+		Label:   "Error Too Many Requests",
+		MsgText: "Error",
+		MsgURN:  "whatsapp:250788123123",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/12345_ID/messages": {
+				httpx.NewMockResponse(429, map[string]string{"Retry-After": "60"}, []byte(`{ "error": {"message": "(#80007) Rate limit issues","code": 80007 }}`)),
+			},
+		},
+		ExpectedError: courier.ErrRetryAfter(courier.ErrConnectionThrottled, time.Minute),
+	},
 	{
 		Label:   "Error Throttled",
 		MsgText: "Error",
@@ -774,6 +787,13 @@ var whatsappOutgoingTests = []OutgoingTestCase{
 		},
 		ExpectedError: courier.ErrConnectionFailed,
 	},
+	{
+		Label:         "Expired",
+		MsgText:       "Your code is 1234",
+		MsgURN:        "whatsapp:250788123123",
+		MsgExpiresOn:  &expiredOn,
+		ExpectedError: courier.ErrMsgExpired,
+	},
 }
 
 func TestWhatsAppOutgoing(t *testing.T) {
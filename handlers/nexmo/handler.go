@@ -215,7 +215,7 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			resp, respBody, requestErr = h.RequestHTTP(req, clog)
 			matched := throttledRE.FindAllStringSubmatch(string(respBody), -1)
 			if len(matched) > 0 && len(matched[0]) > 0 {
-				return courier.ErrConnectionThrottled
+				return courier.ErrRetryAfter(courier.ErrConnectionThrottled, handlers.RetryAfter(resp))
 			} else {
 				break
 			}
@@ -384,7 +384,15 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 		return nil, fmt.Errorf("unmarshal user info error:%s", err)
 	}
 
-	return map[string]string{"name": uInfo.User.RealName}, nil
+	attrs := map[string]string{courier.URNAttrName: uInfo.User.RealName}
+	if uInfo.User.Name != "" {
+		attrs[courier.URNAttrUsername] = uInfo.User.Name
+	}
+	if uInfo.User.Profile.Image192 != "" {
+		attrs[courier.URNAttrAvatarURL] = uInfo.User.Profile.Image192
+	}
+
+	return attrs, nil
 }
 
 // mtPayload is a struct that represents the body of a SendMmsg text part
@@ -501,6 +509,10 @@ type FileParams struct {
 type UserInfo struct {
 	Ok   bool `json:"ok"`
 	User struct {
+		Name     string `json:"name"`
 		RealName string `json:"real_name"`
+		Profile  struct {
+			Image192 string `json:"image_192"`
+		} `json:"profile"`
 	} `json:"user"`
 }
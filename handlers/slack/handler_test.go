@@ -331,7 +331,7 @@ func buildMockSlackService(testCases []IncomingTestCase) *httptest.Server {
 		case "/users.info":
 
 			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{"user":{"real_name":"dummy user"}}`))
+			w.Write([]byte(`{"user":{"name":"dummy.user","real_name":"dummy user","profile":{"image_192":"https://avatars.slack-edge.com/dummy_192.png"}}}`))
 
 		case "/files.sharedPublicURL":
 
@@ -374,7 +374,7 @@ func TestDescribeURN(t *testing.T) {
 	clog := courier.NewChannelLog(courier.ChannelLogTypeUnknown, testChannels[0], handler.RedactValues(testChannels[0]))
 	urn, _ := urns.New(urns.Slack, "U012345")
 
-	data := map[string]string{"name": "dummy user"}
+	data := map[string]string{"name": "dummy user", "username": "dummy.user", "avatar_url": "https://avatars.slack-edge.com/dummy_192.png"}
 
 	describe, err := handler.(courier.URNDescriber).DescribeURN(context.Background(), testChannels[0], urn, clog)
 	assert.Nil(t, err)
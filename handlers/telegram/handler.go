@@ -72,7 +72,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 
 	// this is a start command, trigger a new conversation
 	if text == "/start" {
-		event := h.Backend().NewChannelEvent(channel, courier.EventTypeNewConversation, urn, clog).WithContactName(name).WithOccurredOn(date)
+		event := h.Backend().NewChannelEvent(channel, courier.EventTypeNewConversation, urn, clog).WithContactName(name).WithContactLanguage(payload.Message.From.LanguageCode).WithOccurredOn(date)
 		err = h.Backend().WriteChannelEvent(ctx, event, clog)
 		if err != nil {
 			return nil, err
@@ -125,7 +125,7 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// build our msg
-	msg := h.Backend().NewIncomingMsg(ctx, channel, urn, text, fmt.Sprintf("%d", payload.Message.MessageID), clog).WithReceivedOn(date).WithContactName(name)
+	msg := h.Backend().NewIncomingMsg(ctx, channel, urn, text, fmt.Sprintf("%d", payload.Message.MessageID), clog).WithReceivedOn(date).WithContactName(name).WithContactLanguage(payload.Message.From.LanguageCode)
 
 	if mediaURL != "" {
 		msg.WithAttachment(mediaURL)
@@ -138,12 +138,20 @@ type mtResponse struct {
 	Ok          bool   `json:"ok" validate:"required"`
 	ErrorCode   int    `json:"error_code"`
 	Description string `json:"description"`
-	Result      struct {
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+	Result struct {
 		MessageID int64 `json:"message_id"`
 	} `json:"result"`
 }
 
 func (h *handler) sendMsgPart(msg courier.MsgOut, token, path string, form url.Values, keyboard *ReplyKeyboardMarkup, clog *courier.ChannelLog) (string, error) {
+	// the Bot API has no time to live so don't send any more parts once the msg has expired
+	if handlers.MsgExpired(msg) {
+		return "", courier.ErrMsgExpired
+	}
+
 	// either include or remove our keyboard
 	form.Add("parse_mode", "Markdown")
 	if keyboard == nil {
@@ -170,6 +178,8 @@ func (h *handler) sendMsgPart(msg courier.MsgOut, token, path string, form url.V
 	if err != nil || resp.StatusCode/100 != 2 || !response.Ok {
 		if response.ErrorCode == 403 && response.Description == "Forbidden: bot was blocked by the user" {
 			return "", courier.ErrContactStopped
+		} else if response.ErrorCode == 429 {
+			return "", courier.ErrRetryAfter(courier.ErrConnectionThrottled, time.Duration(response.Parameters.RetryAfter)*time.Second)
 		} else if response.ErrorCode > 0 {
 			return "", courier.ErrFailedWithReason(strconv.Itoa(response.ErrorCode), response.Description)
 		}
@@ -379,10 +389,11 @@ type moPayload struct {
 	Message  struct {
 		MessageID int64 `json:"message_id"`
 		From      struct {
-			ContactID int64  `json:"id"`
-			FirstName string `json:"first_name"`
-			LastName  string `json:"last_name"`
-			Username  string `json:"username"`
+			ContactID    int64  `json:"id"`
+			FirstName    string `json:"first_name"`
+			LastName     string `json:"last_name"`
+			Username     string `json:"username"`
+			LanguageCode string `json:"language_code"`
 		} `json:"from"`
 		Date    int64  `json:"date"`
 		Text    string `json:"text"`
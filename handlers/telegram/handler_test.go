@@ -26,7 +26,8 @@ var helloMsg = `{
 		"id": 3527065,
 		"first_name": "Nic",
 		"last_name": "Pottier",
-		"username": "nicpottier"
+		"username": "nicpottier",
+		"language_code": "en"
 	},
 	"chat": {
 		"id": 3527065,
@@ -47,7 +48,8 @@ var startMsg = `{
           "id": 3527065,
           "first_name": "Nic",
           "last_name": "Pottier",
-          "username": "nicpottier"
+          "username": "nicpottier",
+          "language_code": "pt-br"
       },
       "chat": {
           "id": 3527065,
@@ -519,25 +521,27 @@ var contactMsg = `
 var testCases = []IncomingTestCase{
 	{
 
-		Label:                "Receive Valid Message",
-		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
-		Data:                 helloMsg,
-		ExpectedRespStatus:   200,
-		ExpectedBodyContains: "Accepted",
-		ExpectedContactName:  Sp("Nic Pottier"),
-		ExpectedMsgText:      Sp("Hello World"),
-		ExpectedURN:          "telegram:3527065#nicpottier",
-		ExpectedExternalID:   "41",
-		ExpectedDate:         time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC),
+		Label:                   "Receive Valid Message",
+		URL:                     "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                    helloMsg,
+		ExpectedRespStatus:      200,
+		ExpectedBodyContains:    "Accepted",
+		ExpectedContactName:     Sp("Nic Pottier"),
+		ExpectedContactLanguage: "eng",
+		ExpectedMsgText:         Sp("Hello World"),
+		ExpectedURN:             "telegram:3527065#nicpottier",
+		ExpectedExternalID:      "41",
+		ExpectedDate:            time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC),
 	},
 	{
 
-		Label:                "Receive Start Message",
-		URL:                  "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
-		Data:                 startMsg,
-		ExpectedRespStatus:   200,
-		ExpectedBodyContains: "Accepted",
-		ExpectedContactName:  Sp("Nic Pottier"),
+		Label:                   "Receive Start Message",
+		URL:                     "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/",
+		Data:                    startMsg,
+		ExpectedRespStatus:      200,
+		ExpectedBodyContains:    "Accepted",
+		ExpectedContactName:     Sp("Nic Pottier"),
+		ExpectedContactLanguage: "por",
 		ExpectedEvents: []ExpectedEvent{
 			{Type: courier.EventTypeNewConversation, URN: "telegram:3527065#nicpottier", Time: time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)},
 		},
@@ -780,6 +784,8 @@ func TestIncoming(t *testing.T) {
 	RunIncomingTestCases(t, chs, newHandler(), testCases)
 }
 
+var expiredOn = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
 var outgoingCases = []OutgoingTestCase{
 	{
 		Label:   "Plain Send",
@@ -874,6 +880,20 @@ var outgoingCases = []OutgoingTestCase{
 		},
 		ExpectedError: courier.ErrContactStopped,
 	},
+	{
+		Label:   "Rate Limited",
+		MsgText: "Slow down",
+		MsgURN:  "telegram:12345",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/botauth_token/sendMessage": {
+				httpx.NewMockResponse(429, nil, []byte(`{ "ok": false, "error_code":429, "description":"Too Many Requests: retry after 30", "parameters": {"retry_after": 30}}`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{
+			{Form: url.Values{"text": {"Slow down"}, "chat_id": {"12345"}, "parse_mode": []string{"Markdown"}, "reply_markup": {`{"remove_keyboard":true}`}}},
+		},
+		ExpectedError: courier.ErrRetryAfter(courier.ErrConnectionThrottled, 30*time.Second),
+	},
 	{
 		Label:          "Send Photo",
 		MsgText:        "My pic!",
@@ -955,6 +975,13 @@ var outgoingCases = []OutgoingTestCase{
 		MsgAttachments:    []string{"unknown/foo:https://foo.bar/unknown.foo"},
 		ExpectedLogErrors: []*clogs.Error{courier.ErrorMediaUnsupported("unknown/foo")},
 	},
+	{
+		Label:         "Expired",
+		MsgText:       "Your code is 1234",
+		MsgURN:        "telegram:12345",
+		MsgExpiresOn:  &expiredOn,
+		ExpectedError: courier.ErrMsgExpired,
+	},
 }
 
 func TestOutgoing(t *testing.T) {
@@ -57,20 +57,21 @@ type IncomingTestCase struct {
 	Headers       map[string]string
 	MultipartForm map[string]string
 
-	ExpectedRespStatus    int
-	ExpectedBodyContains  string
-	ExpectedContactName   *string
-	ExpectedMsgText       *string
-	ExpectedURN           urns.URN
-	ExpectedURNAuthTokens map[urns.URN]map[string]string
-	ExpectedAttachments   []string
-	ExpectedDate          time.Time
-	ExpectedExternalID    string
-	ExpectedMsgID         int64
-	ExpectedStatuses      []ExpectedStatus
-	ExpectedEvents        []ExpectedEvent
-	ExpectedErrors        []*clogs.Error
-	NoLogsExpected        bool
+	ExpectedRespStatus      int
+	ExpectedBodyContains    string
+	ExpectedContactName     *string
+	ExpectedContactLanguage i18n.Language
+	ExpectedMsgText         *string
+	ExpectedURN             urns.URN
+	ExpectedURNAuthTokens   map[urns.URN]map[string]string
+	ExpectedAttachments     []string
+	ExpectedDate            time.Time
+	ExpectedExternalID      string
+	ExpectedMsgID           int64
+	ExpectedStatuses        []ExpectedStatus
+	ExpectedEvents          []ExpectedEvent
+	ExpectedErrors          []*clogs.Error
+	NoLogsExpected          bool
 }
 
 // utility method to make a request to a handler URL
@@ -222,6 +223,9 @@ func RunIncomingTestCases(t *testing.T, channels []courier.Channel, handler cour
 			if tc.ExpectedContactName != nil {
 				require.Equal(*tc.ExpectedContactName, mb.LastContactName())
 			}
+			if tc.ExpectedContactLanguage != "" {
+				assert.Equal(t, tc.ExpectedContactLanguage, mb.LastContactLanguage())
+			}
 
 			assert.Equal(t, tc.ExpectedURNAuthTokens, mb.URNAuthTokens())
 
@@ -311,6 +315,7 @@ type OutgoingTestCase struct {
 	MsgUserID               courier.UserID
 	MsgOrigin               courier.MsgOrigin
 	MsgContactLastSeenOn    *time.Time
+	MsgExpiresOn            *time.Time
 
 	MockResponses map[string][]*httpx.MockResponse
 
@@ -350,6 +355,9 @@ func (tc *OutgoingTestCase) Msg(mb *test.MockBackend, ch courier.Channel) courie
 	if tc.MsgOptIn != nil {
 		m.WithOptIn(tc.MsgOptIn)
 	}
+	if tc.MsgExpiresOn != nil {
+		m.WithExpiresOn(*tc.MsgExpiresOn)
+	}
 	return m
 }
 
@@ -62,6 +62,9 @@ var mediaSupport = map[handlers.MediaType]handlers.MediaTypeSupport{
 const errorStopped = 21610
 const errorThrottled = 63018
 
+// the longest validity period twilio accepts in seconds
+const maxValidityPeriod = 36000
+
 type handler struct {
 	handlers.BaseHandler
 	validateSignatures bool
@@ -274,6 +277,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			}
 		}
 
+		setValidityPeriod(form, msg)
+
 		contentVariablesJson := jsonx.MustMarshal(contentVariables)
 		if len(contentVariables) > 0 {
 			form["ContentVariables"] = []string{string(contentVariablesJson)}
@@ -302,6 +307,11 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 			return courier.ErrConnectionFailed
 		}
 
+		// Twilio tells us how long to back off for when we're sending too fast
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return courier.ErrRetryAfter(courier.ErrConnectionThrottled, handlers.RetryAfter(resp))
+		}
+
 		// see if we can parse the error if we have one
 		if resp.StatusCode/100 != 2 && len(respBody) > 0 {
 			errorCode, _ := jsonparser.GetInt(respBody, "code")
@@ -361,6 +371,8 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 				form["From"] = []string{channel.Address()}
 			}
 
+			setValidityPeriod(form, msg)
+
 			// for whatsapp channels, we have to prepend whatsapp to the To and From
 			if channel.IsScheme(urns.WhatsApp) {
 				form["To"][0] = fmt.Sprintf("%s:+%s", urns.WhatsApp.Prefix, form["To"][0])
@@ -391,6 +403,11 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 				return courier.ErrConnectionFailed
 			}
 
+			// Twilio tells us how long to back off for when we're sending too fast
+			if resp.StatusCode == http.StatusTooManyRequests {
+				return courier.ErrRetryAfter(courier.ErrConnectionThrottled, handlers.RetryAfter(resp))
+			}
+
 			// see if we can parse the error if we have one
 			if resp.StatusCode/100 != 2 && len(respBody) > 0 {
 				errorCode, _ := jsonparser.GetInt(respBody, "code")
@@ -468,6 +485,13 @@ func (h *handler) parseURN(channel courier.Channel, text string, country i18n.Co
 	return urns.ParsePhone(text, country, true, true)
 }
 
+// sets the validity period of the message being sent so that twilio doesn't deliver it after it has expired
+func setValidityPeriod(form url.Values, msg courier.MsgOut) {
+	if ttl := handlers.MsgTTL(msg); ttl > 0 {
+		form["ValidityPeriod"] = []string{strconv.Itoa(min(int(ttl.Seconds()), maxValidityPeriod))}
+	}
+}
+
 func (h *handler) baseURL(c courier.Channel) string {
 	// Twilio channels use the Twili base URL
 	if c.ChannelType() == "T" || c.ChannelType() == "TMS" || c.ChannelType() == "TWA" {
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/dates"
 )
 
 var (
@@ -102,3 +106,41 @@ func DecodePossibleBase64(original string) string {
 func IsURL(s string) bool {
 	return urlRegex.MatchString(s)
 }
+
+// RetryAfter returns the delay requested by the Retry-After header of the passed in response, or zero if there
+// isn't one. The header value can be a number of seconds or an HTTP date.
+func RetryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+
+	return 0
+}
+
+// MsgTTL returns how long the passed in msg has left before it expires, rounded to the nearest second, or zero if it
+// doesn't expire. Handlers whose providers support a time to live can pass this through.
+func MsgTTL(msg courier.MsgOut) time.Duration {
+	if msg.ExpiresOn() == nil {
+		return 0
+	}
+	return max(msg.ExpiresOn().Sub(dates.Now()).Round(time.Second), time.Second)
+}
+
+// MsgExpired returns whether the passed in msg has expired. Handlers which send a msg in multiple parts, and whose
+// providers don't support a time to live, should check this before each part and return courier.ErrMsgExpired.
+func MsgExpired(msg courier.MsgOut) bool {
+	return msg.ExpiresOn() != nil && !dates.Now().Before(*msg.ExpiresOn())
+}
@@ -1,9 +1,15 @@
 package handlers_test
 
 import (
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/urns"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -124,3 +130,40 @@ func TestDecodePossibleBase64(t *testing.T) {
 	assert.Contains(handlers.DecodePossibleBase64("Tm93IGlzDQp0aGUgdGltZQ0KZm9yIGFsbCBnb29kDQpwZW9wbGUgdG8NCnJlc2lzdC4NCg0KSG93IGFib3V0IGhhaWt1cz8NCkkgZmluZCB0aGVtIHRvIGJlIGZyaWVuZGx5Lg0KcmVmcmlnZXJhdG9yDQoNCjAxMjM0NTY3ODkNCiFAIyQlXiYqKCkgW117fS09Xys7JzoiLC4vPD4/fFx+YA0KQUJDREVGR0hJSktMTU5PUFFSU1RVVldYWVphYmNkZWZnaGlqa2xtbm9wcXJzdHV2d3h5eg=="), "I find them to be friendly")
 	assert.Contains(handlers.DecodePossibleBase64(test6), "I received your letter today")
 }
+
+func TestRetryAfter(t *testing.T) {
+	resp := func(retryAfter string) *http.Response {
+		r := &http.Response{Header: http.Header{}}
+		if retryAfter != "" {
+			r.Header.Set("Retry-After", retryAfter)
+		}
+		return r
+	}
+
+	assert.Equal(t, time.Duration(0), handlers.RetryAfter(nil))
+	assert.Equal(t, time.Duration(0), handlers.RetryAfter(resp("")))
+	assert.Equal(t, time.Duration(0), handlers.RetryAfter(resp("soon")))
+	assert.Equal(t, time.Duration(0), handlers.RetryAfter(resp("-5")))
+	assert.Equal(t, 30*time.Second, handlers.RetryAfter(resp("30")))
+	assert.Equal(t, time.Duration(0), handlers.RetryAfter(resp("Wed, 21 Oct 2015 07:28:00 GMT")))
+
+	d := handlers.RetryAfter(resp(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)))
+	assert.Greater(t, d, 58*time.Second)
+	assert.LessOrEqual(t, d, time.Minute)
+}
+
+func TestMsgTTL(t *testing.T) {
+	dates.SetNowFunc(dates.NewFixedNow(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)))
+	defer dates.SetNowFunc(time.Now)
+
+	ch := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{})
+	msg := test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, ch, "tel:+250788383383", "hi", nil)
+
+	assert.Equal(t, time.Duration(0), handlers.MsgTTL(msg))
+
+	msg.WithExpiresOn(time.Date(2025, 3, 1, 12, 5, 0, 400000000, time.UTC))
+	assert.Equal(t, 5*time.Minute, handlers.MsgTTL(msg))
+
+	msg.WithExpiresOn(time.Date(2025, 3, 1, 11, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Second, handlers.MsgTTL(msg))
+}
@@ -83,12 +83,14 @@ type eventPayload struct {
 	MessageToken int64  `json:"message_token" validate:"required"`
 	UserID       string `json:"user_id"`
 	Sender       struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Language string `json:"language"`
 	} `json:"sender"`
 	User struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Language string `json:"language"`
 	} `json:"user"`
 	Message struct {
 		Text      string `json:"text"`
@@ -146,7 +148,7 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("invalid viber id"))
 		}
 		// build the channel event
-		channelEvent := h.Backend().NewChannelEvent(channel, courier.EventTypeWelcomeMessage, urn, clog).WithContactName(ContactName)
+		channelEvent := h.Backend().NewChannelEvent(channel, courier.EventTypeWelcomeMessage, urn, clog).WithContactName(ContactName).WithContactLanguage(payload.User.Language)
 
 		err = h.Backend().WriteChannelEvent(ctx, channelEvent, clog)
 		if err != nil {
@@ -168,7 +170,7 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 		}
 
 		// build the channel event
-		channelEvent := h.Backend().NewChannelEvent(channel, courier.EventTypeNewConversation, urn, clog).WithContactName(ContactName)
+		channelEvent := h.Backend().NewChannelEvent(channel, courier.EventTypeNewConversation, urn, clog).WithContactName(ContactName).WithContactLanguage(payload.User.Language)
 
 		err = h.Backend().WriteChannelEvent(ctx, channelEvent, clog)
 		if err != nil {
@@ -262,7 +264,7 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 		}
 
 		// build our msg
-		msg := h.Backend().NewIncomingMsg(ctx, channel, urn, text, fmt.Sprintf("%d", payload.MessageToken), clog).WithContactName(contactName)
+		msg := h.Backend().NewIncomingMsg(ctx, channel, urn, text, fmt.Sprintf("%d", payload.MessageToken), clog).WithContactName(contactName).WithContactLanguage(payload.Sender.Language)
 		if mediaURL != "" {
 			msg.WithAttachment(mediaURL)
 		}
@@ -341,7 +341,8 @@ var (
 		"message_token": 4987381189870374000,
 		"sender": {
 			"id": "xy5/5y6O81+/kbWHpLhBoA==",
-			"name": "ET3"
+			"name": "ET3",
+			"language": "fr"
 		},
 		"message": {
 			"text": "incoming msg",
@@ -582,7 +583,7 @@ var (
 var testCases = []IncomingTestCase{
 	{Label: "Receive Valid", URL: receiveURL, Data: validMsg, ExpectedRespStatus: 200, ExpectedBodyContains: "Accepted",
 		ExpectedMsgText: Sp("incoming msg"), ExpectedURN: "viber:xy5/5y6O81+/kbWHpLhBoA==", ExpectedExternalID: "4987381189870374000",
-		PrepRequest: addValidSignature},
+		ExpectedContactLanguage: "fra", PrepRequest: addValidSignature},
 	{Label: "Receive invalid signature", URL: receiveURL, Data: validMsg, ExpectedRespStatus: 400, ExpectedBodyContains: "invalid request signature",
 		PrepRequest: addInvalidSignature},
 	{Label: "Receive invalid JSON", URL: receiveURL, Data: invalidJSON, ExpectedRespStatus: 400, ExpectedBodyContains: "unable to parse request JSON",
@@ -603,11 +604,12 @@ var testCases = []IncomingTestCase{
 	},
 	{Label: "Delivered Status Report", URL: receiveURL, Data: deliveredStatusReport, ExpectedRespStatus: 200, ExpectedBodyContains: `Ignored`, PrepRequest: addValidSignature},
 	{
-		Label:                "Subcribe",
-		URL:                  receiveURL,
-		Data:                 validSubscribed,
-		ExpectedRespStatus:   200,
-		ExpectedBodyContains: "Accepted",
+		Label:                   "Subcribe",
+		URL:                     receiveURL,
+		Data:                    validSubscribed,
+		ExpectedRespStatus:      200,
+		ExpectedBodyContains:    "Accepted",
+		ExpectedContactLanguage: "eng",
 		ExpectedEvents: []ExpectedEvent{
 			{Type: courier.EventTypeNewConversation, URN: "viber:01234567890A="},
 		},
@@ -460,7 +460,7 @@ func (h *handler) handleMediaUploadAndGetAttachment(channel courier.Channel, med
 				URLPhotoUploadServer = serverURL
 			}
 		}
-		download, err := h.downloadMedia(mediaURL)
+		download, err := h.downloadMedia(mediaURL, clog)
 
 		if err != nil {
 			return "", err
@@ -514,14 +514,19 @@ func (h *handler) getUploadServerURL(channel courier.Channel, sendURL string, cl
 }
 
 // downloadMedia GET request to given media URL
-func (h *handler) downloadMedia(mediaURL string) (io.Reader, error) {
+func (h *handler) downloadMedia(mediaURL string, clog *courier.ChannelLog) (io.Reader, error) {
 	req, err := http.NewRequest(http.MethodGet, mediaURL, nil)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if res, err := httpx.Do(h.Backend().HttpClient(true), req, nil, nil); err == nil {
+	client := h.Backend().HttpClient(true)
+	if clog.DryRun() {
+		client = courier.DryRunClient(client)
+	}
+
+	if res, err := httpx.Do(client, req, nil, nil); err == nil {
 		return res.Body, nil
 	} else {
 		return nil, err
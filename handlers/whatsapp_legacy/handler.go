@@ -570,6 +570,11 @@ func (h *handler) Send(ctx context.Context, msg courier.MsgOut, res *courier.Sen
 	}
 
 	for _, payload := range payloads {
+		// WhatsApp has no time to live for session msgs so don't send any more parts once the msg has expired
+		if handlers.MsgExpired(msg) {
+			return courier.ErrMsgExpired
+		}
+
 		externalID := ""
 		wppID, externalID, err = h.sendWhatsAppMsg(msg, sendPath, payload, clog)
 		if err != nil {
@@ -971,18 +976,11 @@ func (h *handler) sendWhatsAppMsg(msg courier.MsgOut, sendPath *url.URL, payload
 	}
 
 	if resp != nil && (resp.StatusCode == 429 || resp.StatusCode == 503) {
-		rateLimitKey := fmt.Sprintf("rate_limit:%s", msg.Channel().UUID())
-
-		h.WithValkeyConn(func(rc redis.Conn) {
-			rc.Do("SET", rateLimitKey, "engaged")
-
-			// The rate limit is 50 requests per second
-			// We pause sending 2 seconds so the limit count is reset
-			// TODO: In the future we should the header value when available
-			rc.Do("EXPIRE", rateLimitKey, 2)
-		})
+		// The rate limit is 50 requests per second so by default we pause sending 2 seconds so the limit count is
+		// reset, unless the response tells us how long to wait
+		retryAfter := handlers.RetryAfter(resp)
 
-		return "", "", courier.ErrConnectionThrottled
+		return "", "", courier.ErrPauseQueue(courier.ErrRetryAfter(courier.ErrConnectionThrottled, retryAfter), max(retryAfter, 2*time.Second), false)
 	}
 
 	errPayload := &mtErrorPayload{}
@@ -991,17 +989,9 @@ func (h *handler) sendWhatsAppMsg(msg courier.MsgOut, sendPath *url.URL, payload
 	// handle send msg errors
 	if err == nil && len(errPayload.Errors) > 0 {
 		if hasTiersError(*errPayload) {
-			rateLimitBulkKey := fmt.Sprintf("rate_limit_bulk:%s", msg.Channel().UUID())
-
-			h.WithValkeyConn(func(rc redis.Conn) {
-				rc.Do("SET", rateLimitBulkKey, "engaged")
-
-				// The WA tiers spam rate limit hit
-				// We pause the bulk queue for 24 hours and 5min
-				rc.Do("EXPIRE", rateLimitBulkKey, (60*60*24)+(5*60))
-			})
-
-			return "", "", courier.ErrConnectionThrottled
+			// The WA tiers spam rate limit hit
+			// We pause the bulk queue for 24 hours and 5min
+			return "", "", courier.ErrPauseQueue(courier.ErrConnectionThrottled, 24*time.Hour+5*time.Minute, true)
 		}
 
 		if !hasWhatsAppContactError(*errPayload) {
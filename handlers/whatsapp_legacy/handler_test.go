@@ -588,6 +588,8 @@ func TestIncoming(t *testing.T) {
 	RunIncomingTestCases(t, testChannels, newWAHandler(courier.ChannelType("TXW"), "TextIt"), replaceTestcaseURLs(waTestCases, txReceiveURL))
 }
 
+var expiredOn = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
 var defaultSendTestCases = []OutgoingTestCase{
 	{
 		Label:   "Link Sending",
@@ -666,7 +668,22 @@ var defaultSendTestCases = []OutgoingTestCase{
 			Path: "/v1/messages",
 			Body: `{"to":"250788123123","type":"text","text":{"body":"Error"}}`,
 		}},
-		ExpectedError: courier.ErrConnectionThrottled,
+		ExpectedError: courier.ErrPauseQueue(courier.ErrConnectionThrottled, 2*time.Second, false),
+	},
+	{
+		Label:   "Tier Limit Reached",
+		MsgText: "Error",
+		MsgURN:  "whatsapp:250788123123",
+		MockResponses: map[string][]*httpx.MockResponse{
+			"*/v1/messages": {
+				httpx.NewMockResponse(200, nil, []byte(`{ "errors": [{ "title": "Spam Rate limit hit", "code": 471 }] }`)),
+			},
+		},
+		ExpectedRequests: []ExpectedRequest{{
+			Path: "/v1/messages",
+			Body: `{"to":"250788123123","type":"text","text":{"body":"Error"}}`,
+		}},
+		ExpectedError: courier.ErrPauseQueue(courier.ErrConnectionThrottled, 24*time.Hour+5*time.Minute, true),
 	},
 	{
 		Label:   "No Message ID",
@@ -1119,6 +1136,13 @@ var defaultSendTestCases = []OutgoingTestCase{
 		ExpectedExtIDs: []string{"157b5e14568e8"},
 		ExpectedNewURN: "whatsapp:551187654321",
 	},
+	{
+		Label:         "Expired",
+		MsgText:       "Your code is 1234",
+		MsgURN:        "whatsapp:250788123123",
+		MsgExpiresOn:  &expiredOn,
+		ExpectedError: courier.ErrMsgExpired,
+	},
 }
 
 var mediaCacheSendTestCases = []OutgoingTestCase{
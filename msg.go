@@ -129,6 +129,9 @@ type MsgOut interface {
 	ResponseToExternalID() string
 	SentOn() *time.Time
 	IsResend() bool
+	Retries() int
+	SendAfter() *time.Time
+	ExpiresOn() *time.Time
 	Flow() *FlowReference
 	OptIn() *OptInReference
 	UserID() UserID
@@ -144,6 +147,7 @@ type MsgIn interface {
 	ReceivedOn() *time.Time
 	WithAttachment(url string) MsgIn
 	WithContactName(name string) MsgIn
+	WithContactLanguage(locale string) MsgIn
 	WithURNAuthTokens(tokens map[string]string) MsgIn
 	WithReceivedOn(date time.Time) MsgIn
 }
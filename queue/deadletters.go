@@ -0,0 +1,104 @@
+package queue
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/uuids"
+)
+
+// DeadLetter is a value which was popped from a queue but couldn't be processed, which is kept so that it can be
+// inspected, and then replayed back onto its queue or discarded
+type DeadLetter struct {
+	ID       string    `json:"id"`
+	Queue    string    `json:"queue"`
+	TPS      int       `json:"tps"`
+	Priority Priority  `json:"priority"`
+	Value    string    `json:"value"`
+	Error    string    `json:"error"`
+	FailedOn time.Time `json:"failed_on"`
+}
+
+// the maximum number of dead letters kept for a queue type, beyond which the oldest are removed
+var maxDeadLetters = 10000
+
+//go:embed lua/add_dead.lua
+var luaAddDead string
+var scriptAddDead = redis.NewScript(5, luaAddDead)
+
+//go:embed lua/replay_dead.lua
+var luaReplayDead string
+var scriptReplayDead = redis.NewScript(3, luaReplayDead)
+
+// AddDeadLetter adds the passed in value, popped from the passed in queue, to the dead letters for the queue type along
+// with the error that prevented it being processed, removing the oldest if there are too many. Returns
+// the ID of the new dead letter.
+func AddDeadLetter(conn redis.Conn, qType string, queue string, tps int, priority Priority, value string, cause error) (string, error) {
+	dl := &DeadLetter{
+		ID:       string(uuids.NewV7()),
+		Queue:    queue,
+		TPS:      tps,
+		Priority: priority,
+		Value:    value,
+		Error:    cause.Error(),
+		FailedOn: time.Now().UTC(),
+	}
+
+	encoded, err := json.Marshal(dl)
+	if err != nil {
+		return "", err
+	}
+
+	epochMS := strconv.FormatFloat(float64(dl.FailedOn.UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	if _, err := scriptAddDead.Do(conn, epochMS, qType, dl.ID, encoded, maxDeadLetters); err != nil {
+		return "", err
+	}
+	return dl.ID, nil
+}
+
+// GetDeadLetters returns all dead letters for the passed in queue type, oldest first
+func GetDeadLetters(conn redis.Conn, qType string) ([]*DeadLetter, error) {
+	values, err := redis.StringMap(conn.Do("HGETALL", qType+":dead"))
+	if err != nil {
+		return nil, err
+	}
+
+	dls := make([]*DeadLetter, 0, len(values))
+	for id, value := range values {
+		dl := &DeadLetter{}
+		if err := json.Unmarshal([]byte(value), dl); err != nil {
+			return nil, fmt.Errorf("error unmarshalling dead letter %s: %w", id, err)
+		}
+		dls = append(dls, dl)
+	}
+
+	// IDs are time ordered UUIDs
+	slices.SortFunc(dls, func(a, b *DeadLetter) int { return strings.Compare(a.ID, b.ID) })
+
+	return dls, nil
+}
+
+// ReplayDeadLetter pushes the dead letter with the passed in ID back onto the queue it was popped from and removes it,
+// returning false if no such dead letter exists
+func ReplayDeadLetter(conn redis.Conn, qType string, id string) (bool, error) {
+	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	return redis.Bool(scriptReplayDead.Do(conn, epochMS, qType, id))
+}
+
+// DiscardDeadLetter removes the dead letter with the passed in ID, returning false if no such dead letter exists
+func DiscardDeadLetter(conn redis.Conn, qType string, id string) (bool, error) {
+	conn.Send("MULTI")
+	conn.Send("HDEL", qType+":dead", id)
+	conn.Send("ZREM", qType+":dead:ids", id)
+	removed, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return false, err
+	}
+	return redis.Bool(removed[0], nil)
+}
@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Limit is a maximum number of values which can be popped from a queue in any sliding window of time. If it has a
+// burst, pops are also spread evenly across the window with no more than that many popped at once, which can't be more
+// than the count since a burst is part of it.
+type Limit struct {
+	Count  int
+	Window time.Duration
+	Burst  int
+}
+
+// String returns the limit in the same format that ParseLimits parses
+func (l Limit) String() string {
+	unit := map[time.Duration]string{time.Second: "s", time.Minute: "m", time.Hour: "h", 24 * time.Hour: "d"}[l.Window]
+	if unit == "" {
+		unit = strconv.Itoa(int(l.Window.Seconds())) + "s"
+	}
+	if l.Burst > 0 {
+		return fmt.Sprintf("%d/%s+%d", l.Count, unit, l.Burst)
+	}
+	return fmt.Sprintf("%d/%s", l.Count, unit)
+}
+
+func (l Limit) validate() error {
+	if l.Count <= 0 || l.Burst > l.Count || l.Window < time.Second || l.Window%time.Second != 0 {
+		return fmt.Errorf("invalid rate limit '%s'", l)
+	}
+	return nil
+}
+
+// how limits are stored for pop.lua to read
+type limitJSON struct {
+	Count  int `json:"count"`
+	Window int `json:"window"`
+	Burst  int `json:"burst"`
+}
+
+var limitRegex = regexp.MustCompile(`^(\d+)/(s|m|h|d)(?:\+(\d+))?$`)
+
+var limitUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+
+// ParseLimits parses a comma separated list of limits like "30/s+10,1000/m,50000/d" where each is a count per second,
+// minute, hour or day, with an optional burst.
+func ParseLimits(s string) ([]Limit, error) {
+	limits := make([]Limit, 0, 3)
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		match := limitRegex.FindStringSubmatch(part)
+		if match == nil {
+			return nil, fmt.Errorf("invalid rate limit '%s'", part)
+		}
+
+		count, _ := strconv.Atoi(match[1])
+		burst, _ := strconv.Atoi(match[3])
+		if count == 0 || burst > count {
+			return nil, fmt.Errorf("invalid rate limit '%s'", part)
+		}
+
+		limits = append(limits, Limit{Count: count, Window: limitUnits[match[2]], Burst: burst})
+	}
+
+	return limits, nil
+}
+
+// SetLimits sets the rate limits of the passed in queue, which apply in addition to its TPS. Passing no limits removes
+// any existing limits.
+func SetLimits(conn redis.Conn, qType string, queue string, limits []Limit) error {
+	if len(limits) == 0 {
+		_, err := conn.Do("HDEL", qType+":limits", queue)
+		return err
+	}
+
+	stored := make([]limitJSON, len(limits))
+	for i, l := range limits {
+		if err := l.validate(); err != nil {
+			return err
+		}
+		stored[i] = limitJSON{Count: l.Count, Window: int(l.Window / time.Second), Burst: max(l.Burst, 0)}
+	}
+
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Do("HSET", qType+":limits", queue, encoded)
+	return err
+}
+
+// GetLimits returns the rate limits of the passed in queue
+func GetLimits(conn redis.Conn, qType string, queue string) ([]Limit, error) {
+	encoded, err := redis.Bytes(conn.Do("HGET", qType+":limits", queue))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	stored := make([]limitJSON, 0)
+	if err := json.Unmarshal(encoded, &stored); err != nil {
+		return nil, fmt.Errorf("error unmarshalling limits for queue %s: %w", queue, err)
+	}
+
+	limits := make([]Limit, len(stored))
+	for i, l := range stored {
+		limits[i] = Limit{Count: l.Count, Window: time.Duration(l.Window) * time.Second, Burst: l.Burst}
+	}
+	return limits, nil
+}
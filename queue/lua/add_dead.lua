@@ -0,0 +1,17 @@
+-- KEYS: [EpochMS, QueueType, ID, DeadLetter, MaxDeadLetters]
+
+local deadKey = KEYS[2] .. ":dead"
+local idsKey = deadKey .. ":ids"
+
+redis.call("hset", deadKey, KEYS[3], KEYS[4])
+redis.call("zadd", idsKey, KEYS[1], KEYS[3])
+
+-- if we have too many, remove the oldest
+local excess = redis.call("zcard", idsKey) - tonumber(KEYS[5])
+if excess > 0 then
+    local oldest = redis.call("zrange", idsKey, 0, excess - 1)
+    redis.call("hdel", deadKey, unpack(oldest))
+    redis.call("zremrangebyrank", idsKey, 0, excess - 1)
+end
+
+return excess > 0 and excess or 0
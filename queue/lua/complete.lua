@@ -1,14 +1,24 @@
--- KEYS: [QueueType, Queue]
+-- KEYS: [QueueType, Token]
+
+-- our token is the queue followed by the priority popped from and the worker's lease
+local queue = string.match(KEYS[2], "^[^/@]+")
+if string.find(KEYS[2], "@") then
+    -- if the lease already expired then the reaper has already released this worker
+    redis.call("hdel", KEYS[1] .. ":leased", KEYS[2])
+    if redis.call("zrem", KEYS[1] .. ":leases", KEYS[2]) == 0 then
+        return
+    end
+end
 
 -- decrement throttled if present
-local throttled = tonumber(redis.call("zadd", KEYS[1] .. ":throttled", "XX", "CH", "INCR", -1, KEYS[2]))
+local throttled = tonumber(redis.call("zadd", KEYS[1] .. ":throttled", "XX", "CH", "INCR", -1, queue))
 
 -- if we didn't decrement anything, do so to our active set
 if not throttled or throttled == 0 then
-    local active = tonumber(redis.call("zincrby", KEYS[1] .. ":active", -1, KEYS[2]))
+    local active = tonumber(redis.call("zincrby", KEYS[1] .. ":active", -1, queue))
     
     -- reset to zero if we somehow go below
     if active < 0 then
-        redis.call("zadd", KEYS[1] .. ":active", 0, KEYS[2])
+        redis.call("zadd", KEYS[1] .. ":active", 0, queue)
     end
-end
\ No newline at end of file
+end
@@ -0,0 +1,14 @@
+-- KEYS: [EpochMS, QueueType, QueueName, URN, Token, ID, HoldMS]
+
+local lockKey = KEYS[2] .. ":" .. KEYS[3] .. ":urn:" .. KEYS[4]
+
+-- only the holder of the lock can hand it back
+if redis.call("get", lockKey) ~= KEYS[5] then
+    return 0
+end
+
+-- hand the lock back to the value that held it so that it stays at the front of the line when it's popped again, any
+-- values waiting for it will be released when it expires if that never happens
+redis.call("set", lockKey, "handoff:" .. KEYS[6], "PX", KEYS[7])
+
+return 1
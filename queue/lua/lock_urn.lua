@@ -0,0 +1,25 @@
+-- KEYS: [EpochMS, QueueType, QueueName, URN, ID, Token, LeaseMS, TPS, Priority, Value]
+
+local lockKey = KEYS[2] .. ":" .. KEYS[3] .. ":urn:" .. KEYS[4]
+local waitingKey = lockKey .. ":waiting"
+local now = tonumber(KEYS[1])
+
+local lock = redis.call("get", lockKey)
+
+-- if no one holds the lock and no one is waiting for it, or it was handed off to this value, we can take it
+if (not lock and redis.call("llen", waitingKey) == 0) or lock == "handoff:" .. KEYS[5] then
+    redis.call("set", lockKey, KEYS[6], "PX", KEYS[7])
+    return 1
+end
+
+-- otherwise get in line behind any other values waiting for this URN
+redis.call("rpush", waitingKey, cjson.encode({id=KEYS[5], tps=KEYS[8], priority=KEYS[9], value=KEYS[10]}))
+
+-- and record when the lock expires so that the line can be released if it is never unlocked
+local expiresAt = now
+if lock then
+    expiresAt = now + math.max(redis.call("pttl", lockKey), 0) / 1000
+end
+redis.call("zadd", KEYS[2] .. ":urns", expiresAt, cjson.encode({KEYS[3], KEYS[4]}))
+
+return 0
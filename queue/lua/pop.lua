@@ -1,4 +1,4 @@
--- KEYS: [EpochMS QueueType]
+-- KEYS: [EpochMS QueueType LeaseSeconds PriorityWeights]
 
 -- get the first key off our active list
 local result = redis.call("zrange", KEYS[2] .. ":active", 0, 0, "WITHSCORES")
@@ -20,6 +20,12 @@ local queueName = ""
 if delim then
     queueName = string.sub(queue, string.len(KEYS[2])+2, delim-1)
     tps = tonumber(string.sub(queue, delim+1))
+
+    -- a TPS set on the queue takes precedence over the TPS it was pushed with
+    local queueTPS = redis.call("hget", KEYS[2] .. ":tps", queueName)
+    if queueTPS then
+        tps = tonumber(queueTPS)
+    end
 end
 
 if queueName then
@@ -34,53 +40,170 @@ end
 
 -- if we have a tps, then check whether we exceed it
 if tps > 0 then
-    tpsKey = queue .. ":tps:" .. math.floor(KEYS[1])
+    -- count is by queue name so that it's shared by keys of the same queue pushed with different TPS
+    tpsKey = KEYS[2] .. ":" .. queueName .. ":tps:" .. math.floor(KEYS[1])
     local curr = redis.call("get", tpsKey)
     
-    -- we are at or above our tps, move to our throttled queue
+    -- we are at or above our tps, move to our throttled queue and count that it was throttled
     if curr and tonumber(curr) >= tps then 
+        redis.call("hincrby", KEYS[2] .. ":throttles", queueName, 1)
         redis.call("zincrby", KEYS[2] .. ":throttled", workers, queue)
         redis.call("zrem", KEYS[2] .. ":active", queue)
         return {"retry", ""}
     end
 end
 
--- pop our next value out, first from our default queue
-local resultQueue = queue .. "/1"
-local result = redis.call("zrangebyscore", resultQueue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
-
--- keep track as to whether this result is in the future (and therefore ineligible)
-local isFutureResult = result[1] and tonumber(result[2]) > tonumber(KEYS[1])
+-- check any rate limits on this queue, each of which is a maximum count of pops in a sliding window of seconds, which
+-- we keep as a log of when each pop happened. A limit with a burst also spreads its pops evenly across its window,
+-- allowing no more than its burst at once, but never more than its count in any window.
+local limits = nil
+local now = tonumber(KEYS[1])
 
--- if we didn't find one, try again from our bulk queue
-if not result[1] or isFutureResult then
-    -- check if we are rate limited for bulk queue
-    local rateLimitBulkKey = "rate_limit_bulk:" .. queueName
-    local rateLimitBulk = redis.call("get", rateLimitBulkKey)
-    if rateLimitBulk then
+if queueName ~= "" then
+    local limitedKey = KEYS[2] .. ":" .. queueName .. ":limited"
+    if redis.call("exists", limitedKey) == 1 then
+        redis.call("zincrby", KEYS[2] .. ":throttled", workers, queue)
+        redis.call("zrem", KEYS[2] .. ":active", queue)
         return {"retry", ""}
     end
 
-    -- we are not pause check our bulk queue
-    local bulkQueue = queue .. "/0"
-    local bulkResult = redis.call("zrangebyscore", bulkQueue, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+    local limitsJSON = redis.call("hget", KEYS[2] .. ":limits", queueName)
+    if limitsJSON then
+        limits = cjson.decode(limitsJSON)
+        local freesAt = 0
 
-    -- if we got a result
-    if bulkResult[1] then
-        -- if it is in the future, set ourselves as in the future
-        if tonumber(bulkResult[2]) > tonumber(KEYS[1]) then
-            isFutureResult = true
-        
-        -- otherwise, this is a valid result
-        else 
-            redis.call("echo", "found result")
-            isFutureResult = false
-            result = bulkResult
-            resultQueue = bulkQueue
+        for _, limit in ipairs(limits) do
+            local logKey = KEYS[2] .. ":" .. queueName .. ":limit:" .. limit["window"]
+
+            -- forget pops which are no longer in the window, and if we're at the count, wait for the oldest to leave it
+            redis.call("zremrangebyscore", logKey, "-inf", now - limit["window"])
+            if redis.call("zcard", logKey) >= limit["count"] then
+                local oldest = redis.call("zrange", logKey, 0, 0, "WITHSCORES")
+                freesAt = math.max(freesAt, tonumber(oldest[2]) + limit["window"])
+            end
+
+            -- and if we have a burst, check we aren't too far ahead of an even pace
+            if limit["burst"] > 0 then
+                local interval = limit["window"] / limit["count"]
+                local paceAt = tonumber(redis.call("get", logKey .. ":pace")) or now
+                local allowedAt = paceAt - (limit["burst"] - 1) * interval
+                if allowedAt > now then
+                    freesAt = math.max(freesAt, allowedAt)
+                end
+            end
+        end
+
+        -- if we've hit any limit, throttle this queue until it frees up
+        if freesAt > 0 then
+            redis.call("hincrby", KEYS[2] .. ":throttles", queueName, 1)
+            redis.call("set", limitedKey, "engaged", "PX", math.max(math.ceil((freesAt - now) * 1000), 1))
+            redis.call("zincrby", KEYS[2] .. ":throttled", workers, queue)
+            redis.call("zrem", KEYS[2] .. ":active", queue)
+            return {"retry", ""}
+        end
+    end
+end
+
+local weights = cjson.decode(KEYS[4])
+
+-- if priorities are set for the origins of values, a batch is moved to the highest priority of its values' origins the
+-- first time it's due here, whoever pushed it. We keep a watermark of the score we've checked each priority up to so
+-- that each batch is only decoded once, and only check so many each pop so that large queues are checked gradually.
+local originPriorities = redis.call("hgetall", KEYS[2] .. ":priorities")
+if #originPriorities > 0 then
+    local byOrigin = {}
+    for i = 1, #originPriorities, 2 do
+        byOrigin[originPriorities[i]] = tonumber(originPriorities[i + 1])
+    end
+
+    local sweptKey = KEYS[2] .. ":swept"
+    for priority = 0, #weights - 1 do
+        local priorityKey = queue .. "/" .. priority
+        local from = redis.call("hget", sweptKey, priorityKey) or "-inf"
+        local items = redis.call("zrangebyscore", priorityKey, from, now, "WITHSCORES", "LIMIT", 0, 100)
+
+        for i = 1, #items, 2 do
+            local batch = string.match(items[i], "^[%d%.]+|(.*)$") or items[i]
+            local ok, values = pcall(cjson.decode, batch)
+            local mapped = nil
+            if ok and type(values) == "table" then
+                for _, value in ipairs(values) do
+                    if type(value) == "table" and type(value["origin"]) == "string" and byOrigin[value["origin"]] then
+                        mapped = math.max(mapped or 0, byOrigin[value["origin"]])
+                    end
+                end
+            end
+
+            if mapped and mapped ~= priority then
+                redis.call("zrem", priorityKey, items[i])
+                redis.call("zadd", queue .. "/" .. mapped, items[i + 1], items[i])
+            end
+        end
+
+        if #items > 0 then
+            -- the watermark is inclusive so batches pushed later with the same score aren't missed, unless a whole
+            -- page has the same score in which case we'd never get past it
+            local last = items[#items]
+            if #items == 200 and items[2] == last then
+                last = "(" .. last
+            end
+            redis.call("hset", sweptKey, priorityKey, last)
+        elseif redis.call("exists", priorityKey) == 0 then
+            redis.call("hdel", sweptKey, priorityKey)
         end
     end
 end
 
+-- find which of our priorities have values due, highest first, noting whether any only have values in the future
+local bulkPaused = redis.call("get", "rate_limit_bulk:" .. queueName)
+local due = {}
+local dueWeight = 0
+local isFutureResult = false
+
+for priority = #weights - 1, 0, -1 do
+    -- bulk values are those with the lowest priority which can be paused separately
+    if priority == 0 and bulkPaused then
+        -- if nothing else is due, take this queue out of rotation like any other throttled queue so that it doesn't
+        -- block other queues, the dethrottler will put it back
+        if #due == 0 then
+            redis.call("zincrby", KEYS[2] .. ":throttled", workers, queue)
+            redis.call("zrem", KEYS[2] .. ":active", queue)
+            return {"retry", ""}
+        end
+    else
+        local first = redis.call("zrangebyscore", queue .. "/" .. priority, 0, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+        if first[1] then
+            if tonumber(first[2]) > now then
+                isFutureResult = true
+            else
+                table.insert(due, {priority, first})
+                dueWeight = dueWeight + weights[priority + 1]
+            end
+        end
+    end
+end
+
+-- pick which priority to pop from, if more than one is due then we take turns in proportion to their weights so
+-- that higher priorities are preferred but lower priorities still get a share
+local result = {}
+local resultPriority = 0
+
+if #due == 1 then
+    resultPriority, result = due[1][1], due[1][2]
+elseif #due > 1 then
+    local slot = redis.call("hincrby", KEYS[2] .. ":turns", queueName, 1) % dueWeight
+    for _, d in ipairs(due) do
+        slot = slot - weights[d[1] + 1]
+        if slot < 0 then
+            resultPriority, result = d[1], d[2]
+            break
+        end
+    end
+end
+
+local resultQueue = queue .. "/" .. resultPriority
+isFutureResult = isFutureResult and not result[1]
+
 -- if we found one
 if result[1] and not isFutureResult then
     -- then remove it from the queue
@@ -89,27 +212,60 @@ if result[1] and not isFutureResult then
     -- and add a worker to this queue
     redis.call("zincrby", KEYS[2] .. ":active", 1, queue)
 
+    -- values we push are prefixed with when they were due, otherwise we assume that was their score
+    local pushedOn, batch = string.match(result[1], "^([%d%.]+)|(.*)$")
+    if not pushedOn then
+        pushedOn, batch = result[2], result[1]
+    end
+
     -- parse it as JSON to get the first element out
-    local valueList = cjson.decode(result[1])
+    local valueList = cjson.decode(batch)
     local popValue = cjson.encode(valueList[1])
     table.remove(valueList, 1)
 
-    -- increment our tps for this second if we have a limit
+    -- lease this value to the worker
+    local token = resultQueue .. "@" .. redis.call("incr", KEYS[2] .. ":leases:seq")
+
+    -- count the pop against our tps for this second and our rate limits, noting what we counted so that it can be
+    -- refunded if the value isn't processed
+    local counted = {}
     if tps > 0 then 
-        redis.call("incrby", tpsKey, popValue["tps_cost"] or 1)
+        redis.call("incr", tpsKey)
         redis.call("expire", tpsKey, 10)
+        counted["tps"] = tpsKey
     end 
 
+    if limits then
+        counted["limits"] = {}
+        for _, limit in ipairs(limits) do
+            local logKey = KEYS[2] .. ":" .. queueName .. ":limit:" .. limit["window"]
+            redis.call("zadd", logKey, KEYS[1], token)
+            redis.call("expire", logKey, limit["window"] + 60)
+
+            local interval = 0
+            if limit["burst"] > 0 then
+                interval = limit["window"] / limit["count"]
+                local paceAt = math.max(tonumber(redis.call("get", logKey .. ":pace")) or now, now) + interval
+                redis.call("set", logKey .. ":pace", string.format("%.6f", paceAt), "EX", limit["window"] + 60)
+            end
+            table.insert(counted["limits"], {logKey, interval})
+        end
+    end
+
     -- encode it back if there is anything left
     if table.getn(valueList) > 0 then
         local remaining = cjson.encode(valueList)
         
-        -- schedule it in the future 3 seconds on our main queue
-        redis.call("zadd", queue .. "/1", tonumber(KEYS[1]) + 3, remaining)
+        -- schedule it in the future 3 seconds, at no lower than high priority
+        redis.call("zadd", queue .. "/" .. math.max(resultPriority, 1), tonumber(KEYS[1]) + 3, pushedOn .. "|" .. remaining)
         redis.call("zincrby", KEYS[2] .. ":future", 0, queue)
     end
 
-    return {queue, popValue}
+    -- record what the leased value was so it can be requeued if the lease expires
+    redis.call("zadd", KEYS[2] .. ":leases", tonumber(KEYS[1]) + tonumber(KEYS[3]), token)
+    redis.call("hset", KEYS[2] .. ":leased", token, cjson.encode({priority=tostring(resultPriority), value=popValue, pushed_on=pushedOn, counted=counted}))
+
+    return {token, popValue, pushedOn}
 
 -- otherwise, the queue only contains future results, remove from active and add to future, have the caller retry
 elseif isFutureResult then
@@ -4,16 +4,22 @@
 -- our queue name is built from the type, name and tps, usually something like: "msgs:uuid1-uuid2-uuid3-uuid4|tps"
 local queueKey = KEYS[2] .. ":" .. KEYS[3] .. "|" .. KEYS[4]
 
--- our priority queue name also includes the priority of the message (we have one queue for default and one for bulk)
+-- our priority queue name also includes the priority of the value, of which there are several from bulk up
 local priorityQueueKey = queueKey .. "/" .. KEYS[5]
 redis.call("zadd", priorityQueueKey, KEYS[1], KEYS[6])
 
 local tps = tonumber(KEYS[4])
 
+-- a TPS set on the queue takes precedence over the TPS we were given
+local queueTPS = redis.call("hget", KEYS[2] .. ":tps", KEYS[3])
+if queueTPS then
+    tps = tonumber(queueTPS)
+end
+
 -- if we have a TPS, check whether we are currently throttled
 local curr = -1
 if tps > 0 then
-    local tpsKey = queueKey .. ":tps:" .. math.floor(KEYS[1])
+    local tpsKey = KEYS[2] .. ":" .. KEYS[3] .. ":tps:" .. math.floor(KEYS[1])
     curr = tonumber(redis.call("get", tpsKey))
 end
 
@@ -0,0 +1,36 @@
+-- KEYS: [EpochMS, QueueType, Requeue]
+
+local leasesKey = KEYS[2] .. ":leases"
+local leasedKey = KEYS[2] .. ":leased"
+
+-- get all the leases which have expired without their workers completing them
+local expired = redis.call("zrangebyscore", leasesKey, "-inf", KEYS[1])
+
+for _, token in ipairs(expired) do
+    local queue = string.match(token, "^[^/@]+")
+
+    -- release the worker, decrementing throttled if present, otherwise active
+    local throttled = tonumber(redis.call("zadd", KEYS[2] .. ":throttled", "XX", "CH", "INCR", -1, queue))
+    if not throttled or throttled == 0 then
+        local active = tonumber(redis.call("zincrby", KEYS[2] .. ":active", -1, queue))
+        if active < 0 then
+            redis.call("zadd", KEYS[2] .. ":active", 0, queue)
+        end
+    end
+
+    -- and if asked to, push the value it was processing back onto its queue
+    if KEYS[3] == "1" then
+        local leased = redis.call("hget", leasedKey, token)
+        if leased then
+            local item = cjson.decode(leased)
+            local pushedOn = item["pushed_on"] or KEYS[1]
+            redis.call("zadd", queue .. "/" .. item["priority"], KEYS[1], pushedOn .. "|[" .. item["value"] .. "]")
+            redis.call("zincrby", KEYS[2] .. ":active", 0, queue)
+        end
+    end
+
+    redis.call("hdel", leasedKey, token)
+    redis.call("zrem", leasesKey, token)
+end
+
+return #expired
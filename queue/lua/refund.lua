@@ -0,0 +1,46 @@
+-- KEYS: [QueueType, Token]
+
+-- look up what the pop of this value was counted against
+local leasedKey = KEYS[1] .. ":leased"
+local leased = redis.call("hget", leasedKey, KEYS[2])
+if not leased then
+    return 0
+end
+
+local item = cjson.decode(leased)
+local counted = item["counted"]
+if not counted or next(counted) == nil then
+    return 0
+end
+
+-- take it back off our count for the second it was popped in
+if counted["tps"] then
+    local curr = tonumber(redis.call("get", counted["tps"]))
+    if curr and curr > 0 then
+        redis.call("decr", counted["tps"])
+    end
+end
+
+-- and out of the log of each of our rate limits, moving the pace back if there is one
+if counted["limits"] then
+    for _, limit in ipairs(counted["limits"]) do
+        redis.call("zrem", limit[1], KEYS[2])
+        if limit[2] > 0 and redis.call("exists", limit[1] .. ":pace") == 1 then
+            redis.call("incrbyfloat", limit[1] .. ":pace", -limit[2])
+        end
+    end
+
+    -- if the queue was throttled by its limits, let the next pop check them again
+    local queue = string.match(KEYS[2], "^[^/@]+")
+    local delim = string.find(queue, "|")
+    if delim then
+        local queueName = string.sub(queue, string.len(KEYS[1])+2, delim-1)
+        redis.call("del", KEYS[1] .. ":" .. queueName .. ":limited")
+    end
+end
+
+-- and don't refund it again
+item["counted"] = {}
+redis.call("hset", leasedKey, KEYS[2], cjson.encode(item))
+
+return 1
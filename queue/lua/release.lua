@@ -0,0 +1,20 @@
+-- KEYS: [EpochMS, QueueType]
+
+-- get all the scheduled items which are now due
+local scheduledKey = KEYS[2] .. ":scheduled"
+local due = redis.call("zrangebyscore", scheduledKey, "-inf", KEYS[1], "WITHSCORES")
+
+-- push each onto its priority queue with the time it was due and add its queue to our active list
+for i=1,#due,2 do
+    local item = cjson.decode(due[i])
+    local queueKey = KEYS[2] .. ":" .. item["queue"] .. "|" .. item["tps"]
+
+    redis.call("zadd", queueKey .. "/" .. item["priority"], due[i+1], item["value"])
+    redis.call("zincrby", KEYS[2] .. ":active", 0, queueKey)
+end
+
+if next(due) then
+    redis.call("zremrangebyscore", scheduledKey, "-inf", KEYS[1])
+end
+
+return #due / 2
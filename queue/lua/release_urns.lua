@@ -0,0 +1,41 @@
+-- KEYS: [EpochMS, QueueType, LeaseMS]
+
+local urnsKey = KEYS[2] .. ":urns"
+local now = tonumber(KEYS[1])
+local lease = tonumber(KEYS[3])
+
+-- get all the URNs with values waiting on locks which should have expired by now
+local due = redis.call("zrangebyscore", urnsKey, "-inf", KEYS[1])
+local released = 0
+
+for _, member in ipairs(due) do
+    local queueName, urn = unpack(cjson.decode(member))
+    local lockKey = KEYS[2] .. ":" .. queueName .. ":urn:" .. urn
+    local waitingKey = lockKey .. ":waiting"
+    local pttl = redis.call("pttl", lockKey)
+
+    if pttl > 0 then
+        -- lock is still held so check again when it expires
+        redis.call("zadd", urnsKey, now + pttl / 1000, member)
+    else
+        -- lock was never unlocked so hand it off to the next value in line
+        local next = redis.call("lpop", waitingKey)
+        if next then
+            local item = cjson.decode(next)
+            local queueKey = KEYS[2] .. ":" .. queueName .. "|" .. item["tps"]
+
+            redis.call("zadd", queueKey .. "/" .. item["priority"], 0, item["value"])
+            redis.call("zincrby", KEYS[2] .. ":active", 0, queueKey)
+            redis.call("set", lockKey, "handoff:" .. item["id"], "PX", lease)
+            released = released + 1
+        end
+
+        if redis.call("llen", waitingKey) > 0 then
+            redis.call("zadd", urnsKey, now + lease / 1000, member)
+        else
+            redis.call("zrem", urnsKey, member)
+        end
+    end
+end
+
+return released
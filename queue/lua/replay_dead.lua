@@ -0,0 +1,21 @@
+-- KEYS: [EpochMS, QueueType, ID]
+
+local deadKey = KEYS[2] .. ":dead"
+
+local encoded = redis.call("hget", deadKey, KEYS[3])
+if not encoded then
+    return 0
+end
+
+-- push the value back onto the queue it was popped from, due now
+local dl = cjson.decode(encoded)
+local queueKey = KEYS[2] .. ":" .. dl["queue"] .. "|" .. dl["tps"]
+
+redis.call("zadd", queueKey .. "/" .. dl["priority"], KEYS[1], KEYS[1] .. "|[" .. dl["value"] .. "]")
+redis.call("zincrby", KEYS[2] .. ":active", 0, queueKey)
+
+-- and remove it from our dead letters
+redis.call("hdel", deadKey, KEYS[3])
+redis.call("zrem", deadKey .. ":ids", KEYS[3])
+
+return 1
@@ -0,0 +1,48 @@
+-- KEYS: [EpochMS, QueueType, QueueName, URN, Token, GapMS, LeaseMS]
+
+local lockKey = KEYS[2] .. ":" .. KEYS[3] .. ":urn:" .. KEYS[4]
+local waitingKey = lockKey .. ":waiting"
+local urnsKey = KEYS[2] .. ":urns"
+local member = cjson.encode({KEYS[3], KEYS[4]})
+local now = tonumber(KEYS[1])
+local gap = tonumber(KEYS[6])
+local lease = tonumber(KEYS[7])
+
+-- only the holder of the lock can unlock it
+if redis.call("get", lockKey) ~= KEYS[5] then
+    return 0
+end
+
+local next = redis.call("lpop", waitingKey)
+
+-- if no one is waiting, release the lock, or keep it for our gap so the next value for this URN has to wait
+if not next then
+    if gap > 0 then
+        redis.call("set", lockKey, "gap", "PX", gap)
+    else
+        redis.call("del", lockKey)
+    end
+    redis.call("zrem", urnsKey, member)
+    return 1
+end
+
+-- otherwise hand the lock off to the next value in line and push it back onto its queue, at the front unless it has to
+-- wait for our gap to pass
+local item = cjson.decode(next)
+local queueKey = KEYS[2] .. ":" .. KEYS[3] .. "|" .. item["tps"]
+local score = 0
+if gap > 0 then
+    score = now + gap / 1000
+end
+
+redis.call("zadd", queueKey .. "/" .. item["priority"], score, item["value"])
+redis.call("zincrby", KEYS[2] .. ":active", 0, queueKey)
+redis.call("set", lockKey, "handoff:" .. item["id"], "PX", gap + lease)
+
+if redis.call("llen", waitingKey) > 0 then
+    redis.call("zadd", urnsKey, now + (gap + lease) / 1000, member)
+else
+    redis.call("zrem", urnsKey, member)
+end
+
+return 1
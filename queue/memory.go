@@ -0,0 +1,728 @@
+package queue
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/gocommon/uuids"
+)
+
+// memoryQueue is an implementation of a queue type which keeps its values in memory, for use in tests and in-process
+// queues which don't need to survive restarts. It has the same semantics as the Valkey implementation but never asks callers to retry, and
+// does the work of the Valkey background workers, like releasing expired URN locks, whenever it's popped from.
+type memoryQueue struct {
+	qType       string
+	keys        map[string]*memoryKey      // keyed like the Valkey implementation, e.g. msgs:chan1|10
+	popCounts   map[string]*memoryCount    // pops in the current second by queue name
+	turns       map[string]int             // pops where more than one priority was due by queue name
+	counted     map[WorkerToken]*memoryPop // what each popped value was counted against
+	paused      map[string]time.Time       // pause expirations by queue name
+	bulkPaused  map[string]time.Time       //
+	tps         map[string]int             // TPS set on queues by queue name
+	limits      map[string][]Limit         // rate limits by queue name
+	limitLogs   map[string]*memoryLimitLog // pops counted against rate limits by queue name and window
+	limited     map[string]time.Time       // when queues which have hit a rate limit free up by queue name
+	throttled   map[string]int64           // second in which queues hit their TPS by queue name
+	throttles   map[string]int             // number of times queues have been throttled by queue name
+	priorities  map[string]Priority        // priorities of values by origin
+	urnLocks    map[string]*memoryURNLock  // keyed by queue name and URN
+	deadLetters []*DeadLetter              // oldest first
+	seq         int
+	mutex       sync.Mutex
+}
+
+type memoryKey struct {
+	name    string
+	tps     int
+	workers int
+	values  [MaxPriority + 1][]*memoryValue // by priority, ordered by when they can be popped
+}
+
+type memoryValue struct {
+	value    string
+	at       time.Time
+	pushedOn time.Time // stays the same if the value is moved
+	seq      int
+	swept    bool // whether it's been moved to the priority of its origins
+}
+
+type memoryCount struct {
+	second int64
+	count  int
+}
+
+type memoryPop struct {
+	name   string
+	second int64 // second the pop was counted against the queue's TPS in, zero if it has none
+	limits []memoryLimitPop
+}
+
+type memoryLimitPop struct {
+	log      *memoryLimitLog
+	interval time.Duration
+}
+
+type memoryLimitLog struct {
+	pops   map[WorkerToken]time.Time
+	paceAt time.Time
+}
+
+type memoryURNLock struct {
+	queue     string
+	holder    string // token of the worker holding it, handoff:<id> if handed off to a waiting value, or gap
+	expiresOn time.Time
+	waiting   []*memoryWaiting
+}
+
+type memoryWaiting struct {
+	id       string
+	tps      int
+	priority Priority
+	value    string
+	pushedOn time.Time
+}
+
+// NewMemoryQueue creates a new queue of the given type which is stored in memory
+func NewMemoryQueue(qType string) Queue {
+	return &memoryQueue{
+		qType:      qType,
+		keys:       make(map[string]*memoryKey),
+		popCounts:  make(map[string]*memoryCount),
+		turns:      make(map[string]int),
+		counted:    make(map[WorkerToken]*memoryPop),
+		paused:     make(map[string]time.Time),
+		bulkPaused: make(map[string]time.Time),
+		tps:        make(map[string]int),
+		limits:     make(map[string][]Limit),
+		limitLogs:  make(map[string]*memoryLimitLog),
+		limited:    make(map[string]time.Time),
+		throttled:  make(map[string]int64),
+		throttles:  make(map[string]int),
+		priorities: make(map[string]Priority),
+		urnLocks:   make(map[string]*memoryURNLock),
+	}
+}
+
+// Start is a no-op as values only live as long as the process, so there are no expired leases to requeue, and expired
+// URN locks are released on pop
+func (q *memoryQueue) Start(quit chan bool, wg *sync.WaitGroup, requeueExpired bool) {}
+
+func (q *memoryQueue) Push(queue string, tps int, value string, priority Priority, at time.Time) error {
+	if priority < LowPriority || priority > MaxPriority {
+		return fmt.Errorf("invalid priority %d", priority)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.push(queue, tps, value, priority, at, at)
+	return nil
+}
+
+func (q *memoryQueue) Schedule(queue string, tps int, value string, priority Priority, at time.Time) error {
+	if priority < LowPriority || priority > MaxPriority {
+		return fmt.Errorf("invalid priority %d", priority)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// like the Valkey implementation, scheduled values keep the priority they were scheduled with
+	q.push(queue, tps, value, priority, at, at)
+	return nil
+}
+
+// like pop.lua, moves batches which are due to the highest priority of their values' origins, checking each only once
+func (q *memoryQueue) sweepOrigins(k *memoryKey, now time.Time) {
+	if len(q.priorities) == 0 {
+		return
+	}
+
+	for _, p := range priorities() {
+		for i := 0; i < len(k.values[p]) && !k.values[p][i].at.After(now); {
+			v := k.values[p][i]
+			if v.swept {
+				i++
+				continue
+			}
+			v.swept = true
+
+			if mapped := q.originPriority(v.value); mapped >= LowPriority && mapped != p {
+				k.values[p] = slices.Delete(k.values[p], i, i+1)
+				k.insert(mapped, v)
+			} else {
+				i++
+			}
+		}
+	}
+}
+
+// gets the highest priority of the origins of the values in the given batch, or -1 if none of them have one
+func (q *memoryQueue) originPriority(value string) Priority {
+	var batch []struct {
+		Origin string `json:"origin"`
+	}
+	if err := json.Unmarshal([]byte(value), &batch); err != nil {
+		return -1
+	}
+
+	mapped := Priority(-1)
+	for _, v := range batch {
+		if p, found := q.priorities[v.Origin]; found {
+			mapped = max(mapped, p)
+		}
+	}
+	return mapped
+}
+
+func (q *memoryQueue) push(queue string, tps int, value string, priority Priority, at, pushedOn time.Time) {
+	key := fmt.Sprintf("%s:%s|%d", q.qType, queue, tps)
+	k := q.keys[key]
+	if k == nil {
+		k = &memoryKey{name: queue, tps: tps}
+		q.keys[key] = k
+	}
+
+	q.seq++
+	k.insert(priority, &memoryValue{value: value, at: at, pushedOn: pushedOn, seq: q.seq})
+}
+
+func (k *memoryKey) insert(priority Priority, v *memoryValue) {
+	i, _ := slices.BinarySearchFunc(k.values[priority], v, compareMemoryValues)
+	k.values[priority] = slices.Insert(k.values[priority], i, v)
+}
+
+func (q *memoryQueue) Pop() (WorkerToken, string, time.Time, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+
+	q.releaseURNs(now)
+
+	// like the Valkey implementation, we try queues with the fewest workers first
+	keys := make([]string, 0, len(q.keys))
+	for key := range q.keys {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(cmp.Compare(q.keys[a].workers, q.keys[b].workers), cmp.Compare(a, b))
+	})
+
+	for _, key := range keys {
+		k := q.keys[key]
+
+		if now.Before(q.paused[k.name]) || q.throttled[k.name] == now.Unix() || now.Before(q.limited[k.name]) {
+			continue
+		}
+
+		// check whether we're at our TPS, counting that we were throttled the first time we hit it in each second
+		tps := q.effectiveTPS(k)
+		count := q.popCounts[k.name]
+		if count == nil || count.second != now.Unix() {
+			count = &memoryCount{second: now.Unix()}
+			q.popCounts[k.name] = count
+		}
+		if tps > 0 && count.count >= tps {
+			q.throttled[k.name] = now.Unix()
+			q.throttles[k.name]++
+			continue
+		}
+
+		// check whether we've hit any rate limits, which throttles us until they free up
+		if freesAt := q.limitFreesAt(k.name, now); !freesAt.IsZero() {
+			q.limited[k.name] = freesAt
+			q.throttles[k.name]++
+			continue
+		}
+
+		q.sweepOrigins(k, now)
+
+		// find which priorities have values due, ignoring bulk if it's paused
+		due, dueWeight := make([]Priority, 0, MaxPriority+1), 0
+		for _, p := range priorities() {
+			if p == LowPriority && now.Before(q.bulkPaused[k.name]) {
+				continue
+			}
+			if len(k.values[p]) > 0 && !k.values[p][0].at.After(now) {
+				due = append(due, p)
+				dueWeight += priorityWeights[p]
+			}
+		}
+		if len(due) == 0 {
+			continue // like the Valkey implementation, a queue with nothing due doesn't block other queues
+		}
+
+		// like the Valkey implementation, if more than one is due we take turns in proportion to their weights
+		priority := due[0]
+		if len(due) > 1 {
+			q.turns[k.name]++
+			slot := q.turns[k.name] % dueWeight
+			for _, p := range due {
+				if slot -= priorityWeights[p]; slot < 0 {
+					priority = p
+					break
+				}
+			}
+		}
+
+		v := k.values[priority][0]
+		k.values[priority] = k.values[priority][1:]
+
+		// values are batches, of which we pop the first item and put the rest back for later
+		var batch []json.RawMessage
+		if err := json.Unmarshal([]byte(v.value), &batch); err != nil || len(batch) == 0 {
+			return "", "", time.Time{}, fmt.Errorf("error decoding queue value %s: %w", v.value, err)
+		}
+
+		first := &bytes.Buffer{}
+		if err := json.Compact(first, batch[0]); err != nil {
+			return "", "", time.Time{}, fmt.Errorf("error decoding queue value %s: %w", v.value, err)
+		}
+
+		if len(batch) > 1 {
+			remaining, _ := json.Marshal(batch[1:])
+			q.push(k.name, k.tps, string(remaining), max(priority, HighPriority), now.Add(3*time.Second), v.pushedOn)
+		}
+
+		k.workers++
+		q.seq++
+
+		token := WorkerToken(fmt.Sprintf("%s/%d@%d", key, priority, q.seq))
+		q.counted[token] = q.countPop(token, k.name, tps, count, now)
+
+		return token, first.String(), v.pushedOn, nil
+	}
+
+	// forget about queues which are empty and have no workers
+	for key, k := range q.keys {
+		if k.workers == 0 && !k.hasValues() {
+			delete(q.keys, key)
+		}
+	}
+
+	return EmptyQueue, "", time.Time{}, nil
+}
+
+func (q *memoryQueue) effectiveTPS(k *memoryKey) int {
+	if tps, found := q.tps[k.name]; found {
+		return tps
+	}
+	return k.tps
+}
+
+// like pop.lua, returns when the rate limits of the named queue free up if any have been hit, or zero if none have
+func (q *memoryQueue) limitFreesAt(name string, now time.Time) time.Time {
+	var freesAt time.Time
+
+	for _, l := range q.limits[name] {
+		log := q.limitLog(name, l)
+
+		// forget pops which are no longer in the window, and if we're at the count, wait for the oldest to leave it
+		var oldest time.Time
+		for token, at := range log.pops {
+			if !at.After(now.Add(-l.Window)) {
+				delete(log.pops, token)
+			} else if oldest.IsZero() || at.Before(oldest) {
+				oldest = at
+			}
+		}
+		if len(log.pops) >= l.Count && oldest.Add(l.Window).After(freesAt) {
+			freesAt = oldest.Add(l.Window)
+		}
+
+		// and if we have a burst, check we aren't too far ahead of an even pace
+		if l.Burst > 0 && !log.paceAt.IsZero() {
+			interval := l.Window / time.Duration(l.Count)
+			allowedAt := log.paceAt.Add(-time.Duration(l.Burst-1) * interval)
+			if allowedAt.After(now) && allowedAt.After(freesAt) {
+				freesAt = allowedAt
+			}
+		}
+	}
+	return freesAt
+}
+
+func (q *memoryQueue) limitLog(name string, l Limit) *memoryLimitLog {
+	key := fmt.Sprintf("%s|%d", name, int(l.Window/time.Second))
+	log := q.limitLogs[key]
+	if log == nil {
+		log = &memoryLimitLog{pops: make(map[WorkerToken]time.Time)}
+		q.limitLogs[key] = log
+	}
+	return log
+}
+
+// counts a pop against the TPS and rate limits of the named queue, returning what it was counted against
+func (q *memoryQueue) countPop(token WorkerToken, name string, tps int, count *memoryCount, now time.Time) *memoryPop {
+	pop := &memoryPop{name: name}
+
+	if tps > 0 {
+		count.count++
+		pop.second = count.second
+	}
+
+	for _, l := range q.limits[name] {
+		log := q.limitLog(name, l)
+		log.pops[token] = now
+
+		var interval time.Duration
+		if l.Burst > 0 {
+			interval = l.Window / time.Duration(l.Count)
+			if log.paceAt.Before(now) {
+				log.paceAt = now
+			}
+			log.paceAt = log.paceAt.Add(interval)
+		}
+		pop.limits = append(pop.limits, memoryLimitPop{log: log, interval: interval})
+	}
+	return pop
+}
+
+func (q *memoryQueue) MarkComplete(token WorkerToken) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if k := q.keys[token.Key()]; k != nil && k.workers > 0 {
+		k.workers--
+	}
+	delete(q.counted, token)
+	return nil
+}
+
+func (q *memoryQueue) Refund(token WorkerToken) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	pop, found := q.counted[token]
+	if !found {
+		return nil
+	}
+	delete(q.counted, token)
+
+	if count := q.popCounts[pop.name]; pop.second != 0 && count != nil && count.second == pop.second && count.count > 0 {
+		count.count--
+		delete(q.throttled, pop.name)
+	}
+
+	for _, l := range pop.limits {
+		delete(l.log.pops, token)
+		l.log.paceAt = l.log.paceAt.Add(-l.interval)
+	}
+	if len(pop.limits) > 0 {
+		delete(q.limited, pop.name)
+	}
+	return nil
+}
+
+func (q *memoryQueue) Pause(queue string, duration time.Duration, bulkOnly bool) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if bulkOnly {
+		q.bulkPaused[queue] = time.Now().Add(duration)
+	} else {
+		q.paused[queue] = time.Now().Add(duration)
+	}
+	return nil
+}
+
+func (q *memoryQueue) Resume(queue string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	delete(q.paused, queue)
+	delete(q.bulkPaused, queue)
+	return nil
+}
+
+func (q *memoryQueue) Purge(queue string) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	purged := 0
+	for _, k := range q.keys {
+		if k.name == queue {
+			for p := range k.values {
+				purged += len(k.values[p])
+				k.values[p] = nil
+			}
+		}
+	}
+	return purged, nil
+}
+
+func (q *memoryQueue) SetTPS(queue string, tps int) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if tps < 0 {
+		delete(q.tps, queue)
+	} else {
+		q.tps[queue] = tps
+	}
+	return nil
+}
+
+func (q *memoryQueue) SetLimits(queue string, limits []Limit) error {
+	for _, l := range limits {
+		if err := l.validate(); err != nil {
+			return err
+		}
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(limits) == 0 {
+		delete(q.limits, queue)
+	} else {
+		q.limits[queue] = slices.Clone(limits)
+	}
+	return nil
+}
+
+func (q *memoryQueue) SetOriginPriorities(priorities map[string]Priority) error {
+	for _, priority := range priorities {
+		if priority < LowPriority || priority > MaxPriority {
+			return fmt.Errorf("invalid priority %d", priority)
+		}
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.priorities = make(map[string]Priority, len(priorities))
+	for origin, priority := range priorities {
+		q.priorities[origin] = priority
+	}
+	return nil
+}
+
+func (q *memoryQueue) LockURN(queue string, tps int, priority Priority, urn string, id string, value string, pushedOn time.Time, lease time.Duration) (string, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	q.releaseURNs(now)
+
+	key := queue + "|" + urn
+	lock := q.urnLocks[key]
+
+	// like lock_urn.lua, we can take the lock if no one holds it and no one is waiting for it, or it was handed off to us
+	if lock == nil || (lock.holder == "handoff:"+id && now.Before(lock.expiresOn)) {
+		token := string(uuids.NewV4())
+		if lock == nil {
+			lock = &memoryURNLock{queue: queue}
+			q.urnLocks[key] = lock
+		}
+		lock.holder, lock.expiresOn = token, now.Add(lease)
+		return token, nil
+	}
+
+	// otherwise get in line behind any other values waiting for this URN
+	lock.waiting = append(lock.waiting, &memoryWaiting{id: id, tps: tps, priority: priority, value: "[" + value + "]", pushedOn: pushedOn})
+	return "", nil
+}
+
+func (q *memoryQueue) UnlockURN(queue string, urn string, token string, gap time.Duration) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	key := queue + "|" + urn
+	lock := q.urnLocks[key]
+
+	// only the holder of the lock can unlock it
+	if lock == nil || lock.holder != token || !now.Before(lock.expiresOn) {
+		return false, nil
+	}
+
+	// if no one is waiting, release the lock, or keep it for our gap so the next value for this URN has to wait
+	if len(lock.waiting) == 0 {
+		if gap > 0 {
+			lock.holder, lock.expiresOn = "gap", now.Add(gap)
+		} else {
+			delete(q.urnLocks, key)
+		}
+		return true, nil
+	}
+
+	// otherwise hand the lock off to the next value in line and push it back onto its queue, at the front unless it has
+	// to wait for our gap to pass
+	var at time.Time
+	if gap > 0 {
+		at = now.Add(gap)
+	}
+	q.handoffURN(lock, at, now.Add(gap+urnHandoffLease))
+	return true, nil
+}
+
+func (q *memoryQueue) HoldURN(queue string, urn string, token string, id string, delay time.Duration) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	lock := q.urnLocks[queue+"|"+urn]
+
+	// like hold_urn.lua, only the holder of the lock can hand it back
+	if lock == nil || lock.holder != token || !now.Before(lock.expiresOn) {
+		return false, nil
+	}
+
+	lock.holder, lock.expiresOn = "handoff:"+id, now.Add(delay+urnHandoffLease)
+	return true, nil
+}
+
+// like release_urns.lua, hands off URN locks which expired without being unlocked to the next values waiting for them,
+// and forgets those which no one is waiting for
+func (q *memoryQueue) releaseURNs(now time.Time) {
+	for key, lock := range q.urnLocks {
+		if now.Before(lock.expiresOn) {
+			continue
+		}
+		if len(lock.waiting) == 0 {
+			delete(q.urnLocks, key)
+			continue
+		}
+		q.handoffURN(lock, time.Time{}, now.Add(urnHandoffLease))
+	}
+}
+
+func (q *memoryQueue) handoffURN(lock *memoryURNLock, at, expiresOn time.Time) {
+	next := lock.waiting[0]
+	lock.waiting = lock.waiting[1:]
+
+	q.push(lock.queue, next.tps, next.value, next.priority, at, next.pushedOn)
+	lock.holder, lock.expiresOn = "handoff:"+next.id, expiresOn
+}
+
+func (q *memoryQueue) AddDeadLetter(queue string, tps int, priority Priority, value string, cause error) (string, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	dl := &DeadLetter{
+		ID:       string(uuids.NewV7()),
+		Queue:    queue,
+		TPS:      tps,
+		Priority: priority,
+		Value:    value,
+		Error:    cause.Error(),
+		FailedOn: time.Now().UTC(),
+	}
+
+	q.deadLetters = append(q.deadLetters, dl)
+	if len(q.deadLetters) > maxDeadLetters {
+		q.deadLetters = slices.Clone(q.deadLetters[len(q.deadLetters)-maxDeadLetters:])
+	}
+	return dl.ID, nil
+}
+
+func (q *memoryQueue) DeadLetters() ([]*DeadLetter, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return slices.Clone(q.deadLetters), nil
+}
+
+func (q *memoryQueue) ReplayDeadLetter(id string) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	i := slices.IndexFunc(q.deadLetters, func(dl *DeadLetter) bool { return dl.ID == id })
+	if i < 0 {
+		return false, nil
+	}
+
+	dl := q.deadLetters[i]
+	q.deadLetters = slices.Delete(q.deadLetters, i, i+1)
+
+	now := time.Now()
+	q.push(dl.Queue, dl.TPS, "["+dl.Value+"]", dl.Priority, now, now)
+	return true, nil
+}
+
+func (q *memoryQueue) DiscardDeadLetter(id string) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	i := slices.IndexFunc(q.deadLetters, func(dl *DeadLetter) bool { return dl.ID == id })
+	if i < 0 {
+		return false, nil
+	}
+
+	q.deadLetters = slices.Delete(q.deadLetters, i, i+1)
+	return true, nil
+}
+
+func (q *memoryQueue) Queues() ([]*Info, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	infos := make([]*Info, 0, len(q.keys))
+
+	for key, k := range q.keys {
+		if k.workers == 0 && !k.hasValues() {
+			continue
+		}
+
+		info := &Info{Queue: k.name, TPS: k.tps, Workers: k.workers, key: key, keyTPS: k.tps}
+		if tps, found := q.tps[k.name]; found {
+			info.TPS, info.TPSSet = tps, true
+		}
+		for p, values := range k.values {
+			if Priority(p) == LowPriority {
+				info.BulkSize = len(values)
+			} else {
+				info.Size += len(values)
+			}
+		}
+		if count := q.popCounts[k.name]; count != nil && count.second == now.Unix() {
+			info.CurrentTPS = count.count
+		}
+		info.Paused = now.Before(q.paused[k.name])
+		info.BulkPaused = now.Before(q.bulkPaused[k.name])
+		info.Limited = now.Before(q.limited[k.name])
+
+		if info.Limited || q.throttled[k.name] == now.Unix() {
+			info.State = StateThrottled
+		} else if k.hasValues() && !k.hasDueValues(now) {
+			info.State = StateFuture
+		} else {
+			info.State = StateActive
+		}
+
+		infos = append(infos, info)
+	}
+
+	slices.SortFunc(infos, func(a, b *Info) int { return cmp.Compare(a.key, b.key) })
+
+	return infos, nil
+}
+
+func (q *memoryQueue) ExtractThrottles() (map[string]int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	throttles := q.throttles
+	q.throttles = make(map[string]int)
+	return throttles, nil
+}
+
+func (k *memoryKey) hasValues() bool {
+	return slices.ContainsFunc(k.values[:], func(vs []*memoryValue) bool { return len(vs) > 0 })
+}
+
+func (k *memoryKey) hasDueValues(now time.Time) bool {
+	return slices.ContainsFunc(k.values[:], func(vs []*memoryValue) bool { return len(vs) > 0 && !vs[0].at.After(now) })
+}
+
+func compareMemoryValues(a, b *memoryValue) int {
+	return cmp.Or(a.at.Compare(b.at), cmp.Compare(a.seq, b.seq))
+}
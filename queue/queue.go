@@ -2,8 +2,11 @@ package queue
 
 import (
 	_ "embed"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,15 +19,47 @@ type Priority int64
 // WorkerToken represents a token that a worker should return when a task is complete
 type WorkerToken string
 
+// Key returns the key of the queue the token's value was popped from, e.g. msgs:chan1|10
+func (t WorkerToken) Key() string {
+	key, _, _ := strings.Cut(string(t), "@")
+	key, _, _ = strings.Cut(key, "/")
+	return key
+}
+
+// Priority returns the priority the token's value was popped from
+func (t WorkerToken) Priority() Priority {
+	key, _, _ := strings.Cut(string(t), "@")
+	_, priority, _ := strings.Cut(key, "/")
+	p, _ := strconv.Atoi(priority)
+	return Priority(p)
+}
+
 const (
 	// HighPriority is typically used for replies to ensure they sent as soon as possible.
 	HighPriority = 1
 
-	// LowPriority is typically used for bulk messages (sent in batches). These will only be
-	// processed after all high priority messages are dealt with.
+	// LowPriority is typically used for bulk messages (sent in batches). These will mostly be
+	// processed after all higher priority messages are dealt with.
 	LowPriority = 0
+
+	// MaxPriority is the highest priority, with priorities above HighPriority available for values
+	// which should be sent ahead of ordinary replies.
+	MaxPriority = 3
 )
 
+// priorityWeights are the shares of pops given to each priority when more than one has values due. For example, a
+// high priority value will be popped 100 times for every time a bulk value is popped.
+var priorityWeights = [MaxPriority + 1]int{1, 100, 1000, 10000}
+
+// returns the priorities from highest to lowest
+func priorities() []Priority {
+	ps := make([]Priority, 0, MaxPriority+1)
+	for p := Priority(MaxPriority); p >= LowPriority; p-- {
+		ps = append(ps, p)
+	}
+	return ps
+}
+
 const (
 	// EmptyQueue means there are no items to retrive, caller should sleep and try again later
 	EmptyQueue = WorkerToken("empty")
@@ -33,6 +68,83 @@ const (
 	Retry = WorkerToken("retry")
 )
 
+// Queue is a queue type made up of named queues of values. Pops are spread fairly across named queues, with high
+// priority values popped before low priority values within each queue, and no more than a queue's TPS per second or
+// its rate limits allow.
+type Queue interface {
+	// Start starts any background workers the queue needs, e.g. to reactivate throttled queues, which stop when the
+	// quit channel is closed. If requeueExpired is set, values whose workers never marked them complete are requeued.
+	Start(quit chan bool, wg *sync.WaitGroup, requeueExpired bool)
+
+	// Push pushes the passed in value onto the named queue, and it won't be popped off before the given time. A tps
+	// value of 0 means there is no limit to the rate that values can be popped.
+	Push(queue string, tps int, value string, priority Priority, at time.Time) error
+
+	// Schedule parks the passed in value until the given time, when it's pushed onto the named queue
+	Schedule(queue string, tps int, value string, priority Priority, at time.Time) error
+
+	// Pop pops the next available value. A worker token of Retry means the caller should immediately try again, and
+	// EmptyQueue means there are no values to pop. Otherwise the token should be passed to MarkComplete once the value
+	// has been processed. Also returns when the value was pushed, or was due if that was later.
+	Pop() (WorkerToken, string, time.Time, error)
+
+	// MarkComplete marks the processing of a popped value as complete
+	MarkComplete(token WorkerToken) error
+
+	// Refund takes the pop of a value back off the counts of its queue's TPS and rate limits because it won't be
+	// processed. It must be called before MarkComplete.
+	Refund(token WorkerToken) error
+
+	// Pause pauses popping from the named queue for the given duration, or only popping of its bulk values
+	Pause(queue string, duration time.Duration, bulkOnly bool) error
+
+	// Resume removes any pause on popping from the named queue
+	Resume(queue string) error
+
+	// Purge removes all values from the named queue, returning how many were removed
+	Purge(queue string) (int, error)
+
+	// SetTPS sets the TPS of the named queue which takes precedence over the TPS values were pushed with. A negative
+	// TPS removes it.
+	SetTPS(queue string, tps int) error
+
+	// SetLimits sets the rate limits of the named queue, or removes them if there are none
+	SetLimits(queue string, limits []Limit) error
+
+	// SetOriginPriorities sets the priorities that values of the given origins are popped with, whatever priority they
+	// were pushed with
+	SetOriginPriorities(priorities map[string]Priority) error
+
+	// LockURN tries to lock the given URN on the named queue for the popped value with the given id, returning an
+	// empty token if it has to wait its turn, see LockURN
+	LockURN(queue string, tps int, priority Priority, urn string, id string, value string, pushedOn time.Time, lease time.Duration) (string, error)
+
+	// UnlockURN unlocks a URN locked with LockURN, handing it off to the next value waiting for it, see UnlockURN
+	UnlockURN(queue string, urn string, token string, gap time.Duration) (bool, error)
+
+	// HoldURN hands a URN locked with LockURN back to the value with the given id which has been put back on the queue
+	// to be processed after the given delay, so that values waiting for the URN stay behind it, see HoldURN
+	HoldURN(queue string, urn string, token string, id string, delay time.Duration) (bool, error)
+
+	// AddDeadLetter adds a popped value which couldn't be processed to the dead letters, returning its ID
+	AddDeadLetter(queue string, tps int, priority Priority, value string, cause error) (string, error)
+
+	// DeadLetters returns all dead letters, oldest first
+	DeadLetters() ([]*DeadLetter, error)
+
+	// ReplayDeadLetter pushes a dead letter back onto its queue, returning false if it doesn't exist
+	ReplayDeadLetter(id string) (bool, error)
+
+	// DiscardDeadLetter removes a dead letter, returning false if it doesn't exist
+	DiscardDeadLetter(id string) (bool, error)
+
+	// Queues returns information on all queues which have values or workers
+	Queues() ([]*Info, error)
+
+	// ExtractThrottles returns how many times each named queue has been throttled since the last call
+	ExtractThrottles() (map[string]int, error)
+}
+
 //go:embed lua/push.lua
 var luaPush string
 var scriptPush = redis.NewScript(6, luaPush)
@@ -41,27 +153,358 @@ var scriptPush = redis.NewScript(6, luaPush)
 // specified transactions per second are popped off at a time. A tps value of 0 means there is no
 // limit to the rate that messages can be consumed
 func PushOntoQueue(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority) error {
+	return PushOntoQueueAt(conn, qType, queue, tps, value, priority, time.Now())
+}
+
+// PushOntoQueueAt pushes the passed in value to the passed in queue like PushOntoQueue, but the value won't be
+// popped off before the given time
+func PushOntoQueueAt(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority, at time.Time) error {
+	if priority < LowPriority || priority > MaxPriority {
+		return fmt.Errorf("invalid priority %d", priority)
+	}
+
+	// values are stored prefixed with when they're due, which stays with them if they're moved, e.g. when the rest of a
+	// batch is put back, so that pops can tell how long they waited
+	epochMS := strconv.FormatFloat(float64(at.UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	_, err := redis.Int(scriptPush.Do(conn, epochMS, qType, queue, tps, priority, epochMS+"|"+value))
+	return err
+}
+
+// scheduledItem is how values are stored in the scheduled set until they are due
+type scheduledItem struct {
+	Queue    string `json:"queue"`
+	TPS      string `json:"tps"`
+	Priority string `json:"priority"`
+	Value    string `json:"value"`
+}
+
+// ScheduleOntoQueue parks the passed in value in the scheduled set for the queue type, from where it will be pushed
+// onto the passed in queue by the releaser once the given time is reached
+func ScheduleOntoQueue(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority, at time.Time) error {
+	item, err := json.Marshal(&scheduledItem{Queue: queue, TPS: strconv.Itoa(tps), Priority: strconv.Itoa(int(priority)), Value: value})
+	if err != nil {
+		return err
+	}
+
+	epochMS := strconv.FormatFloat(float64(at.UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	_, err = conn.Do("ZADD", qType+":scheduled", epochMS, item)
+	return err
+}
+
+//go:embed lua/release.lua
+var luaRelease string
+var scriptRelease = redis.NewScript(2, luaRelease)
+
+// ReleaseScheduled pushes any scheduled values which are now due onto their queues, returning how many were released
+func ReleaseScheduled(conn redis.Conn, qType string) (int, error) {
 	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
-	_, err := redis.Int(scriptPush.Do(conn, epochMS, qType, queue, tps, priority, value))
+	return redis.Int(scriptRelease.Do(conn, epochMS, qType))
+}
+
+// PauseQueue pauses popping from the passed in queue for the given duration, or if bulkOnly is set, only popping of
+// its bulk values. Pauses are keyed by queue name alone so apply to queues of that name of any type.
+func PauseQueue(conn redis.Conn, queue string, duration time.Duration, bulkOnly bool) error {
+	key := "rate_limit:" + queue
+	if bulkOnly {
+		key = "rate_limit_bulk:" + queue
+	}
+
+	_, err := conn.Do("SET", key, "engaged", "PX", max(duration.Milliseconds(), 1))
+	return err
+}
+
+// SetTPS sets the TPS of the passed in queue, which takes precedence over the TPS its values were pushed with, including
+// values already queued. A negative TPS removes it so that the TPS values were pushed with applies again.
+func SetTPS(conn redis.Conn, qType string, queue string, tps int) error {
+	var err error
+	if tps < 0 {
+		_, err = conn.Do("HDEL", qType+":tps", queue)
+	} else {
+		_, err = conn.Do("HSET", qType+":tps", queue, tps)
+	}
+	return err
+}
+
+// SetOriginPriorities sets the priorities that values of the passed in origins are popped with, overriding the priority
+// they're pushed with, so that they're moved to that priority as soon as they're due, whoever pushed them. Values are
+// JSON objects and their origin is taken from their origin field. An empty map removes all origin priorities.
+func SetOriginPriorities(conn redis.Conn, qType string, priorities map[string]Priority) error {
+	args := redis.Args{}.Add(qType + ":priorities")
+	for origin, priority := range priorities {
+		if priority < LowPriority || priority > MaxPriority {
+			return fmt.Errorf("invalid priority %d", priority)
+		}
+		args = args.Add(origin, priority)
+	}
+
+	conn.Send("MULTI")
+	conn.Send("DEL", qType+":priorities")
+	if len(priorities) > 0 {
+		conn.Send("HSET", args...)
+	}
+	_, err := conn.Do("EXEC")
 	return err
 }
 
+// MigrateTPS finds queues which have values split across keys pushed with different TPS, and which don't have their
+// own TPS, and sets their TPS to that of the key with the most recently pushed value. Only does anything the first time
+// it's called for a queue type. Returns the names of the queues migrated so that callers can replace the TPS we've given
+// them with their actual TPS.
+func MigrateTPS(conn redis.Conn, qType string) ([]string, error) {
+	// only migrate once rather than pinning the TPS of queues which are split later on
+	done, err := redis.Bool(conn.Do("EXISTS", qType+":tps:migrated"))
+	if err != nil || done {
+		return nil, err
+	}
+
+	infos, err := ListQueues(conn, qType)
+	if err != nil {
+		return nil, err
+	}
+
+	byQueue := make(map[string][]*Info)
+	for _, info := range infos {
+		byQueue[info.Queue] = append(byQueue[info.Queue], info)
+	}
+
+	migrated := make([]string, 0, len(byQueue))
+	for name, keys := range byQueue {
+		if len(keys) < 2 || keys[0].TPSSet {
+			continue
+		}
+
+		newestTPS, newest := 0, 0.0
+		for _, info := range keys {
+			for _, priority := range priorities() {
+				last, err := redis.Values(conn.Do("ZRANGE", fmt.Sprintf("%s/%d", info.key, priority), -1, -1, "WITHSCORES"))
+				if err != nil {
+					return migrated, fmt.Errorf("error reading queue %s: %w", info.key, err)
+				}
+				if len(last) == 2 {
+					if score, _ := redis.Float64(last[1], nil); score > newest {
+						newestTPS, newest = info.keyTPS, score
+					}
+				}
+			}
+		}
+
+		if err := SetTPS(conn, qType, name, newestTPS); err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, name)
+	}
+
+	// only record that we've migrated once every queue has been, so that a failed migration is tried again
+	if _, err := conn.Do("SET", qType+":tps:migrated", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return migrated, err
+	}
+	return migrated, nil
+}
+
+// ExtractThrottles returns how many times each queue of the passed in type has been throttled by its TPS or rate limits
+// since the last call
+func ExtractThrottles(conn redis.Conn, qType string) (map[string]int, error) {
+	conn.Send("MULTI")
+	conn.Send("HGETALL", qType+":throttles")
+	conn.Send("DEL", qType+":throttles")
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	return redis.IntMap(values[0], nil)
+}
+
+// ResumeQueue removes any pause on popping from the passed in queue
+func ResumeQueue(conn redis.Conn, queue string) error {
+	_, err := conn.Do("DEL", "rate_limit:"+queue, "rate_limit_bulk:"+queue)
+	return err
+}
+
+// State is the state of a queue, i.e. which set it's currently in
+type State string
+
+// possible queue states
+const (
+	StateActive    = State("active")
+	StateThrottled = State("throttled")
+	StateFuture    = State("future")
+)
+
+// Info describes a queue and its current state. Sizes are numbers of values pushed, each of which may be a batch.
+type Info struct {
+	Queue      string `json:"queue"`
+	TPS        int    `json:"tps"`     // TPS that applies when popping
+	TPSSet     bool   `json:"tps_set"` // whether TPS was set on the queue rather than when pushed
+	State      State  `json:"state"`
+	Workers    int    `json:"workers"`
+	Size       int    `json:"size"` // size of all priorities above bulk
+	BulkSize   int    `json:"bulk_size"`
+	CurrentTPS int    `json:"current_tps"`
+	Paused     bool   `json:"paused"`
+	BulkPaused bool   `json:"bulk_paused"`
+	Limited    bool   `json:"limited"` // whether a rate limit has been hit
+
+	key    string
+	keyTPS int
+}
+
+// ListQueues returns information on all the queues of the passed in type which are active, throttled or only have
+// values due in the future
+func ListQueues(conn redis.Conn, qType string) ([]*Info, error) {
+	infos := make([]*Info, 0)
+	seen := make(map[string]bool)
+
+	for _, state := range []State{StateActive, StateThrottled, StateFuture} {
+		values, err := redis.Values(conn.Do("ZRANGE", fmt.Sprintf("%s:%s", qType, state), 0, -1, "WITHSCORES"))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s queues: %w", state, err)
+		}
+
+		for len(values) > 0 {
+			var key string
+			var workers float64
+			if values, err = redis.Scan(values, &key, &workers); err != nil {
+				return nil, fmt.Errorf("error reading %s queues: %w", state, err)
+			}
+
+			// a queue can be active and have future values at the same time
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			info, err := readQueueInfo(conn, qType, key, state, int(workers))
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+	}
+
+	return infos, nil
+}
+
+// GetQueues returns information on the queues with the passed in name, of which there can be more than one if its
+// TPS has changed while it had values
+func GetQueues(conn redis.Conn, qType, queue string) ([]*Info, error) {
+	all, err := ListQueues(conn, qType)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*Info, 0, 1)
+	for _, info := range all {
+		if info.Queue == queue {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// PurgeQueue removes all values from the queues with the passed in name, returning how many were removed
+func PurgeQueue(conn redis.Conn, qType, queue string) (int, error) {
+	infos, err := GetQueues(conn, qType, queue)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, info := range infos {
+		keys := make([]any, 0, MaxPriority+1)
+		for _, priority := range priorities() {
+			keys = append(keys, fmt.Sprintf("%s/%d", info.key, priority))
+		}
+		if _, err := conn.Do("DEL", keys...); err != nil {
+			return purged, fmt.Errorf("error purging queue %s: %w", info.key, err)
+		}
+		purged += info.Size + info.BulkSize
+	}
+	return purged, nil
+}
+
+func readQueueInfo(conn redis.Conn, qType, key string, state State, workers int) (*Info, error) {
+	// our key is in the format msgs:name|tps, break it apart
+	name, tpsStr, found := strings.Cut(strings.TrimPrefix(key, qType+":"), "|")
+	if !found {
+		return nil, fmt.Errorf("error parsing queue key '%s'", key)
+	}
+	keyTPS, _ := strconv.Atoi(tpsStr)
+
+	for _, priority := range priorities() {
+		conn.Send("ZCARD", fmt.Sprintf("%s/%d", key, priority))
+	}
+	conn.Send("GET", fmt.Sprintf("%s:%s:tps:%d", qType, name, time.Now().Unix()))
+	conn.Send("EXISTS", "rate_limit:"+name)
+	conn.Send("EXISTS", "rate_limit_bulk:"+name)
+	conn.Send("HGET", qType+":tps", name)
+	conn.Send("EXISTS", fmt.Sprintf("%s:%s:limited", qType, name))
+	conn.Flush()
+
+	info := &Info{Queue: name, TPS: keyTPS, State: state, Workers: workers, key: key, keyTPS: keyTPS}
+	var err error
+
+	// size is that of all our priorities above bulk
+	for _, priority := range priorities() {
+		size, err := redis.Int(conn.Receive())
+		if err != nil {
+			return nil, fmt.Errorf("error reading queue size: %w", err)
+		}
+		if priority == LowPriority {
+			info.BulkSize = size
+		} else {
+			info.Size += size
+		}
+	}
+	if info.CurrentTPS, err = redis.Int(conn.Receive()); err != nil && err != redis.ErrNil {
+		return nil, fmt.Errorf("error reading queue current tps: %w", err)
+	}
+	if info.Paused, err = redis.Bool(conn.Receive()); err != nil {
+		return nil, fmt.Errorf("error reading queue pause: %w", err)
+	}
+	if info.BulkPaused, err = redis.Bool(conn.Receive()); err != nil {
+		return nil, fmt.Errorf("error reading queue bulk pause: %w", err)
+	}
+	if tps, err := redis.Int(conn.Receive()); err == nil {
+		info.TPS, info.TPSSet = tps, true
+	} else if err != redis.ErrNil {
+		return nil, fmt.Errorf("error reading queue tps: %w", err)
+	}
+	if info.Limited, err = redis.Bool(conn.Receive()); err != nil {
+		return nil, fmt.Errorf("error reading queue limited: %w", err)
+	}
+
+	return info, nil
+}
+
+// WorkerLease is how long a worker has to mark a popped value as complete before the reaper can reclaim it
+const WorkerLease = 5 * time.Minute
+
 //go:embed lua/pop.lua
 var luaPop string
-var scriptPop = redis.NewScript(2, luaPop)
+var scriptPop = redis.NewScript(4, luaPop)
 
 // PopFromQueue pops the next available message from the passed in queue. If QueueRetry
 // is returned the caller should immediately make another call to get the next value. A
 // worker token of EmptyQueue will be returned if there are no more items to retrive.
-// Otherwise the WorkerToken should be saved in order to mark the task as complete later.
-func PopFromQueue(conn redis.Conn, qType string) (WorkerToken, string, error) {
+// Otherwise the WorkerToken should be saved in order to mark the task as complete later,
+// and before WorkerLease has passed, after which it can be reclaimed by ReapLeases. The
+// time the value was pushed, or was due if it was pushed for later, is also returned.
+func PopFromQueue(conn redis.Conn, qType string) (WorkerToken, string, time.Time, error) {
 	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
-	values, err := redis.Strings(scriptPop.Do(conn, epochMS, qType))
+	weights, _ := json.Marshal(priorityWeights)
+	values, err := redis.Strings(scriptPop.Do(conn, epochMS, qType, int(WorkerLease/time.Second), weights))
 	if err != nil {
 		slog.Error("error popping from queue", "error", err)
-		return "", "", err
+		return "", "", time.Time{}, err
+	}
+
+	var pushedOn time.Time
+	if len(values) > 2 {
+		epoch, _ := strconv.ParseFloat(values[2], 64)
+		pushedOn = time.UnixMicro(int64(epoch * 1000000))
 	}
-	return WorkerToken(values[0]), values[1], nil
+
+	return WorkerToken(values[0]), values[1], pushedOn, nil
 }
 
 //go:embed lua/complete.lua
@@ -79,6 +522,18 @@ func MarkComplete(conn redis.Conn, qType string, token WorkerToken) error {
 	return err
 }
 
+//go:embed lua/refund.lua
+var luaRefund string
+var scriptRefund = redis.NewScript(2, luaRefund)
+
+// Refund takes the pop of the value with the passed in token back off the counts of its queue's TPS and rate limits,
+// for when it was popped but won't be processed, e.g. because it's been put back. It must be called before the task
+// is marked as complete.
+func Refund(conn redis.Conn, qType string, token WorkerToken) error {
+	_, err := scriptRefund.Do(conn, qType, token)
+	return err
+}
+
 //go:embed lua/dethrottle.lua
 var luaDethrottle string
 var scriptDethrottle = redis.NewScript(1, luaDethrottle)
@@ -111,3 +566,74 @@ func StartDethrottler(redis *redis.Pool, quitter chan bool, wg *sync.WaitGroup,
 		}
 	}()
 }
+
+//go:embed lua/reap.lua
+var luaReap string
+var scriptReap = redis.NewScript(3, luaReap)
+
+// ReapLeases releases the workers of any popped values whose leases have expired without them being marked as
+// complete, e.g. because the worker crashed. If requeue is true those values are pushed back onto their queues.
+func ReapLeases(conn redis.Conn, qType string, requeue bool) (int, error) {
+	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	requeueArg := "0"
+	if requeue {
+		requeueArg = "1"
+	}
+	return redis.Int(scriptReap.Do(conn, epochMS, qType, requeueArg))
+}
+
+// StartReaper starts a goroutine responsible for reaping expired worker leases every ten seconds. The passed in
+// quitter chan can be used to shut down the goroutine
+func StartReaper(redis *redis.Pool, quitter chan bool, wg *sync.WaitGroup, qType string, requeue bool) {
+	wg.Add(1)
+
+	go func() {
+		for {
+			select {
+			case <-quitter:
+				wg.Done()
+				return
+
+			case <-time.After(10 * time.Second):
+				rc := redis.Get()
+				count, err := ReapLeases(rc, qType, requeue)
+				if err != nil {
+					slog.Error("error reaping worker leases", "error", err)
+				} else if count > 0 {
+					slog.Warn("reaped expired worker leases", "count", count, "requeued", requeue)
+				}
+				rc.Close()
+			}
+		}
+	}()
+}
+
+// StartReleaser starts a goroutine responsible for releasing scheduled values onto their queues, and expired URN locks
+// to the values waiting for them, every second. The passed in quitter chan can be used to shut down the goroutine
+func StartReleaser(redis *redis.Pool, quitter chan bool, wg *sync.WaitGroup, qType string) {
+	wg.Add(1)
+
+	go func() {
+		delay := time.Second - time.Duration(time.Now().UnixNano()%int64(time.Second))
+
+		for {
+			select {
+			case <-quitter:
+				wg.Done()
+				return
+
+			case <-time.After(delay):
+				rc := redis.Get()
+				if _, err := ReleaseScheduled(rc, qType); err != nil {
+					slog.Error("error releasing scheduled", "error", err)
+				}
+				if _, err := ReleaseURNs(rc, qType); err != nil {
+					slog.Error("error releasing URN locks", "error", err)
+				}
+				rc.Close()
+
+				delay = time.Second - time.Duration(time.Now().UnixNano()%int64(time.Second))
+			}
+		}
+	}()
+}
@@ -1,6 +1,7 @@
 package queue
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -39,253 +40,1386 @@ func getPool() *redis.Pool {
 	return redisPool
 }
 
-func TestLua(t *testing.T) {
-	rp := getPool()
-	rc := rp.Get()
-	defer rc.Close()
+// runs the given test against each of our queue implementations
+func testQueues(t *testing.T, fn func(*testing.T, Queue)) {
+	t.Run("valkey", func(t *testing.T) { fn(t, NewValkeyQueue(getPool(), "msgs")) })
+	t.Run("memory", func(t *testing.T) { fn(t, NewMemoryQueue("msgs")) })
+}
+
+// calls the given function with a connection if the given queue is stored in Valkey so tests can check its state
+func ifValkey(q Queue, fn func(redis.Conn)) {
+	if vq, ok := q.(*valkeyQueue); ok {
+		rc := vq.rp.Get()
+		defer rc.Close()
+		fn(rc)
+	}
+}
+
+// starts a dethrottler if the given queue needs one, returning a function to stop it
+func startDethrottler(q Queue) func() {
+	vq, ok := q.(*valkeyQueue)
+	if !ok {
+		return func() {}
+	}
 
-	// start our dethrottler
 	quitter := make(chan bool)
 	wg := &sync.WaitGroup{}
-	StartDethrottler(rp, quitter, wg, "msgs")
-	defer close(quitter)
+	StartDethrottler(vq.rp, quitter, wg, vq.qType)
+	return func() { close(quitter); wg.Wait() }
+}
 
-	rate := 10
+// pops from the given queue, retrying as many times as it asks us to up to a limit
+func popQueue(t *testing.T, q Queue) (WorkerToken, string) {
+	t.Helper()
 
-	// add 20 messages with ids 0-19
-	for i := 0; i < 20; i++ {
-		err := PushOntoQueue(rc, "msgs", "chan1", rate, fmt.Sprintf(`[{"id":%d}]`, i), LowPriority)
+	for range 10 {
+		token, value, _, err := q.Pop()
 		require.NoError(t, err)
+		if token != Retry {
+			return token, value
+		}
 	}
+	return Retry, ""
+}
 
-	// get ourselves aligned with a second boundary
-	delay := time.Second*2 - time.Duration(time.Now().UnixNano()%int64(time.Second))
-	time.Sleep(delay)
-
-	// mark chan1 as rate limited
-	rc.Do("SET", "rate_limit_bulk:chan1", "engaged")
-	rc.Do("EXPIRE", "rate_limit_bulk:chan1", 5)
+// asserts that nothing can currently be popped from the given queue
+func assertNoValue(t *testing.T, q Queue) {
+	t.Helper()
 
-	// popping shouldn't error or return a value
-	queue, value, err := PopFromQueue(rc, "msgs")
-	assert.NoError(t, err)
+	token, value := popQueue(t, q)
+	assert.Contains(t, []WorkerToken{Retry, EmptyQueue}, token)
 	assert.Equal(t, "", value)
-	assert.Equal(t, Retry, queue)
+}
+
+func TestWorkerToken(t *testing.T) {
+	assert.Equal(t, "msgs:chan1|10", WorkerToken("msgs:chan1|10/2@123").Key())
+	assert.Equal(t, Priority(2), WorkerToken("msgs:chan1|10/2@123").Priority())
+	assert.Equal(t, "msgs:chan1|10", WorkerToken("msgs:chan1|10").Key())
+	assert.Equal(t, Priority(0), WorkerToken("msgs:chan1|10").Priority())
+}
+
+func TestQueue(t *testing.T) {
+	testQueues(t, func(t *testing.T, q Queue) {
+		// start our dethrottler
+		defer startDethrottler(q)()
+
+		rate := 10
+
+		// add 20 messages with ids 0-19
+		for i := 0; i < 20; i++ {
+			err := q.Push("chan1", rate, fmt.Sprintf(`[{"id":%d}]`, i), LowPriority, time.Now())
+			require.NoError(t, err)
+		}
+
+		// get ourselves aligned with a second boundary
+		delay := time.Second*2 - time.Duration(time.Now().UnixNano()%int64(time.Second))
+		time.Sleep(delay)
+
+		// mark chan1 as rate limited
+		require.NoError(t, q.Pause("chan1", 5*time.Second, true))
+
+		// popping shouldn't error or return a value
+		assertNoValue(t, q)
+
+		// unmark chan1 as rate limited
+		require.NoError(t, q.Resume("chan1"))
+
+		// pop 10 items off
+		for i := 0; i < 10; i++ {
+			queue, value := popQueue(t, q)
+			assert.NotEqual(t, queue, EmptyQueue)
+			assert.Equal(t, fmt.Sprintf(`{"id":%d}`, i), value)
+		}
+
+		// next value should be throttled
+		assertNoValue(t, q)
+
+		// check our redis state
+		ifValkey(q, func(rc redis.Conn) {
+			assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{})
+			assertvk.ZGetAll(t, rc, "msgs:throttled", map[string]float64{"msgs:chan1|10": 10})
+			assertvk.ZGetAll(t, rc, "msgs:future", map[string]float64{})
+		})
+
+		// adding more items shouldn't change that
+		err := q.Push("chan1", rate, `[{"id":30}]`, LowPriority, time.Now())
+		assert.NoError(t, err)
+
+		ifValkey(q, func(rc redis.Conn) {
+			assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{})
+			assertvk.ZGetAll(t, rc, "msgs:throttled", map[string]float64{"msgs:chan1|10": 10})
+			assertvk.ZGetAll(t, rc, "msgs:future", map[string]float64{})
+		})
+
+		// but if we wait, our next msg should be our highest priority
+		time.Sleep(time.Second)
+
+		err = q.Push("chan1", rate, `[{"id":31}]`, HighPriority, time.Now())
+		assert.NoError(t, err)
+
+		// make sure pause bulk key do not prevent use to get from the high priority queue
+		require.NoError(t, q.Pause("chan1", 5*time.Second, true))
+
+		queue, value := popQueue(t, q)
+		assert.Equal(t, "msgs:chan1|10", queue.Key())
+		assert.Equal(t, `{"id":31}`, value)
+
+		// make sure paused is not present for more tests
+		require.NoError(t, q.Resume("chan1"))
+
+		// should get next five bulk msgs fine
+		for i := 10; i < 15; i++ {
+			queue, value := popQueue(t, q)
+			assert.NotEqual(t, queue, EmptyQueue)
+			assert.Equal(t, fmt.Sprintf(`{"id":%d}`, i), value)
+		}
+
+		// push a multi-message batch for a single contact
+		err = q.Push("chan1", rate, `[{"id":32}, {"id":33}]`, HighPriority, time.Now())
+		assert.NoError(t, err)
+
+		queue, value = popQueue(t, q)
+		assert.Equal(t, "msgs:chan1|10", queue.Key())
+		assert.Equal(t, `{"id":32}`, value)
+
+		ifValkey(q, func(rc redis.Conn) {
+			assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{"msgs:chan1|10": 17})
+			assertvk.ZGetAll(t, rc, "msgs:throttled", map[string]float64{})
+			assertvk.ZGetAll(t, rc, "msgs:future", map[string]float64{"msgs:chan1|10": 0})
+		})
+
+		// sleep a few seconds
+		time.Sleep(2 * time.Second)
+
+		// pop remaining bulk off
+		for i := 15; i < 20; i++ {
+			queue, value := popQueue(t, q)
+			assert.NotEqual(t, queue, EmptyQueue)
+			assert.Equal(t, fmt.Sprintf(`{"id":%d}`, i), value)
+		}
+
+		// next should be 30
+		queue, value = popQueue(t, q)
+		assert.NotEqual(t, queue, EmptyQueue)
+		assert.Equal(t, `{"id":30}`, value)
+
+		// popping again should give us nothing since it is too soon to send 33
+		assertNoValue(t, q)
+
+		// but if we sleep 6 seconds should get it
+		time.Sleep(time.Second * 6)
 
-	// unmark chan1 as rate limited
-	rc.Do("DEL", "rate_limit_bulk:chan1")
+		queue, value = popQueue(t, q)
+		assert.Equal(t, "msgs:chan1|10", queue.Key())
+		assert.Equal(t, `{"id":33}`, value)
 
-	// pop 10 items off
-	for i := 0; i < 10; i++ {
-		queue, value, err := PopFromQueue(rc, "msgs")
+		// nothing should be left
+		assertNoValue(t, q)
+
+		err = q.Push("chan1", rate, `[{"id":34}]`, HighPriority, time.Now())
 		assert.NoError(t, err)
+
+		require.NoError(t, q.Pause("chan1", 5*time.Second, false))
+
+		// we have the rate limit set
+		assertNoValue(t, q)
+
+		time.Sleep(2 * time.Second)
+
+		assertNoValue(t, q)
+
+		ifValkey(q, func(rc redis.Conn) {
+			assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{})
+			assertvk.ZGetAll(t, rc, "msgs:throttled", map[string]float64{"msgs:chan1|10": 0})
+			assertvk.ZGetAll(t, rc, "msgs:future", map[string]float64{})
+		})
+
+		// but if we wait for the rate limit to expire
+		time.Sleep(3 * time.Second)
+
+		// next should be 34
+		queue, value = popQueue(t, q)
 		assert.NotEqual(t, queue, EmptyQueue)
-		assert.Equal(t, fmt.Sprintf(`{"id":%d}`, i), value)
+		assert.Equal(t, `{"id":34}`, value)
+
+		// nothing should be left
+		assertNoValue(t, q)
+	})
+}
+
+func TestFairness(t *testing.T) {
+	testQueues(t, func(t *testing.T, q Queue) {
+		require.NoError(t, q.Push("chan1", 0, `[{"id":1}]`, LowPriority, time.Now()))
+		require.NoError(t, q.Push("chan1", 0, `[{"id":2}]`, LowPriority, time.Now()))
+		require.NoError(t, q.Push("chan2", 0, `[{"id":3}]`, LowPriority, time.Now()))
+		require.NoError(t, q.Push("chan2", 0, `[{"id":4}]`, LowPriority, time.Now()))
+
+		// queues with the fewest workers are popped from first
+		token1, value := popQueue(t, q)
+		assert.Equal(t, "msgs:chan1|0", token1.Key())
+		assert.Equal(t, `{"id":1}`, value)
+
+		token2, value := popQueue(t, q)
+		assert.Equal(t, "msgs:chan2|0", token2.Key())
+		assert.Equal(t, `{"id":3}`, value)
+
+		// chan2 now has fewer workers than chan1
+		require.NoError(t, q.MarkComplete(token2))
+
+		token, value := popQueue(t, q)
+		assert.Equal(t, "msgs:chan2|0", token.Key())
+		assert.Equal(t, `{"id":4}`, value)
+
+		token, value = popQueue(t, q)
+		assert.Equal(t, "msgs:chan1|0", token.Key())
+		assert.Equal(t, `{"id":2}`, value)
+
+		assertNoValue(t, q)
+	})
+}
+
+func TestPriorities(t *testing.T) {
+	// use small weights so that we can see lower priorities getting their share
+	defer func(w [MaxPriority + 1]int) { priorityWeights = w }(priorityWeights)
+	priorityWeights = [MaxPriority + 1]int{1, 2, 3, 4}
+
+	testQueues(t, func(t *testing.T, q Queue) {
+		assert.EqualError(t, q.Push("chan1", 0, `[{"id":1}]`, MaxPriority+1, time.Now()), "invalid priority 4")
+		assert.EqualError(t, q.Push("chan1", 0, `[{"id":1}]`, -1, time.Now()), "invalid priority -1")
+
+		for i := range 4 {
+			for _, p := range []Priority{0, 1, 2, 3} {
+				require.NoError(t, q.Push("chan1", 0, fmt.Sprintf(`[{"id":%d}]`, int(p)*10+i), p, time.Now()))
+			}
+		}
+
+		ifValkey(q, func(rc redis.Conn) {
+			infos, err := GetQueues(rc, "msgs", "chan1")
+			require.NoError(t, err)
+			assert.Equal(t, 12, infos[0].Size)
+			assert.Equal(t, 4, infos[0].BulkSize)
+		})
+
+		// higher priorities are preferred but every priority gets popped in proportion to its weight
+		popped := make([]string, 0, 16)
+		for range 16 {
+			token, value := popQueue(t, q)
+			require.NoError(t, q.MarkComplete(token))
+			popped = append(popped, value)
+		}
+		assert.Equal(t, []string{
+			`{"id":30}`, `{"id":31}`, `{"id":32}`, `{"id":20}`,
+			`{"id":21}`, `{"id":22}`, `{"id":10}`, `{"id":11}`,
+			`{"id":0}`, `{"id":33}`, `{"id":1}`, `{"id":23}`,
+			`{"id":12}`, `{"id":2}`, `{"id":13}`, `{"id":3}`,
+		}, popped)
+
+		assertNoValue(t, q)
+
+		// bulk values aren't popped while bulk is paused, and values of other priorities are unaffected
+		require.NoError(t, q.Push("chan1", 0, `[{"id":1}]`, LowPriority, time.Now()))
+		require.NoError(t, q.Push("chan1", 0, `[{"id":2}]`, MaxPriority, time.Now()))
+		require.NoError(t, q.Pause("chan1", time.Minute, true))
+
+		token, value := popQueue(t, q)
+		assert.Equal(t, `{"id":2}`, value)
+		assert.Equal(t, "msgs:chan1|0", token.Key())
+		assert.Equal(t, Priority(MaxPriority), token.Priority())
+		require.NoError(t, q.MarkComplete(token))
+
+		assertNoValue(t, q)
+	})
+}
+
+func TestOriginPriorities(t *testing.T) {
+	rc := getPool().Get()
+	defer rc.Close()
+
+	assert.EqualError(t, SetOriginPriorities(rc, "msgs", map[string]Priority{"ticket": MaxPriority + 1}), "invalid priority 4")
+
+	require.NoError(t, SetOriginPriorities(rc, "msgs", map[string]Priority{"ticket": 3, "flow": 2, "broadcast": 0}))
+	assertvk.HGetAll(t, rc, "msgs:priorities", map[string]string{"ticket": "3", "flow": "2", "broadcast": "0"})
+
+	// flow msgs are queued first, then a broadcast and a ticket msg, all pushed as high priority
+	for i := range 3 {
+		require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 0, fmt.Sprintf(`[{"id":%d,"origin":"flow"}]`, i+1), HighPriority))
 	}
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 0, `[{"id":4,"origin":"broadcast"}]`, HighPriority))
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 0, `[{"id":5,"origin":"ticket"}]`, HighPriority))
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 0, `[{"id":6}]`, HighPriority))
+
+	// they're queued with the priority they were pushed with
+	assertvk.ZCard(t, rc, "msgs:chan1|0/1", 6)
+
+	// but popping moves each to the priority of its origin, or leaves it if it doesn't have one, so the ticket msg
+	// overtakes the earlier flow msgs
+	token, value, _, err := PopFromQueue(rc, "msgs")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":5,"origin":"ticket"}`, value)
+	assert.Equal(t, Priority(3), token.Priority())
+
+	assertvk.ZCard(t, rc, "msgs:chan1|0/3", 0)
+	assertvk.ZCard(t, rc, "msgs:chan1|0/2", 3)
+	assertvk.ZCard(t, rc, "msgs:chan1|0/1", 1)
+	assertvk.ZCard(t, rc, "msgs:chan1|0/0", 1)
+	assertvk.HLen(t, rc, "msgs:swept", 3)
+
+	token, value, _, err = PopFromQueue(rc, "msgs")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":1,"origin":"flow"}`, value)
+	assert.Equal(t, Priority(2), token.Priority())
+
+	// values pushed after that are moved too
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 0, `[{"id":7,"origin":"ticket"}]`, HighPriority))
+
+	token, value, _, err = PopFromQueue(rc, "msgs")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":7,"origin":"ticket"}`, value)
+	assert.Equal(t, Priority(3), token.Priority())
+
+	// clearing priorities means values are popped with the priority they're pushed with
+	require.NoError(t, SetOriginPriorities(rc, "msgs", map[string]Priority{}))
+	assertvk.HGetAll(t, rc, "msgs:priorities", map[string]string{})
+
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 0, `[{"id":8,"origin":"ticket"}]`, HighPriority))
+	assertvk.ZCard(t, rc, "msgs:chan1|0/1", 2)
+
+	// and through the queue interface, where priorities also apply to values pushed before they were set
+	testQueues(t, func(t *testing.T, q Queue) {
+		assert.EqualError(t, q.SetOriginPriorities(map[string]Priority{"ticket": MaxPriority + 1}), "invalid priority 4")
+
+		require.NoError(t, q.Push("chan1", 0, `[{"id":1,"origin":"flow"}]`, HighPriority, time.Now()))
+		require.NoError(t, q.Push("chan1", 0, `[{"id":2,"origin":"ticket"}]`, HighPriority, time.Now()))
+
+		require.NoError(t, q.SetOriginPriorities(map[string]Priority{"ticket": 3, "flow": 2}))
+
+		token, value := popQueue(t, q)
+		assert.JSONEq(t, `{"id":2,"origin":"ticket"}`, value)
+		assert.Equal(t, Priority(3), token.Priority())
+
+		token, value = popQueue(t, q)
+		assert.JSONEq(t, `{"id":1,"origin":"flow"}`, value)
+		assert.Equal(t, Priority(2), token.Priority())
+	})
+}
+
+func TestPushedOn(t *testing.T) {
+	testQueues(t, func(t *testing.T, q Queue) {
+		defer startDethrottler(q)()
 
-	// next value should be throttled
-	queue, value, err = PopFromQueue(rc, "msgs")
+		pushedOn := time.Now().Add(-10 * time.Second)
+		require.NoError(t, q.Push("chan1", 0, `[{"id":1},{"id":2}]`, LowPriority, pushedOn))
+
+		token, value, popPushedOn, err := q.Pop()
+		assert.NoError(t, err)
+		assert.Equal(t, `{"id":1}`, value)
+		assert.WithinDuration(t, pushedOn, popPushedOn, time.Millisecond)
+		require.NoError(t, q.MarkComplete(token))
+
+		// the rest of the batch is put back for later but keeps the time it was pushed
+		time.Sleep(4 * time.Second)
+
+		token, value = popQueue(t, q)
+		assert.Equal(t, `{"id":2}`, value)
+		require.NoError(t, q.MarkComplete(token))
+
+		require.NoError(t, q.Push("chan1", 0, `[{"id":3}]`, HighPriority, pushedOn))
+
+		ifValkey(q, func(rc redis.Conn) {
+			// values pushed by others without a time are assumed to have been pushed when they were due
+			rc.Do("ZADD", "msgs:chan1|0/0", 1700000000, `[{"id":4}]`)
+		})
+
+		_, value, popPushedOn, err = q.Pop()
+		assert.NoError(t, err)
+		assert.Equal(t, `{"id":3}`, value)
+		assert.WithinDuration(t, pushedOn, popPushedOn, time.Millisecond)
+
+		ifValkey(q, func(rc redis.Conn) {
+			_, value, popPushedOn, err = PopFromQueue(rc, "msgs")
+			assert.NoError(t, err)
+			assert.Equal(t, `{"id":4}`, value)
+			assert.Equal(t, time.Unix(1700000000, 0), popPushedOn)
+		})
+
+		assertNoValue(t, q)
+	})
+}
+
+func TestPushOntoQueueAt(t *testing.T) {
+	testQueues(t, func(t *testing.T, q Queue) {
+		err := q.Push("chan1", 0, `[{"id":1}]`, HighPriority, time.Now().Add(time.Second))
+		require.NoError(t, err)
+
+		// too soon to get our value
+		assertNoValue(t, q)
+
+		ifValkey(q, func(rc redis.Conn) {
+			assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{})
+			assertvk.ZGetAll(t, rc, "msgs:future", map[string]float64{"msgs:chan1|0": 0})
+		})
+
+		// but once that time has passed and the queue is moved back to active, we can pop it
+		time.Sleep(time.Second)
+		ifValkey(q, func(rc redis.Conn) { rc.Do("ZINCRBY", "msgs:active", 0, "msgs:chan1|0") })
+
+		queue, value := popQueue(t, q)
+		assert.Equal(t, "msgs:chan1|0", queue.Key())
+		assert.Equal(t, `{"id":1}`, value)
+	})
+}
+
+func TestScheduleOntoQueue(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	err := ScheduleOntoQueue(rc, "msgs", "chan1", 10, `[{"id":1}]`, LowPriority, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	err = ScheduleOntoQueue(rc, "msgs", "chan1", 10, `[{"id":2}]`, HighPriority, time.Now().Add(-time.Second))
+	require.NoError(t, err)
+
+	assertvk.ZCard(t, rc, "msgs:scheduled", 2)
+
+	// only one is due
+	released, err := ReleaseScheduled(rc, "msgs")
 	assert.NoError(t, err)
-	assert.Equal(t, "", value)
-	assert.Equal(t, Retry, queue)
+	assert.Equal(t, 1, released)
 
-	// check our redis state
-	assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{})
-	assertvk.ZGetAll(t, rc, "msgs:throttled", map[string]float64{"msgs:chan1|10": 10})
-	assertvk.ZGetAll(t, rc, "msgs:future", map[string]float64{})
+	assertvk.ZCard(t, rc, "msgs:scheduled", 1)
+	assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{"msgs:chan1|10": 0})
 
-	// adding more items shouldn't change that
-	err = PushOntoQueue(rc, "msgs", "chan1", rate, `[{"id":30}]`, LowPriority)
+	queue, value, _, err := PopFromQueue(rc, "msgs")
 	assert.NoError(t, err)
+	assert.Equal(t, "msgs:chan1|10", queue.Key())
+	assert.Equal(t, `{"id":2}`, value)
 
-	assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{})
-	assertvk.ZGetAll(t, rc, "msgs:throttled", map[string]float64{"msgs:chan1|10": 10})
-	assertvk.ZGetAll(t, rc, "msgs:future", map[string]float64{})
+	// nothing more to pop until the other is released
+	assertNoValue(t, NewValkeyQueue(rp, "msgs"))
 
-	// but if we wait, our next msg should be our highest priority
 	time.Sleep(time.Second)
 
-	err = PushOntoQueue(rc, "msgs", "chan1", rate, `[{"id":31}]`, HighPriority)
+	released, err = ReleaseScheduled(rc, "msgs")
 	assert.NoError(t, err)
+	assert.Equal(t, 1, released)
+	assertvk.ZCard(t, rc, "msgs:scheduled", 0)
 
-	// make sure pause bulk key do not prevent use to get from the high priority queue
-	rc.Do("SET", "rate_limit_bulk:chan1", "engaged")
-	rc.Do("EXPIRE", "rate_limit_bulk:chan1", 5)
+	queue, value, _, err = PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, "msgs:chan1|10", queue.Key())
+	assert.Equal(t, `{"id":1}`, value)
+
+	// and scheduling through the queue interface
+	testQueues(t, func(t *testing.T, q Queue) {
+		require.NoError(t, q.Schedule("chan1", 10, `[{"id":3}]`, HighPriority, time.Now().Add(-time.Second)))
+
+		ifValkey(q, func(rc redis.Conn) {
+			_, err := ReleaseScheduled(rc, "msgs")
+			require.NoError(t, err)
+		})
+
+		_, value := popQueue(t, q)
+		assert.Equal(t, `{"id":3}`, value)
+	})
+}
+
+func TestPauseQueue(t *testing.T) {
+	testQueues(t, func(t *testing.T, q Queue) {
+		require.NoError(t, q.Push("chan1", 0, `[{"id":1}]`, HighPriority, time.Now()))
+		require.NoError(t, q.Push("chan1", 0, `[{"id":2}]`, LowPriority, time.Now()))
+
+		// pausing bulk sends still lets high priority values through
+		require.NoError(t, q.Pause("chan1", time.Second, true))
+		ifValkey(q, func(rc redis.Conn) { assertvk.Exists(t, rc, "rate_limit_bulk:chan1") })
 
-	queue, value, err = PopFromQueue(rc, "msgs")
+		token, value := popQueue(t, q)
+		assert.Equal(t, "msgs:chan1|0", token.Key())
+		assert.Equal(t, `{"id":1}`, value)
+		require.NoError(t, q.MarkComplete(token))
+
+		assertNoValue(t, q)
+
+		// pausing the whole queue throttles it until the pause expires
+		require.NoError(t, q.Pause("chan1", 500*time.Millisecond, false))
+		require.NoError(t, q.Push("chan1", 0, `[{"id":3}]`, HighPriority, time.Now()))
+
+		assertNoValue(t, q)
+		ifValkey(q, func(rc redis.Conn) {
+			assertvk.ZGetAll(t, rc, "msgs:throttled", map[string]float64{"msgs:chan1|0": 0})
+		})
+
+		time.Sleep(600 * time.Millisecond)
+		ifValkey(q, func(rc redis.Conn) {
+			assertvk.NotExists(t, rc, "rate_limit:chan1")
+			rc.Do("ZINCRBY", "msgs:active", 0, "msgs:chan1|0")
+		})
+
+		// once the pause has expired, high priority values can be popped again
+		token, value = popQueue(t, q)
+		assert.Equal(t, "msgs:chan1|0", token.Key())
+		assert.Equal(t, `{"id":3}`, value)
+	})
+}
+
+func TestBulkPauseDoesntBlockOtherQueues(t *testing.T) {
+	testQueues(t, func(t *testing.T, q Queue) {
+		require.NoError(t, q.Push("chan1", 0, `[{"id":1}]`, LowPriority, time.Now()))
+		require.NoError(t, q.Push("chan2", 0, `[{"id":2}]`, HighPriority, time.Now()))
+
+		// chan1 only has bulk values which are paused, but would be tried first as it sorts first
+		require.NoError(t, q.Pause("chan1", time.Minute, true))
+
+		token, value := popQueue(t, q)
+		assert.Equal(t, "msgs:chan2|0", token.Key())
+		assert.Equal(t, `{"id":2}`, value)
+		require.NoError(t, q.MarkComplete(token))
+
+		ifValkey(q, func(rc redis.Conn) {
+			assertvk.ZGetAll(t, rc, "msgs:throttled", map[string]float64{"msgs:chan1|0": 0})
+		})
+
+		assertNoValue(t, q)
+
+		// once the pause is lifted, the bulk value can be popped
+		require.NoError(t, q.Resume("chan1"))
+		ifValkey(q, func(rc redis.Conn) { rc.Do("ZINCRBY", "msgs:active", 0, "msgs:chan1|0") })
+
+		token, value = popQueue(t, q)
+		assert.Equal(t, "msgs:chan1|0", token.Key())
+		assert.Equal(t, `{"id":1}`, value)
+	})
+}
+
+func TestListQueues(t *testing.T) {
+	rc := getPool().Get()
+	defer rc.Close()
+
+	infos, err := ListQueues(rc, "msgs")
 	assert.NoError(t, err)
-	assert.Equal(t, WorkerToken("msgs:chan1|10"), queue)
-	assert.Equal(t, `{"id":31}`, value)
+	assert.Len(t, infos, 0)
 
-	// make sure paused is not present for more tests
-	rc.Do("DEL", "rate_limit_bulk:chan1")
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 10, `[{"id":1}]`, HighPriority))
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 10, `[{"id":2}]`, LowPriority))
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 10, `[{"id":3}]`, LowPriority))
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan2", 0, `[{"id":4}]`, HighPriority))
+	require.NoError(t, PauseQueue(rc, "chan2", time.Minute, false))
 
-	// should get next five bulk msgs fine
-	for i := 10; i < 15; i++ {
-		queue, value, err := PopFromQueue(rc, "msgs")
-		assert.NoError(t, err)
-		assert.NotEqual(t, queue, EmptyQueue)
-		assert.Equal(t, fmt.Sprintf(`{"id":%d}`, i), value)
+	token, _, _, err := PopFromQueue(rc, "msgs")
+	require.NoError(t, err)
+	assert.Equal(t, "msgs:chan1|10", token.Key())
+
+	// chan2 gets throttled because it's paused
+	token, _, _, err = PopFromQueue(rc, "msgs")
+	require.NoError(t, err)
+	assert.Equal(t, Retry, token)
+
+	infos, err = ListQueues(rc, "msgs")
+	assert.NoError(t, err)
+	require.Len(t, infos, 2)
+
+	// current TPS is only 1 if we're still in the same second as the pop
+	assert.LessOrEqual(t, infos[0].CurrentTPS, 1)
+	infos[0].CurrentTPS = 0
+
+	assert.Equal(t, []*Info{
+		{Queue: "chan1", TPS: 10, State: StateActive, Workers: 1, Size: 0, BulkSize: 2, key: "msgs:chan1|10", keyTPS: 10},
+		{Queue: "chan2", TPS: 0, State: StateThrottled, Workers: 0, Size: 1, BulkSize: 0, Paused: true, key: "msgs:chan2|0"},
+	}, infos)
+
+	infos, err = GetQueues(rc, "msgs", "chan2")
+	assert.NoError(t, err)
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "chan2", infos[0].Queue)
+
+	// resume and purge chan2
+	require.NoError(t, ResumeQueue(rc, "chan2"))
+	assertvk.NotExists(t, rc, "rate_limit:chan2")
+
+	purged, err := PurgeQueue(rc, "msgs", "chan2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assertvk.NotExists(t, rc, "msgs:chan2|0/1")
+
+	purged, err = PurgeQueue(rc, "msgs", "chan3")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+}
+
+func TestSetTPS(t *testing.T) {
+	rc := getPool().Get()
+	defer rc.Close()
+
+	// values pushed with different TPS end up in different keys
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 10, `[{"id":1}]`, HighPriority))
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 10, `[{"id":2}]`, LowPriority))
+	time.Sleep(time.Millisecond)
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 5, `[{"id":3}]`, HighPriority))
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan2", 5, `[{"id":4}]`, HighPriority))
+
+	// migrating gives the split queue the TPS of its newest key
+	migrated, err := MigrateTPS(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"chan1"}, migrated)
+	assertvk.HGetAll(t, rc, "msgs:tps", map[string]string{"chan1": "5"})
+	assertvk.Exists(t, rc, "msgs:tps:migrated")
+
+	// and does nothing the second time, even if a queue has since been split
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan2", 3, `[{"id":5}]`, HighPriority))
+
+	migrated, err = MigrateTPS(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Len(t, migrated, 0)
+	assertvk.HGetAll(t, rc, "msgs:tps", map[string]string{"chan1": "5"})
+
+	// set a lower TPS which applies to values in both keys
+	require.NoError(t, SetTPS(rc, "msgs", "chan1", 2))
+	assertvk.HGetAll(t, rc, "msgs:tps", map[string]string{"chan1": "2"})
+
+	infos, err := GetQueues(rc, "msgs", "chan1")
+	assert.NoError(t, err)
+	assert.Len(t, infos, 2)
+	for _, info := range infos {
+		assert.Equal(t, 2, info.TPS)
+		assert.True(t, info.TPSSet)
+	}
+
+	// wait until the start of the next second so all our pops happen within it
+	time.Sleep(time.Second - time.Duration(time.Now().UnixNano()%int64(time.Second)) + 10*time.Millisecond)
 
+	popped := make([]string, 0)
+	for {
+		token, value, _, err := PopFromQueue(rc, "msgs")
+		require.NoError(t, err)
+		if token == EmptyQueue {
+			break
+		}
+		if value != "" {
+			popped = append(popped, value)
+		}
 	}
+	assert.ElementsMatch(t, []string{`{"id":1}`, `{"id":3}`, `{"id":4}`, `{"id":5}`}, popped)
 
-	// push a multi-message batch for a single contact
-	err = PushOntoQueue(rc, "msgs", "chan1", rate, `[{"id":32}, {"id":33}]`, HighPriority)
+	// chan1 was throttled by its TPS, once for each of its keys
+	throttles, err := ExtractThrottles(rc, "msgs")
 	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"chan1": 2}, throttles)
 
-	queue, value, err = PopFromQueue(rc, "msgs")
+	throttles, err = ExtractThrottles(rc, "msgs")
 	assert.NoError(t, err)
-	assert.Equal(t, WorkerToken("msgs:chan1|10"), queue)
-	assert.Equal(t, `{"id":32}`, value)
+	assert.Equal(t, map[string]int{}, throttles)
 
-	assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{"msgs:chan1|10": 17})
-	assertvk.ZGetAll(t, rc, "msgs:throttled", map[string]float64{})
-	assertvk.ZGetAll(t, rc, "msgs:future", map[string]float64{"msgs:chan1|10": 0})
+	// removing the TPS reverts to the TPS values were pushed with
+	require.NoError(t, SetTPS(rc, "msgs", "chan1", -1))
+	assertvk.HGetAll(t, rc, "msgs:tps", map[string]string{})
 
-	// sleep a few seconds
-	time.Sleep(2 * time.Second)
+	// and through the queue interface, including purging
+	testQueues(t, func(t *testing.T, q Queue) {
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, q.Push("chan1", 10, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority, time.Now()))
+		}
+		require.NoError(t, q.SetTPS("chan1", 1))
+
+		// wait until the start of the next second so all our pops happen within it
+		time.Sleep(time.Second - time.Duration(time.Now().UnixNano()%int64(time.Second)) + 10*time.Millisecond)
+
+		_, value := popQueue(t, q)
+		assert.Equal(t, `{"id":1}`, value)
+		assertNoValue(t, q)
 
-	// pop remaining bulk off
-	for i := 15; i < 20; i++ {
-		queue, value, err := PopFromQueue(rc, "msgs")
+		throttles, err := q.ExtractThrottles()
 		assert.NoError(t, err)
-		assert.NotEqual(t, queue, EmptyQueue)
-		assert.Equal(t, fmt.Sprintf(`{"id":%d}`, i), value)
+		assert.Equal(t, map[string]int{"chan1": 1}, throttles)
+
+		infos, err := q.Queues()
+		assert.NoError(t, err)
+		if assert.Len(t, infos, 1) {
+			assert.Equal(t, "chan1", infos[0].Queue)
+			assert.Equal(t, 1, infos[0].TPS)
+			assert.True(t, infos[0].TPSSet)
+			assert.Equal(t, StateThrottled, infos[0].State)
+			assert.Equal(t, 1, infos[0].Workers)
+			assert.Equal(t, 2, infos[0].Size)
+			assert.Equal(t, 1, infos[0].CurrentTPS)
+		}
+
+		purged, err := q.Purge("chan1")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, purged)
+
+		require.NoError(t, q.SetTPS("chan1", -1))
+
+		infos, err = q.Queues()
+		assert.NoError(t, err)
+		if assert.Len(t, infos, 1) {
+			assert.Equal(t, 10, infos[0].TPS)
+			assert.False(t, infos[0].TPSSet)
+			assert.Equal(t, 0, infos[0].Size)
+		}
+	})
+}
+
+func TestParseLimits(t *testing.T) {
+	tcs := []struct {
+		input  string
+		limits []Limit
+		err    string
+	}{
+		{"", []Limit{}, ""},
+		{"30/s", []Limit{{Count: 30, Window: time.Second}}, ""},
+		{"30/s+10, 1000/m,50000/d", []Limit{{Count: 30, Window: time.Second, Burst: 10}, {Count: 1000, Window: time.Minute}, {Count: 50000, Window: 24 * time.Hour}}, ""},
+		{"5/h+2", []Limit{{Count: 5, Window: time.Hour, Burst: 2}}, ""},
+		{"0/s", nil, "invalid rate limit '0/s'"},
+		{"5/s+6", nil, "invalid rate limit '5/s+6'"},
+		{"10/w", nil, "invalid rate limit '10/w'"},
+		{"10/s,abc", nil, "invalid rate limit 'abc'"},
 	}
 
-	// next should be 30
-	queue, value, err = PopFromQueue(rc, "msgs")
-	assert.NoError(t, err)
-	assert.NotEqual(t, queue, EmptyQueue)
-	assert.Equal(t, `{"id":30}`, value)
+	for _, tc := range tcs {
+		limits, err := ParseLimits(tc.input)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, "error mismatch for input '%s'", tc.input)
+		} else {
+			assert.NoError(t, err, "unexpected error for input '%s'", tc.input)
+			assert.Equal(t, tc.limits, limits, "limits mismatch for input '%s'", tc.input)
+		}
+	}
+
+	assert.Equal(t, "30/s+10", Limit{Count: 30, Window: time.Second, Burst: 10}.String())
+	assert.Equal(t, "1000/m", Limit{Count: 1000, Window: time.Minute}.String())
+}
 
-	// popping again should give us nothing since it is too soon to send 33
-	queue = Retry
-	for queue == Retry {
-		queue, value, err = PopFromQueue(rc, "msgs")
+func TestLimits(t *testing.T) {
+	rc := getPool().Get()
+	defer rc.Close()
+
+	popAll := func() []string {
+		popped := make([]string, 0)
+		for {
+			token, value, _, err := PopFromQueue(rc, "msgs")
+			require.NoError(t, err)
+			if token == EmptyQueue {
+				return popped
+			}
+			if value != "" {
+				popped = append(popped, value)
+			}
+		}
 	}
+
+	// no limits to begin with
+	limits, err := GetLimits(rc, "msgs", "chan1")
 	assert.NoError(t, err)
-	assert.Equal(t, EmptyQueue, queue)
-	assert.Empty(t, value)
+	assert.Nil(t, limits)
+
+	// invalid limits can't be set
+	assert.EqualError(t, SetLimits(rc, "msgs", "chan1", []Limit{{Count: 5, Window: time.Millisecond}}), "invalid rate limit '5/0s'")
+	assert.EqualError(t, SetLimits(rc, "msgs", "chan1", []Limit{{Count: 5, Window: time.Minute, Burst: 6}}), "invalid rate limit '5/m+6'")
 
-	// but if we sleep 6 seconds should get it
-	time.Sleep(time.Second * 6)
+	// limit chan1 to 3 per minute, but with a burst of 2, which its TPS of 10 would otherwise allow
+	require.NoError(t, SetLimits(rc, "msgs", "chan1", []Limit{{Count: 3, Window: time.Minute, Burst: 2}}))
+	assertvk.HGetAll(t, rc, "msgs:limits", map[string]string{"chan1": `[{"count":3,"window":60,"burst":2}]`})
 
-	queue, value, err = PopFromQueue(rc, "msgs")
+	limits, err = GetLimits(rc, "msgs", "chan1")
 	assert.NoError(t, err)
-	assert.Equal(t, WorkerToken("msgs:chan1|10"), queue)
-	assert.Equal(t, `{"id":33}`, value)
+	assert.Equal(t, []Limit{{Count: 3, Window: time.Minute, Burst: 2}}, limits)
 
-	// nothing should be left
-	queue = Retry
-	for queue == Retry {
-		queue, value, err = PopFromQueue(rc, "msgs")
+	for i := 1; i <= 6; i++ {
+		require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 10, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority))
 	}
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan2", 10, `[{"id":7}]`, HighPriority))
+
+	// chan1 gets its burst, and then has to wait until it's back on pace, one pop every 20 seconds
+	assert.ElementsMatch(t, []string{`{"id":1}`, `{"id":2}`, `{"id":7}`}, popAll())
+
+	throttled, err := redis.Strings(rc.Do("ZRANGE", "msgs:throttled", 0, -1))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"msgs:chan1|10"}, throttled)
+
+	pttl, err := redis.Int(rc.Do("PTTL", "msgs:chan1:limited"))
+	assert.NoError(t, err)
+	assert.Greater(t, pttl, 19000)
+	assert.LessOrEqual(t, pttl, 20000)
+
+	infos, err := GetQueues(rc, "msgs", "chan1")
 	assert.NoError(t, err)
-	assert.Equal(t, EmptyQueue, queue)
-	assert.Empty(t, value)
+	assert.Len(t, infos, 1)
+	assert.True(t, infos[0].Limited)
 
-	err = PushOntoQueue(rc, "msgs", "chan1", rate, `[{"id":34}]`, HighPriority)
+	// even after dethrottling it can't be popped from
+	_, err = scriptDethrottle.Do(rc, "msgs")
+	require.NoError(t, err)
+	token, value, _, err := PopFromQueue(rc, "msgs")
 	assert.NoError(t, err)
+	assert.Equal(t, Retry, token)
+	assert.Equal(t, "", value)
 
-	rc.Do("SET", "rate_limit:chan1", "engaged")
-	rc.Do("EXPIRE", "rate_limit:chan1", 5)
+	// without the burst, the rest of the count can be popped at once, but no more than the count in the window
+	require.NoError(t, SetLimits(rc, "msgs", "chan1", []Limit{{Count: 3, Window: time.Minute}}))
+	_, err = rc.Do("DEL", "msgs:chan1:limited")
+	require.NoError(t, err)
+	_, err = scriptDethrottle.Do(rc, "msgs")
+	require.NoError(t, err)
 
-	// we have the rate limit set
-	queue, value, err = PopFromQueue(rc, "msgs")
+	tokens := make([]WorkerToken, 0)
+	for {
+		token, value, _, err := PopFromQueue(rc, "msgs")
+		require.NoError(t, err)
+		if token == EmptyQueue {
+			break
+		}
+		if value != "" {
+			assert.Equal(t, `{"id":3}`, value)
+			tokens = append(tokens, token)
+		}
+	}
+	require.Len(t, tokens, 1)
+	assertvk.ZCard(t, rc, "msgs:chan1:limit:60", 3)
+
+	// and the window only frees up once the oldest pop leaves it
+	pttl, err = redis.Int(rc.Do("PTTL", "msgs:chan1:limited"))
 	assert.NoError(t, err)
-	if value != "" && queue != EmptyQueue {
-		t.Fatal("Should be throttled")
+	assert.Greater(t, pttl, 59000)
+	assert.LessOrEqual(t, pttl, 60000)
+
+	// refunding a pop takes it back out of the window so another value can be popped instead
+	require.NoError(t, Refund(rc, "msgs", tokens[0]))
+	require.NoError(t, Refund(rc, "msgs", tokens[0]))
+	require.NoError(t, MarkComplete(rc, "msgs", tokens[0]))
+	assertvk.ZCard(t, rc, "msgs:chan1:limit:60", 2)
+	assertvk.NotExists(t, rc, "msgs:chan1:limited")
+
+	_, err = scriptDethrottle.Do(rc, "msgs")
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"id":4}`}, popAll())
+
+	// removing limits clears them
+	require.NoError(t, SetLimits(rc, "msgs", "chan1", nil))
+	assertvk.HGetAll(t, rc, "msgs:limits", map[string]string{})
+
+	// and through the queue interface
+	testQueues(t, func(t *testing.T, q Queue) {
+		assert.EqualError(t, q.SetLimits("chan1", []Limit{{Count: 5, Window: time.Minute, Burst: 6}}), "invalid rate limit '5/m+6'")
+		require.NoError(t, q.SetLimits("chan1", []Limit{{Count: 2, Window: time.Minute}}))
+
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, q.Push("chan1", 10, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority, time.Now()))
+		}
+
+		token1, value := popQueue(t, q)
+		assert.Equal(t, `{"id":1}`, value)
+		token2, value := popQueue(t, q)
+		assert.Equal(t, `{"id":2}`, value)
+		assertNoValue(t, q)
+
+		infos, err := q.Queues()
+		assert.NoError(t, err)
+		if assert.Len(t, infos, 1) {
+			assert.True(t, infos[0].Limited)
+			assert.Equal(t, StateThrottled, infos[0].State)
+		}
+
+		throttles, err := q.ExtractThrottles()
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{"chan1": 1}, throttles)
+
+		// refunding a pop frees up the window
+		require.NoError(t, q.Refund(token1))
+		require.NoError(t, q.MarkComplete(token1))
+		require.NoError(t, q.MarkComplete(token2))
+
+		ifValkey(q, func(rc redis.Conn) {
+			_, err := scriptDethrottle.Do(rc, "msgs")
+			require.NoError(t, err)
+		})
+
+		_, value = popQueue(t, q)
+		assert.Equal(t, `{"id":3}`, value)
+	})
+}
+
+func TestRefund(t *testing.T) {
+	testQueues(t, func(t *testing.T, q Queue) {
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, q.Push("chan1", 2, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority, time.Now()))
+		}
+
+		// wait until the start of the next second so all our pops happen within it
+		time.Sleep(time.Second - time.Duration(time.Now().UnixNano()%int64(time.Second)) + 10*time.Millisecond)
+
+		token1, value := popQueue(t, q)
+		assert.Equal(t, `{"id":1}`, value)
+		token2, value := popQueue(t, q)
+		assert.Equal(t, `{"id":2}`, value)
+
+		// at our TPS
+		assertNoValue(t, q)
+
+		// but if the first value is put back rather than processed, its pop doesn't count
+		require.NoError(t, q.Refund(token1))
+		require.NoError(t, q.MarkComplete(token1))
+		require.NoError(t, q.MarkComplete(token2))
+
+		ifValkey(q, func(rc redis.Conn) {
+			_, err := scriptDethrottle.Do(rc, "msgs")
+			require.NoError(t, err)
+		})
+
+		_, value = popQueue(t, q)
+		assert.Equal(t, `{"id":3}`, value)
+	})
+}
+
+func TestURNLocks(t *testing.T) {
+	rc := getPool().Get()
+	defer rc.Close()
+
+	pop := func() (WorkerToken, string) {
+		for {
+			token, value, _, err := PopFromQueue(rc, "msgs")
+			require.NoError(t, err)
+			if token != Retry {
+				return token, value
+			}
+		}
 	}
 
-	time.Sleep(2 * time.Second)
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 10, `[{"id":1,"urn":"tel:1"}]`, HighPriority))
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 10, `[{"id":2,"urn":"tel:1"}]`, HighPriority))
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 10, `[{"id":3,"urn":"tel:2"}]`, HighPriority))
+
+	// first value for tel:1 gets the lock
+	token, value := pop()
+	assert.Equal(t, `{"id":1,"urn":"tel:1"}`, value)
+	lock1, err := LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:1", "1", value, time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", lock1)
+	require.NoError(t, MarkComplete(rc, "msgs", token))
+
+	// second has to wait in line
+	token, value = pop()
+	assert.Equal(t, `{"id":2,"urn":"tel:1"}`, value)
+	pushedOn2 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	lock2, err := LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:1", "2", value, pushedOn2, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "", lock2)
+	require.NoError(t, MarkComplete(rc, "msgs", token))
+
+	assertvk.LLen(t, rc, "msgs:chan1:urn:tel:1:waiting", 1)
+	assertvk.ZCard(t, rc, "msgs:urns", 1)
+
+	// but values for other URNs aren't blocked
+	token, value = pop()
+	assert.Equal(t, `{"id":3,"urn":"tel:2"}`, value)
+	lock3, err := LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:2", "3", value, time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", lock3)
+	require.NoError(t, MarkComplete(rc, "msgs", token))
+
+	token, _ = pop()
+	assert.Equal(t, EmptyQueue, token)
+
+	// can't unlock without the right token
+	unlocked, err := UnlockURN(rc, "msgs", "chan1", "tel:1", "xyz", 0)
+	assert.NoError(t, err)
+	assert.False(t, unlocked)
+
+	// unlocking hands the lock off to the next value in line and puts it back on the queue
+	unlocked, err = UnlockURN(rc, "msgs", "chan1", "tel:1", lock1, 0)
+	assert.NoError(t, err)
+	assert.True(t, unlocked)
+	assertvk.Get(t, rc, "msgs:chan1:urn:tel:1", "handoff:2")
+	assertvk.LLen(t, rc, "msgs:chan1:urn:tel:1:waiting", 0)
+	assertvk.ZCard(t, rc, "msgs:urns", 0)
+
+	// keeping the time it was originally pushed
+	assertvk.ZRange(t, rc, "msgs:chan1|10/1", 0, -1, []string{`1735787045.000000|[{"id":2,"urn":"tel:1"}]`})
+
+	// a new value for tel:1 can't jump the line
+	lock4, err := LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:1", "4", `{"id":4,"urn":"tel:1"}`, time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "", lock4)
 
-	queue, value, err = PopFromQueue(rc, "msgs")
+	// but the value that was handed the lock can take it
+	token, value = pop()
+	assert.Equal(t, `{"id":2,"urn":"tel:1"}`, value)
+	lock2, err = LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:1", "2", value, time.Now(), time.Minute)
 	assert.NoError(t, err)
+	assert.NotEqual(t, "", lock2)
+	require.NoError(t, MarkComplete(rc, "msgs", token))
+
+	// unlocking with a gap means the next value can't be popped until it has passed
+	unlocked, err = UnlockURN(rc, "msgs", "chan1", "tel:1", lock2, 2*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, unlocked)
+	assertvk.Get(t, rc, "msgs:chan1:urn:tel:1", "handoff:4")
+
+	_, value = pop()
 	assert.Equal(t, "", value)
-	assert.Equal(t, Retry, queue)
 
-	assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{})
-	assertvk.ZGetAll(t, rc, "msgs:throttled", map[string]float64{"msgs:chan1|10": 0})
-	assertvk.ZGetAll(t, rc, "msgs:future", map[string]float64{})
+	// a lock which expires without being unlocked is released to the next value in line by the releaser
+	lock5, err := LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:2", "5", `{"id":5,"urn":"tel:2"}`, time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "", lock5)
+
+	released, err := ReleaseURNs(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, released)
 
-	// but if we wait for the rate limit to expire
-	time.Sleep(3 * time.Second)
+	_, err = rc.Do("DEL", "msgs:chan1:urn:tel:2") // as if it expired
+	require.NoError(t, err)
+	_, err = rc.Do("ZADD", "msgs:urns", 0, `["chan1","tel:2"]`)
+	require.NoError(t, err)
 
-	// next should be 34
-	queue, value, err = PopFromQueue(rc, "msgs")
+	released, err = ReleaseURNs(rc, "msgs")
 	assert.NoError(t, err)
-	assert.NotEqual(t, queue, EmptyQueue)
-	assert.Equal(t, `{"id":34}`, value)
+	assert.Equal(t, 1, released)
+	assertvk.Get(t, rc, "msgs:chan1:urn:tel:2", "handoff:5")
 
-	// nothing should be left
-	queue = Retry
-	for queue == Retry {
-		queue, value, err = PopFromQueue(rc, "msgs")
-	}
+	token, value = pop()
+	assert.Equal(t, `{"id":5,"urn":"tel:2"}`, value)
+	lock5, err = LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:2", "5", value, time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", lock5)
+	require.NoError(t, MarkComplete(rc, "msgs", token))
+
+	// and once the last value is unlocked without a gap, the lock is removed
+	unlocked, err = UnlockURN(rc, "msgs", "chan1", "tel:2", lock5, 0)
 	assert.NoError(t, err)
-	assert.Equal(t, EmptyQueue, queue)
-	assert.Empty(t, value)
+	assert.True(t, unlocked)
+	assertvk.NotExists(t, rc, "msgs:chan1:urn:tel:2")
+
+	// a value which is put back on the queue can hold onto its lock so that values waiting for it stay behind it
+	lock6, err := LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:3", "6", `{"id":6,"urn":"tel:3"}`, time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", lock6)
+	lock7, err := LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:3", "7", `{"id":7,"urn":"tel:3"}`, time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "", lock7)
+
+	held, err := HoldURN(rc, "msgs", "chan1", "tel:3", "xyz", "6", time.Second)
+	assert.NoError(t, err)
+	assert.False(t, held)
+
+	held, err = HoldURN(rc, "msgs", "chan1", "tel:3", lock6, "6", time.Second)
+	assert.NoError(t, err)
+	assert.True(t, held)
+	assertvk.Get(t, rc, "msgs:chan1:urn:tel:3", "handoff:6")
+	assertvk.LLen(t, rc, "msgs:chan1:urn:tel:3:waiting", 1)
+
+	lock7, err = LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:3", "7", `{"id":7,"urn":"tel:3"}`, time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "", lock7)
+
+	lock6, err = LockURN(rc, "msgs", "chan1", 10, HighPriority, "tel:3", "6", `{"id":6,"urn":"tel:3"}`, time.Now(), time.Minute)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", lock6)
+
+	// and through the queue interface
+	testQueues(t, func(t *testing.T, q Queue) {
+		require.NoError(t, q.Push("chan1", 10, `[{"id":1,"urn":"tel:1"}]`, HighPriority, time.Now()))
+
+		token, value := popQueue(t, q)
+		lock1, err := q.LockURN("chan1", 10, HighPriority, "tel:1", "1", value, time.Now(), time.Minute)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "", lock1)
+		require.NoError(t, q.MarkComplete(token))
+
+		pushedOn2 := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+		lock2, err := q.LockURN("chan1", 10, HighPriority, "tel:1", "2", `{"id":2,"urn":"tel:1"}`, pushedOn2, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, "", lock2)
+		assertNoValue(t, q)
+
+		unlocked, err := q.UnlockURN("chan1", "tel:1", "xyz", 0)
+		assert.NoError(t, err)
+		assert.False(t, unlocked)
+
+		// unlocking hands the lock off to the waiting value which keeps the time it was pushed
+		unlocked, err = q.UnlockURN("chan1", "tel:1", lock1, 0)
+		assert.NoError(t, err)
+		assert.True(t, unlocked)
+
+		var pushedOn time.Time
+		for range 10 {
+			if token, value, pushedOn, err = q.Pop(); token != Retry {
+				break
+			}
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, `{"id":2,"urn":"tel:1"}`, value)
+		assert.True(t, pushedOn2.Equal(pushedOn))
+
+		lock2, err = q.LockURN("chan1", 10, HighPriority, "tel:1", "2", value, pushedOn, time.Minute)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "", lock2)
+
+		unlocked, err = q.UnlockURN("chan1", "tel:1", lock2, 0)
+		assert.NoError(t, err)
+		assert.True(t, unlocked)
+
+		// and once it's unlocked with no one waiting, the next value can take it straight away
+		lock3, err := q.LockURN("chan1", 10, HighPriority, "tel:1", "3", `{"id":3,"urn":"tel:1"}`, time.Now(), time.Minute)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "", lock3)
+
+		// holding it for a value put back on the queue means values waiting for it stay behind that value
+		lock4, err := q.LockURN("chan1", 10, HighPriority, "tel:1", "4", `{"id":4,"urn":"tel:1"}`, time.Now(), time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, "", lock4)
+
+		held, err := q.HoldURN("chan1", "tel:1", "xyz", "3", 0)
+		assert.NoError(t, err)
+		assert.False(t, held)
+
+		held, err = q.HoldURN("chan1", "tel:1", lock3, "3", 0)
+		assert.NoError(t, err)
+		assert.True(t, held)
+		assertNoValue(t, q)
+
+		lock3, err = q.LockURN("chan1", 10, HighPriority, "tel:1", "3", `{"id":3,"urn":"tel:1"}`, time.Now(), time.Minute)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "", lock3)
+	})
 }
 
-func TestThrottle(t *testing.T) {
-	assert := assert.New(t)
-	pool := getPool()
-	conn := pool.Get()
-	defer conn.Close()
+func TestLeases(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
 
-	// start our dethrottler
-	quitter := make(chan bool)
-	wg := &sync.WaitGroup{}
-	StartDethrottler(pool, quitter, wg, "msgs")
+	q := NewValkeyQueue(rp, "msgs")
 
-	insertCount := 30
-	rate := 10
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan1", 10, `[{"id":1},{"id":2}]`, HighPriority))
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan2", 10, `[{"id":3}]`, LowPriority))
 
-	// insert items with our set limit
-	for i := 0; i < insertCount; i++ {
-		err := PushOntoQueue(conn, "msgs", "chan1", rate, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority)
-		assert.NoError(err)
-		time.Sleep(1 * time.Microsecond)
+	token1, value := popQueue(t, q)
+	assert.Equal(t, "msgs:chan1|10", token1.Key())
+	assert.Equal(t, `{"id":1}`, value)
+
+	token2, value := popQueue(t, q)
+	assert.Equal(t, "msgs:chan2|10", token2.Key())
+	assert.Equal(t, `{"id":3}`, value)
+	assert.NotEqual(t, token1, token2)
+
+	assertvk.ZCard(t, rc, "msgs:leases", 2)
+	assertvk.HLen(t, rc, "msgs:leased", 2)
+	assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{"msgs:chan1|10": 1, "msgs:chan2|10": 1})
+
+	// nothing to reap yet
+	reaped, err := ReapLeases(rc, "msgs", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reaped)
+
+	// expire both leases
+	rc.Do("ZADD", "msgs:leases", 0, string(token1))
+	rc.Do("ZADD", "msgs:leases", 0, string(token2))
+
+	reaped, err = ReapLeases(rc, "msgs", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, reaped)
+
+	assertvk.ZCard(t, rc, "msgs:leases", 0)
+	assertvk.HLen(t, rc, "msgs:leased", 0)
+	assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{"msgs:chan1|10": 0, "msgs:chan2|10": 0})
+
+	// and if the original workers do eventually complete, they don't release their workers twice
+	assert.NoError(t, MarkComplete(rc, "msgs", token1))
+	assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{"msgs:chan1|10": 0, "msgs:chan2|10": 0})
+
+	// remainder of the first batch is still there, but nothing was requeued
+	assertvk.ZCard(t, rc, "msgs:chan1|10/1", 1)
+	assertvk.ZCard(t, rc, "msgs:chan2|10/0", 0)
+
+	// pop again and this time reap with requeuing
+	rc.Do("ZADD", "msgs:chan2|10/0", 0, `[{"id":4}]`)
+	rc.Do("ZINCRBY", "msgs:active", 0, "msgs:chan2|10")
+
+	token3, value := popQueue(t, q)
+	assert.Equal(t, "msgs:chan2|10", token3.Key())
+	assert.Equal(t, `{"id":4}`, value)
+
+	rc.Do("ZADD", "msgs:leases", 0, string(token3))
+
+	reaped, err = ReapLeases(rc, "msgs", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reaped)
+
+	// chan1 only has the remainder of its batch which isn't due yet, so it was moved to future by the last pop
+	assertvk.ZGetAll(t, rc, "msgs:active", map[string]float64{"msgs:chan2|10": 0})
+	assertvk.ZGetAll(t, rc, "msgs:future", map[string]float64{"msgs:chan1|10": 0})
+	// requeued value keeps the time it was originally pushed
+	assertvk.ZRange(t, rc, "msgs:chan2|10/0", 0, -1, []string{`0|[{"id":4}]`})
+
+	// completing a token which was popped and completed normally removes its lease
+	require.NoError(t, PushOntoQueue(rc, "msgs", "chan3", 10, `[{"id":5}]`, HighPriority))
+
+	token4, _ := popQueue(t, q)
+	assert.NoError(t, MarkComplete(rc, "msgs", token4))
+
+	assertvk.ZCard(t, rc, "msgs:leases", 0)
+	assertvk.HLen(t, rc, "msgs:leased", 0)
+}
+
+func TestDeadLetters(t *testing.T) {
+	rc := getPool().Get()
+	defer rc.Close()
+
+	dls, err := GetDeadLetters(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Len(t, dls, 0)
+
+	id1, err := AddDeadLetter(rc, "msgs", "chan1", 10, HighPriority, `{"id":1}`, errors.New("boom"))
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond) // so IDs are ordered
+	id2, err := AddDeadLetter(rc, "msgs", "chan2", 5, LowPriority, `{"id":2}`, errors.New("bang"))
+	assert.NoError(t, err)
+
+	dls, err = GetDeadLetters(rc, "msgs")
+	assert.NoError(t, err)
+	if assert.Len(t, dls, 2) {
+		assert.Equal(t, id1, dls[0].ID)
+		assert.Equal(t, "chan1", dls[0].Queue)
+		assert.Equal(t, 10, dls[0].TPS)
+		assert.Equal(t, Priority(HighPriority), dls[0].Priority)
+		assert.Equal(t, `{"id":1}`, dls[0].Value)
+		assert.Equal(t, "boom", dls[0].Error)
+		assert.WithinDuration(t, time.Now(), dls[0].FailedOn, time.Second)
+		assert.Equal(t, id2, dls[1].ID)
+		assert.Equal(t, "bang", dls[1].Error)
 	}
 
-	// start timing
-	start := time.Now()
-	curr := 0
-	var task WorkerToken
-	var err error
-	var value string
-	for curr < insertCount {
-		task, value, err = PopFromQueue(conn, "msgs")
-		assert.NoError(err)
+	// replaying pushes it back onto its queue
+	replayed, err := ReplayDeadLetter(rc, "msgs", id1)
+	assert.NoError(t, err)
+	assert.True(t, replayed)
 
-		// if this wasn't throttled
-		if value != "" {
-			expected := fmt.Sprintf(`{"id":%d}`, curr)
-			assert.Equal(expected, value, "Out of order msg")
-			curr++
+	replayed, err = ReplayDeadLetter(rc, "msgs", id1)
+	assert.NoError(t, err)
+	assert.False(t, replayed)
 
-			err = MarkComplete(conn, "msgs", task)
-			assert.NoError(err)
-		} else {
-			// otherwise sleep a bit
-			time.Sleep(100 * time.Millisecond)
-		}
+	token, value, _, err := PopFromQueue(rc, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, "msgs:chan1|10", token.Key())
+	assert.Equal(t, `{"id":1}`, value)
+
+	// discarding just removes it
+	discarded, err := DiscardDeadLetter(rc, "msgs", id2)
+	assert.NoError(t, err)
+	assert.True(t, discarded)
+
+	discarded, err = DiscardDeadLetter(rc, "msgs", id2)
+	assert.NoError(t, err)
+	assert.False(t, discarded)
+
+	assertvk.HLen(t, rc, "msgs:dead", 0)
+	assertvk.ZCard(t, rc, "msgs:dead:ids", 0)
+
+	// only so many dead letters are kept, with the oldest removed first
+	defer func(m int) { maxDeadLetters = m }(maxDeadLetters)
+	maxDeadLetters = 3
+
+	ids := make([]string, 5)
+	for i := range 5 {
+		ids[i], err = AddDeadLetter(rc, "msgs", "chan1", 10, HighPriority, fmt.Sprintf(`{"id":%d}`, i+3), errors.New("boom"))
+		require.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
 	}
 
-	// if we haven't seen all messages, fail
-	assert.Equal(insertCount, curr, "Did not read all messages")
+	assertvk.HLen(t, rc, "msgs:dead", 3)
+	assertvk.ZCard(t, rc, "msgs:dead:ids", 3)
 
-	// if this took less than 1 second or more than 3 seconds, fail, should have throttled
-	expected := time.Duration((insertCount / rate) - 2)
-	elapsed := time.Since(start)
-	if elapsed < expected*time.Second || elapsed > (expected+2)*time.Second {
-		t.Errorf("Did not throttle properly, took: %f", elapsed.Seconds())
+	dls, err = GetDeadLetters(rc, "msgs")
+	assert.NoError(t, err)
+	if assert.Len(t, dls, 3) {
+		assert.Equal(t, ids[2:], []string{dls[0].ID, dls[1].ID, dls[2].ID})
 	}
 
-	// close our dethrottler
-	close(quitter)
-	wg.Wait()
+	// and through the queue interface
+	testQueues(t, func(t *testing.T, q Queue) {
+		id1, err := q.AddDeadLetter("chan1", 10, HighPriority, `{"id":1}`, errors.New("boom"))
+		assert.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+		id2, err := q.AddDeadLetter("chan2", 5, LowPriority, `{"id":2}`, errors.New("bang"))
+		assert.NoError(t, err)
+
+		dls, err := q.DeadLetters()
+		assert.NoError(t, err)
+		if assert.Len(t, dls, 2) {
+			assert.Equal(t, id1, dls[0].ID)
+			assert.Equal(t, "boom", dls[0].Error)
+			assert.Equal(t, id2, dls[1].ID)
+		}
+
+		replayed, err := q.ReplayDeadLetter(id1)
+		assert.NoError(t, err)
+		assert.True(t, replayed)
+
+		token, value := popQueue(t, q)
+		assert.Equal(t, "msgs:chan1|10", token.Key())
+		assert.Equal(t, `{"id":1}`, value)
+
+		discarded, err := q.DiscardDeadLetter(id2)
+		assert.NoError(t, err)
+		assert.True(t, discarded)
+
+		discarded, err = q.DiscardDeadLetter(id2)
+		assert.NoError(t, err)
+		assert.False(t, discarded)
+
+		dls, err = q.DeadLetters()
+		assert.NoError(t, err)
+		assert.Len(t, dls, 0)
+	})
+}
+
+func TestThrottle(t *testing.T) {
+	testQueues(t, func(t *testing.T, q Queue) {
+		assert := assert.New(t)
+
+		// start our dethrottler
+		defer startDethrottler(q)()
+
+		insertCount := 30
+		rate := 10
+
+		// insert items with our set limit
+		for i := 0; i < insertCount; i++ {
+			err := q.Push("chan1", rate, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority, time.Now())
+			assert.NoError(err)
+			time.Sleep(1 * time.Microsecond)
+		}
+
+		// start timing
+		start := time.Now()
+		curr := 0
+		for curr < insertCount {
+			task, value, _, err := q.Pop()
+			assert.NoError(err)
+
+			// if this wasn't throttled
+			if value != "" {
+				expected := fmt.Sprintf(`{"id":%d}`, curr)
+				assert.Equal(expected, value, "Out of order msg")
+				curr++
+
+				err = q.MarkComplete(task)
+				assert.NoError(err)
+			} else {
+				// otherwise sleep a bit
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+
+		// if we haven't seen all messages, fail
+		assert.Equal(insertCount, curr, "Did not read all messages")
+
+		// if this took less than 1 second or more than 3 seconds, fail, should have throttled
+		expected := time.Duration((insertCount / rate) - 2)
+		elapsed := time.Since(start)
+		if elapsed < expected*time.Second || elapsed > (expected+2)*time.Second {
+			t.Errorf("Did not throttle properly, took: %f", elapsed.Seconds())
+		}
+	})
 }
 
 func BenchmarkQueue(b *testing.B) {
@@ -299,9 +1433,9 @@ func BenchmarkQueue(b *testing.B) {
 		err := PushOntoQueue(conn, "msgs", "chan1", 0, "["+insertValue+"]", HighPriority)
 		assert.NoError(err)
 
-		queue, value, err := PopFromQueue(conn, "msgs")
+		queue, value, _, err := PopFromQueue(conn, "msgs")
 		assert.NoError(err)
-		assert.Equal(WorkerToken("msgs:chan1|0"), queue, "Mismatched queue")
+		assert.Equal("msgs:chan1|0", queue.Key(), "Mismatched queue")
 		assert.Equal(insertValue, value, "Mismatched value")
 
 		err = MarkComplete(conn, "msgs", queue)
@@ -0,0 +1,71 @@
+package queue
+
+import (
+	_ "embed"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/uuids"
+)
+
+// how long a URN lock handed off to a waiting value is held for that value to be popped before it is released to the
+// next value in line
+const urnHandoffLease = 5 * time.Minute
+
+//go:embed lua/lock_urn.lua
+var luaLockURN string
+var scriptLockURN = redis.NewScript(10, luaLockURN)
+
+//go:embed lua/unlock_urn.lua
+var luaUnlockURN string
+var scriptUnlockURN = redis.NewScript(7, luaUnlockURN)
+
+//go:embed lua/hold_urn.lua
+var luaHoldURN string
+var scriptHoldURN = redis.NewScript(7, luaHoldURN)
+
+//go:embed lua/release_urns.lua
+var luaReleaseURNs string
+var scriptReleaseURNs = redis.NewScript(3, luaReleaseURNs)
+
+// LockURN tries to lock sending to the given URN on the passed in queue for the popped value with the given id, so
+// that only one value for each URN is processed at a time. If the lock is taken, a token is returned which must be
+// passed to UnlockURN once the value has been processed. If not, the value is moved to a line of values waiting for
+// the URN and an empty token is returned. The caller should mark the value complete without processing it, and it will
+// be pushed back onto the queue when it is its turn, keeping the time it was originally pushed.
+func LockURN(conn redis.Conn, qType string, queue string, tps int, priority Priority, urn string, id string, value string, pushedOn time.Time, lease time.Duration) (string, error) {
+	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	pushedMS := strconv.FormatFloat(float64(pushedOn.UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	token := string(uuids.NewV4())
+
+	locked, err := redis.Bool(scriptLockURN.Do(conn, epochMS, qType, queue, urn, id, token, lease.Milliseconds(), tps, priority, pushedMS+"|["+value+"]"))
+	if err != nil || !locked {
+		return "", err
+	}
+	return token, nil
+}
+
+// UnlockURN unlocks a URN locked with LockURN. If there are values waiting for the URN, the lock is handed off to the
+// next one and it is pushed back onto the queue. If gap is non-zero, the next value for the URN won't be popped until
+// it has passed.
+func UnlockURN(conn redis.Conn, qType string, queue string, urn string, token string, gap time.Duration) (bool, error) {
+	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	return redis.Bool(scriptUnlockURN.Do(conn, epochMS, qType, queue, urn, token, gap.Milliseconds(), urnHandoffLease.Milliseconds()))
+}
+
+// HoldURN hands a URN locked with LockURN back to the value with the given id which held it, because it's been put back
+// on the queue to be processed again after the given delay, e.g. to be retried. Values waiting for the URN stay behind
+// it, and it takes the lock back when it's popped again, unless that doesn't happen within the delay plus a lease, in
+// which case the lock is released to the next value in line.
+func HoldURN(conn redis.Conn, qType string, queue string, urn string, token string, id string, delay time.Duration) (bool, error) {
+	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	return redis.Bool(scriptHoldURN.Do(conn, epochMS, qType, queue, urn, token, id, (delay + urnHandoffLease).Milliseconds()))
+}
+
+// ReleaseURNs finds URN locks which expired without being unlocked, e.g. because a worker died, and hands them off to
+// the next values waiting for them, returning how many were released
+func ReleaseURNs(conn redis.Conn, qType string) (int, error) {
+	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	return redis.Int(scriptReleaseURNs.Do(conn, epochMS, qType, urnHandoffLease.Milliseconds()))
+}
@@ -0,0 +1,167 @@
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// valkeyQueue is the implementation of a queue type which stores its values in Valkey, and is the one to use when there
+// are multiple instances popping from the same queues
+type valkeyQueue struct {
+	rp    *redis.Pool
+	qType string
+}
+
+// NewValkeyQueue creates a new queue of the given type stored in Valkey. Callers should also start the queue so that
+// throttled queues and queues with future values are reactivated.
+func NewValkeyQueue(rp *redis.Pool, qType string) Queue {
+	return &valkeyQueue{rp: rp, qType: qType}
+}
+
+func (q *valkeyQueue) Start(quit chan bool, wg *sync.WaitGroup, requeueExpired bool) {
+	StartDethrottler(q.rp, quit, wg, q.qType)
+	StartReleaser(q.rp, quit, wg, q.qType)
+	StartReaper(q.rp, quit, wg, q.qType, requeueExpired)
+}
+
+func (q *valkeyQueue) Push(queue string, tps int, value string, priority Priority, at time.Time) error {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return PushOntoQueueAt(rc, q.qType, queue, tps, value, priority, at)
+}
+
+func (q *valkeyQueue) Schedule(queue string, tps int, value string, priority Priority, at time.Time) error {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return ScheduleOntoQueue(rc, q.qType, queue, tps, value, priority, at)
+}
+
+func (q *valkeyQueue) Pop() (WorkerToken, string, time.Time, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return PopFromQueue(rc, q.qType)
+}
+
+func (q *valkeyQueue) MarkComplete(token WorkerToken) error {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return MarkComplete(rc, q.qType, token)
+}
+
+func (q *valkeyQueue) Refund(token WorkerToken) error {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return Refund(rc, q.qType, token)
+}
+
+func (q *valkeyQueue) Pause(queue string, duration time.Duration, bulkOnly bool) error {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return PauseQueue(rc, queue, duration, bulkOnly)
+}
+
+func (q *valkeyQueue) Resume(queue string) error {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return ResumeQueue(rc, queue)
+}
+
+func (q *valkeyQueue) Purge(queue string) (int, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return PurgeQueue(rc, q.qType, queue)
+}
+
+func (q *valkeyQueue) SetTPS(queue string, tps int) error {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return SetTPS(rc, q.qType, queue, tps)
+}
+
+func (q *valkeyQueue) SetLimits(queue string, limits []Limit) error {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return SetLimits(rc, q.qType, queue, limits)
+}
+
+func (q *valkeyQueue) SetOriginPriorities(priorities map[string]Priority) error {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return SetOriginPriorities(rc, q.qType, priorities)
+}
+
+func (q *valkeyQueue) LockURN(queue string, tps int, priority Priority, urn string, id string, value string, pushedOn time.Time, lease time.Duration) (string, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return LockURN(rc, q.qType, queue, tps, priority, urn, id, value, pushedOn, lease)
+}
+
+func (q *valkeyQueue) UnlockURN(queue string, urn string, token string, gap time.Duration) (bool, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return UnlockURN(rc, q.qType, queue, urn, token, gap)
+}
+
+func (q *valkeyQueue) HoldURN(queue string, urn string, token string, id string, delay time.Duration) (bool, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return HoldURN(rc, q.qType, queue, urn, token, id, delay)
+}
+
+func (q *valkeyQueue) AddDeadLetter(queue string, tps int, priority Priority, value string, cause error) (string, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return AddDeadLetter(rc, q.qType, queue, tps, priority, value, cause)
+}
+
+func (q *valkeyQueue) DeadLetters() ([]*DeadLetter, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return GetDeadLetters(rc, q.qType)
+}
+
+func (q *valkeyQueue) ReplayDeadLetter(id string) (bool, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return ReplayDeadLetter(rc, q.qType, id)
+}
+
+func (q *valkeyQueue) DiscardDeadLetter(id string) (bool, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return DiscardDeadLetter(rc, q.qType, id)
+}
+
+func (q *valkeyQueue) Queues() ([]*Info, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return ListQueues(rc, q.qType)
+}
+
+func (q *valkeyQueue) ExtractThrottles() (map[string]int, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	return ExtractThrottles(rc, q.qType)
+}
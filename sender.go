@@ -5,9 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/urns"
 )
 
@@ -34,9 +37,13 @@ func (r *SendResult) GetNewURN() urns.URN {
 }
 
 type SendError struct {
-	msg       string
-	retryable bool
-	loggable  bool
+	msg        string
+	retryable  bool
+	loggable   bool
+	retryAfter time.Duration
+
+	pause         time.Duration
+	pauseBulkOnly bool
 
 	clogCode    string
 	clogMsg     string
@@ -119,6 +126,15 @@ var ErrResponseUnexpected error = &SendError{
 	clogMsg:   "Response doesn't match expected values.",
 }
 
+// ErrMsgExpired should be returned when the message expires before all its parts could be sent
+var ErrMsgExpired error = &SendError{
+	msg:       "message expired",
+	retryable: false,
+	loggable:  false,
+	clogCode:  "msg_expired",
+	clogMsg:   "Message expired before it could be sent.",
+}
+
 // ErrContactStopped should be returned when channel tells us explicitly that the contact has opted-out
 var ErrContactStopped error = &SendError{
 	msg:       "contact opted out",
@@ -128,6 +144,46 @@ var ErrContactStopped error = &SendError{
 	clogMsg:   "Contact has opted-out of messages from this channel.",
 }
 
+// ErrRetryAfter returns a copy of the passed in send error which tells us not to retry before the given delay, e.g. from
+// a Retry-After header in the channel's response
+func ErrRetryAfter(err error, delay time.Duration) error {
+	var serr *SendError
+	if !errors.As(err, &serr) {
+		return err
+	}
+
+	withDelay := *serr
+	withDelay.retryAfter = delay
+	return &withDelay
+}
+
+// ErrPauseQueue returns a copy of the passed in send error which tells us to pause sending on the channel for the given
+// duration, or only sending of bulk messages if bulkOnly is set. Throttled errors with a retry delay pause the channel
+// for that delay without needing this.
+func ErrPauseQueue(err error, duration time.Duration, bulkOnly bool) error {
+	var serr *SendError
+	if !errors.As(err, &serr) {
+		return err
+	}
+
+	withPause := *serr
+	withPause.pause = duration
+	withPause.pauseBulkOnly = bulkOnly
+	return &withPause
+}
+
+// queuePause returns how long sending on the channel should be paused for after this error, and whether that's only
+// sending of bulk messages
+func (e *SendError) queuePause() (time.Duration, bool) {
+	if e.pause > 0 {
+		return e.pause, e.pauseBulkOnly
+	}
+	if e.clogCode == ErrConnectionThrottled.(*SendError).clogCode {
+		return e.retryAfter, false
+	}
+	return 0, false
+}
+
 func ErrFailedWithReason(code, desc string) *SendError {
 	return &SendError{
 		msg:         "channel rejected send with reason",
@@ -145,17 +201,47 @@ type Foreman struct {
 	senders          []*Sender
 	availableSenders chan *Sender
 	quit             chan bool
+	assignDone       chan bool
+	sendersDone      sync.WaitGroup
+
+	// context for sends which is cancelled if they don't complete within our drain timeout when stopping
+	sendCtx     context.Context
+	cancelSends context.CancelFunc
+
+	// number of sends currently in progress for each channel, and the concurrency limits by channel type
+	inFlight          map[ChannelUUID]int
+	inFlightMutex     sync.Mutex
+	maxInFlightByType map[ChannelType]int
+
+	// msgs popped for channels which were at their concurrency limit, only accessed by Assign
+	held []*heldMsg
+
+	breakers *CircuitBreakers
+}
+
+// heldMsg is a msg which was popped for a channel at its concurrency limit and is waiting for a free slot
+type heldMsg struct {
+	msg    MsgOut
+	heldOn time.Time
 }
 
 // NewForeman creates a new Foreman for the passed in server with the number of max senders
 func NewForeman(server Server, maxSenders int) *Foreman {
+	maxInFlightByType, _ := server.Config().ParseMaxInFlightByType() // already validated
+
 	foreman := &Foreman{
-		server:           server,
-		senders:          make([]*Sender, maxSenders),
-		availableSenders: make(chan *Sender, maxSenders),
-		quit:             make(chan bool),
+		server:            server,
+		senders:           make([]*Sender, maxSenders),
+		availableSenders:  make(chan *Sender, maxSenders),
+		quit:              make(chan bool),
+		assignDone:        make(chan bool),
+		inFlight:          make(map[ChannelUUID]int),
+		maxInFlightByType: maxInFlightByType,
+		breakers:          NewCircuitBreakers(server.Config().BreakerThreshold, time.Duration(server.Config().BreakerCooldown)*time.Second),
 	}
 
+	foreman.sendCtx, foreman.cancelSends = context.WithCancel(context.Background())
+
 	for i := 0; i < maxSenders; i++ {
 		foreman.senders[i] = NewSender(foreman, i)
 	}
@@ -171,13 +257,41 @@ func (f *Foreman) Start() {
 	go f.Assign()
 }
 
-// Stop stops the foreman and all its senders, the wait group of the server can be used to track progress
+// Stop drains the foreman and stops all its senders. We stop popping msgs, return any held msgs to the queue, and
+// give in-flight sends until our drain timeout to complete. Sends still going after that are cancelled.
 func (f *Foreman) Stop() {
+	log := slog.With("comp", "foreman")
+	log.Info("foreman stopping", "state", "stopping")
+
+	// stop assigning, waiting for any msg currently being popped to be given to a sender
+	close(f.quit)
+	<-f.assignDone
+
+	for _, h := range f.held {
+		f.returnMsg(h.msg)
+	}
+	f.held = nil
+
 	for _, sender := range f.senders {
 		sender.Stop()
 	}
-	close(f.quit)
-	slog.Info("foreman stopping", "comp", "foreman", "state", "stopping")
+
+	drained := make(chan bool)
+	go func() {
+		f.sendersDone.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(time.Duration(f.server.Config().DrainTimeout) * time.Second):
+		log.Warn("drain timeout reached, cancelling in-flight sends")
+		f.cancelSends()
+		<-drained
+	}
+
+	f.cancelSends()
+	log.Info("foreman drained", "state", "drained")
 }
 
 // Assign is our main loop for the Foreman, it takes care of popping the next outgoing messages from our
@@ -185,13 +299,13 @@ func (f *Foreman) Stop() {
 func (f *Foreman) Assign() {
 	f.server.WaitGroup().Add(1)
 	defer f.server.WaitGroup().Done()
+	defer close(f.assignDone)
 	log := slog.With("comp", "foreman")
 
 	log.Info("senders started and waiting",
 		"state", "started",
 		"senders", len(f.senders))
 
-	backend := f.server.Backend()
 	lastSleep := false
 
 	for {
@@ -203,9 +317,17 @@ func (f *Foreman) Assign() {
 
 		// otherwise, grab the next msg and assign it to a sender
 		case sender := <-f.availableSenders:
+			// if we were told to stop while waiting for a sender, don't give it anything else to do
+			select {
+			case <-f.quit:
+				log.Info("foreman stopped", "state", "stopped")
+				return
+			default:
+			}
+
 			// see if we have a message to work on
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-			msg, err := backend.PopNextOutgoingMsg(ctx)
+			msg, err := f.nextMsg(ctx)
 			cancel()
 
 			if err == nil && msg != nil {
@@ -230,6 +352,87 @@ func (f *Foreman) Assign() {
 	}
 }
 
+// Status returns a string describing the state of the foreman's channel circuit breakers
+func (f *Foreman) Status() string {
+	return f.breakers.Status()
+}
+
+// nextMsg returns the next msg which can be sent without exceeding its channel's concurrency limit. Held msgs are
+// given out first, then we pop from the backend, holding any msgs for saturated channels so that we can keep serving
+// other channels. We never hold more msgs than we have senders, and msgs held longer than our hold timeout are returned
+// to the queue so that they don't keep their worker lease and URN lock while their channel stays saturated.
+func (f *Foreman) nextMsg(ctx context.Context) (MsgOut, error) {
+	holdTimeout := time.Duration(f.server.Config().SendHoldTimeout) * time.Second
+
+	f.held = slices.DeleteFunc(f.held, func(h *heldMsg) bool {
+		if time.Since(h.heldOn) >= holdTimeout {
+			slog.Debug("msg held too long, returning to queue", "comp", "foreman", "channel_uuid", h.msg.Channel().UUID(), "msg_id", h.msg.ID())
+			f.returnMsg(h.msg)
+			return true
+		}
+		return false
+	})
+
+	for i, h := range f.held {
+		if f.claimSlot(h.msg.Channel()) {
+			f.held = slices.Delete(f.held, i, i+1)
+			return h.msg, nil
+		}
+	}
+
+	for len(f.held) < len(f.senders) {
+		msg, err := f.server.Backend().PopNextOutgoingMsg(ctx)
+		if err != nil || msg == nil {
+			return nil, err
+		}
+
+		if f.claimSlot(msg.Channel()) {
+			return msg, nil
+		}
+
+		slog.Debug("channel at max in flight, holding msg", "comp", "foreman", "channel_uuid", msg.Channel().UUID(), "msg_id", msg.ID())
+		f.held = append(f.held, &heldMsg{msg: msg, heldOn: time.Now()})
+	}
+
+	return nil, nil
+}
+
+// returnMsg puts a msg which was popped but won't be sent back on the queue
+func (f *Foreman) returnMsg(msg MsgOut) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if err := f.server.Backend().ReturnMsg(ctx, msg); err != nil {
+		slog.Error("error returning unsent msg to queue", "comp", "foreman", "msg_id", msg.ID(), "error", err)
+	}
+}
+
+// claimSlot tries to claim a send slot for the given channel, returning false if it is at its limit
+func (f *Foreman) claimSlot(ch Channel) bool {
+	limit := channelMaxInFlight(ch, f.server.Config().MaxInFlight, f.maxInFlightByType)
+
+	f.inFlightMutex.Lock()
+	defer f.inFlightMutex.Unlock()
+
+	if limit > 0 && f.inFlight[ch.UUID()] >= limit {
+		return false
+	}
+
+	f.inFlight[ch.UUID()]++
+	return true
+}
+
+// releaseSlot releases a send slot previously claimed for the given channel
+func (f *Foreman) releaseSlot(ch Channel) {
+	f.inFlightMutex.Lock()
+	defer f.inFlightMutex.Unlock()
+
+	f.inFlight[ch.UUID()]--
+	if f.inFlight[ch.UUID()] <= 0 {
+		delete(f.inFlight, ch.UUID())
+	}
+}
+
 // Sender is our type for a single goroutine that is sending messages
 type Sender struct {
 	id      int
@@ -250,9 +453,11 @@ func NewSender(foreman *Foreman, id int) *Sender {
 // Start starts our Sender's goroutine and has it start waiting for tasks from the foreman
 func (w *Sender) Start() {
 	w.foreman.server.WaitGroup().Add(1)
+	w.foreman.sendersDone.Add(1)
 
 	go func() {
 		defer w.foreman.server.WaitGroup().Done()
+		defer w.foreman.sendersDone.Done()
 		slog.Debug("started", "comp", "sender", "sender_id", w.id)
 		for {
 			// list ourselves as available for work
@@ -267,12 +472,18 @@ func (w *Sender) Start() {
 				return
 			}
 
-			w.sendMessage(msg)
+			// if we're past our drain timeout, don't start any new sends
+			if w.foreman.sendCtx.Err() != nil {
+				w.foreman.returnMsg(msg)
+			} else {
+				w.sendMessage(msg)
+			}
+			w.foreman.releaseSlot(msg.Channel())
 		}
 	}()
 }
 
-// Stop stops our senders, callers can use the server's wait group to track progress
+// Stop stops our sender once it has sent any msg already assigned to it
 func (w *Sender) Stop() {
 	close(w.job)
 }
@@ -284,7 +495,7 @@ func (w *Sender) sendMessage(msg MsgOut) {
 	backend := server.Backend()
 
 	// we don't want any individual send taking more than 35s
-	sendCTX, cancel := context.WithTimeout(context.Background(), time.Second*35)
+	sendCTX, cancel := context.WithTimeout(w.foreman.sendCtx, time.Second*35)
 	defer cancel()
 
 	log = log.With("msg_id", msg.ID(), "msg_text", msg.Text(), "msg_urn", msg.URN().Identity())
@@ -311,7 +522,17 @@ func (w *Sender) sendMessage(msg MsgOut) {
 		log.Error("error looking up msg was sent", "error", err)
 	}
 
+	// was a msg with the same content recently sent to the same URN? (resends are always allowed through)
+	duplicate := false
+	if !sent && !msg.IsResend() {
+		duplicate, err = backend.WasMsgDuplicate(sendCTX, msg)
+		if err != nil {
+			log.Error("error looking up msg was duplicate", "error", err)
+		}
+	}
+
 	var status StatusUpdate
+	var serr *SendError
 	var redactValues []string
 	handler := server.GetHandler(msg.Channel())
 	if handler != nil {
@@ -319,6 +540,7 @@ func (w *Sender) sendMessage(msg MsgOut) {
 	}
 
 	clog := NewChannelLogForSend(msg, redactValues)
+	clog.SetDryRun(server.Config().DryRun || msg.Channel().BoolConfigForKey(ConfigDryRun, false))
 
 	if handler == nil {
 		// if there's no handler, create a FAILED status for it
@@ -330,14 +552,73 @@ func (w *Sender) sendMessage(msg MsgOut) {
 		status = backend.NewStatusUpdate(msg.Channel(), msg.ID(), MsgStatusWired, clog)
 		log.Warn("duplicate send, marking as wired")
 
+	} else if msg.ExpiresOn() != nil && !dates.Now().Before(*msg.ExpiresOn()) {
+		// if this message expired while it was queued, fail it rather than send something stale
+		status = backend.NewStatusUpdate(msg.Channel(), msg.ID(), MsgStatusFailed, clog)
+		status.SetFailedReason(MsgFailedReasonTooOld)
+		clog.Error(&clogs.Error{Code: ErrMsgExpired.(*SendError).clogCode, Message: ErrMsgExpired.(*SendError).clogMsg})
+		log.Info("msg expired before it could be sent", "expires_on", msg.ExpiresOn())
+
+	} else if duplicate {
+		// if a msg with the same content was just sent to this URN, fail this one as looping
+		status = backend.NewStatusUpdate(msg.Channel(), msg.ID(), MsgStatusFailed, clog)
+		status.SetFailedReason(MsgFailedReasonLooping)
+		clog.Error(&clogs.Error{Code: "msg_duplicate", Message: "Message suppressed as a duplicate of one recently sent to the same contact."})
+		log.Info("msg suppressed as duplicate")
+
+	} else if clog.DryRun() {
+		// in dry run mode the handler builds its requests as normal but they're captured, and the msg is always wired
+		// with the channel log recording that it was a dry run
+		status, _ = w.sendByHandler(sendCTX, handler, msg, clog, log)
+		log.Debug("msg sent in dry run mode")
+
+	} else if allowed, wait := w.foreman.breakers.Allow(msg.Channel().UUID()); !allowed {
+		// if this channel's circuit breaker is open, its queue should be paused but this msg was popped anyway, e.g.
+		// while a probe is being sent, so pause it again and put the msg back on the queue without trying to send it,
+		// so that it doesn't count as a retry
+		w.pauseChannel(sendCTX, msg.Channel(), wait, false, log)
+		w.foreman.returnMsg(msg)
+		log.Debug("channel circuit open, returned msg to queue")
+		return
+
 	} else {
-		status = w.sendByHandler(sendCTX, handler, msg, clog, log)
+		status, serr = w.sendByHandler(sendCTX, handler, msg, clog, log)
+
+		// feed the outcome to the channel's circuit breaker, where being throttled tells us nothing about whether the
+		// channel is up
+		channelUUID := msg.Channel().UUID()
+		if serr != nil && serr.clogCode == ErrConnectionThrottled.(*SendError).clogCode {
+			w.foreman.breakers.Skip(channelUUID)
+		} else {
+			connectionFailed := serr != nil && serr.clogCode == ErrConnectionFailed.(*SendError).clogCode
+			if state, changed := w.foreman.breakers.Record(channelUUID, connectionFailed); changed {
+				if state == BreakerOpen {
+					clog.Error(&clogs.Error{Code: "circuit_tripped", Message: "Sending paused due to too many consecutive connection failures."})
+
+					// pause the channel's queue so its msgs stay queued until the cooldown is over
+					w.pauseChannel(sendCTX, msg.Channel(), time.Duration(server.Config().BreakerCooldown)*time.Second, false, log)
+				}
+				log.Warn("channel circuit breaker changed state", "state", state)
+			}
+		}
+
+		// if the channel told us to back off, pause its queue so its other msgs don't run into the same limit
+		if serr != nil {
+			if pause, bulkOnly := serr.queuePause(); pause > 0 {
+				w.pauseChannel(sendCTX, msg.Channel(), pause, bulkOnly, log)
+			}
+		}
 	}
 
 	// we allot 15 seconds to write our status to the db
 	writeCTX, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	// if the send failed with a retryable error, try to requeue it ourselves to be retried after a backoff
+	if serr != nil && serr.retryable && w.requeueForRetry(writeCTX, msg, serr, log) {
+		status.SetStatus(MsgStatusQueued)
+	}
+
 	if err := backend.WriteStatusUpdate(writeCTX, status); err != nil {
 		log.Info("error writing msg status", "error", err)
 	}
@@ -353,16 +634,60 @@ func (w *Sender) sendMessage(msg MsgOut) {
 	backend.OnSendComplete(writeCTX, msg, status, clog)
 }
 
-func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut, clog *ChannelLog, log *slog.Logger) StatusUpdate {
+// pauseChannel pauses popping msgs from the given channel's queue for the given duration, or only its bulk msgs
+func (w *Sender) pauseChannel(ctx context.Context, ch Channel, pause time.Duration, bulkOnly bool, log *slog.Logger) {
+	if err := w.foreman.server.Backend().PauseChannel(ctx, ch, pause, bulkOnly); err != nil {
+		log.Error("error pausing channel", "error", err)
+	} else {
+		log.Info("paused channel", "pause", pause, "bulk_only", bulkOnly)
+	}
+}
+
+// requeueForRetry requeues the given msg to be retried after a delay which doubles with each retry, unless the channel
+// told us how long to wait. Returns false if the msg has used up its retries or couldn't be requeued.
+func (w *Sender) requeueForRetry(ctx context.Context, msg MsgOut, serr *SendError, log *slog.Logger) bool {
+	config := w.foreman.server.Config()
+	if msg.Retries() >= config.SendRetries {
+		return false
+	}
+
+	delay := RetryDelay(config, msg.Retries(), serr.retryAfter)
+
+	if err := w.foreman.server.Backend().RequeueMsg(ctx, msg, delay); err != nil {
+		log.Error("error requeuing msg for retry", "error", err)
+		return false
+	}
+
+	log.Info("requeued msg for retry", "retries", msg.Retries()+1, "delay", delay)
+	return true
+}
+
+// RetryDelay returns the delay before the next retry of a msg which has already been retried the given number of times
+func RetryDelay(config *Config, retries int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+
+	delay := time.Duration(config.SendRetryDelay) * time.Second
+	maxDelay := time.Duration(max(config.SendRetryMaxDelay, config.SendRetryDelay)) * time.Second
+
+	// double the delay for each retry, stopping at our max delay so that it can't overflow however many retries
+	for i := 0; i < retries && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut, clog *ChannelLog, log *slog.Logger) (StatusUpdate, *SendError) {
 	backend := w.foreman.server.Backend()
 	res := &SendResult{newURN: urns.NilURN}
 	err := h.Send(ctx, m, res, clog)
 
 	status := backend.NewStatusUpdate(m.Channel(), m.ID(), MsgStatusWired, clog)
 
-	// fow now we can only store one external id per message
+	// msgs sent in multiple parts will have an external id for each part
 	if len(res.ExternalIDs()) > 0 {
-		status.SetExternalID(res.ExternalIDs()[0])
+		status.SetExternalIDs(res.ExternalIDs())
 	}
 
 	if res.newURN != urns.NilURN {
@@ -373,12 +698,20 @@ func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut,
 	}
 
 	var serr *SendError
-	if errors.As(err, &serr) {
+	if clog.DryRun() {
+		// the channel's responses were faked so a handler which needs them, e.g. to get the external id of each part
+		// before sending the next, will have stopped at the first request, but that's still a successful dry run
+		clog.Error(dryRunError(err))
+
+	} else if errors.As(err, &serr) {
 		if serr.loggable {
 			log.Error("error sending message", "error", err)
 		}
 		if serr.retryable {
 			status.SetStatus(MsgStatusErrored)
+		} else if serr.clogCode == ErrMsgExpired.(*SendError).clogCode {
+			status.SetStatus(MsgStatusFailed)
+			status.SetFailedReason(MsgFailedReasonTooOld)
 		} else {
 			status.SetStatus(MsgStatusFailed)
 		}
@@ -401,5 +734,5 @@ func (w *Sender) sendByHandler(ctx context.Context, h ChannelHandler, m MsgOut,
 		clog.Error(&clogs.Error{Code: "internal_error", Message: "An internal error occured."})
 	}
 
-	return status
+	return status, serr
 }
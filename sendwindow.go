@@ -0,0 +1,114 @@
+package courier
+
+//go:generate go run ./tools/country_timezones -out timezones.go
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var sendWindowRegex = regexp.MustCompile(`^\s*(\d{1,2}):(\d{2})\s*-\s*(\d{1,2}):(\d{2})\s*$`)
+
+// how long msgs are held for before their channel's send window is checked again if it can't be read
+const sendWindowRecheck = time.Hour
+
+// SendWindow is a daily period of local time during which a channel is allowed to send msgs
+type SendWindow struct {
+	start    int // minutes since midnight
+	end      int
+	location *time.Location
+}
+
+// ParseSendWindow parses a window like "08:00-20:00" in the given location. A window which ends before it starts
+// wraps around midnight, and one which ends when it starts is always open.
+func ParseSendWindow(s string, loc *time.Location) (*SendWindow, error) {
+	match := sendWindowRegex.FindStringSubmatch(s)
+	if match == nil {
+		return nil, fmt.Errorf("invalid send window '%s'", s)
+	}
+
+	parts := make([]int, 4)
+	for i := range parts {
+		parts[i], _ = strconv.Atoi(match[i+1])
+	}
+	if parts[0] > 23 || parts[1] > 59 || parts[2] > 24 || parts[3] > 59 || (parts[2] == 24 && parts[3] != 0) {
+		return nil, fmt.Errorf("invalid send window '%s'", s)
+	}
+
+	return &SendWindow{start: parts[0]*60 + parts[1], end: parts[2]*60 + parts[3], location: loc}, nil
+}
+
+// ChannelSendWindow returns the send window configured for the given channel, or nil if it doesn't have one. The
+// window is in the channel's configured timezone, or if it doesn't have one, the timezone of its country if that only
+// has one.
+func ChannelSendWindow(ch Channel) (*SendWindow, error) {
+	window := ch.StringConfigForKey(ConfigSendWindow, "")
+	if window == "" {
+		return nil, nil
+	}
+
+	tz := ch.StringConfigForKey(ConfigTimezone, countryTimezones[ch.Country()])
+	if tz == "" {
+		return nil, fmt.Errorf("no timezone configured for channel in country '%s' which doesn't have a single timezone", ch.Country())
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone '%s': %w", tz, err)
+	}
+
+	return ParseSendWindow(window, loc)
+}
+
+// NextOpen returns the given time if the window is open then, otherwise the time at which it next opens
+func (w *SendWindow) NextOpen(t time.Time) time.Time {
+	local := t.In(w.location)
+	mins := local.Hour()*60 + local.Minute()
+
+	var open bool
+	if w.start < w.end {
+		open = mins >= w.start && mins < w.end
+	} else if w.start > w.end {
+		open = mins >= w.start || mins < w.end
+	} else {
+		open = true
+	}
+	if open {
+		return t
+	}
+
+	opens := time.Date(local.Year(), local.Month(), local.Day(), w.start/60, w.start%60, 0, 0, w.location)
+	if mins >= w.start {
+		opens = time.Date(local.Year(), local.Month(), local.Day()+1, w.start/60, w.start%60, 0, 0, w.location)
+	}
+	return opens
+}
+
+// NextSendTime returns the earliest time from the given time that the given msg can be sent, taking into account its
+// send after time and its channel's send window. Send windows only apply to bulk msgs, i.e. those being sent with the
+// lowest queue priority, unless the channel is configured to apply them to all msgs. If the channel has a send window
+// which can't be read, e.g. because its timezone can't be determined, msgs are held rather than sent at any time of day.
+func NextSendTime(msg MsgOut, bulk bool, now time.Time) time.Time {
+	sendAt := now
+	if msg.SendAfter() != nil && msg.SendAfter().After(now) {
+		sendAt = *msg.SendAfter()
+	}
+
+	if !bulk && !msg.Channel().BoolConfigForKey(ConfigSendWindowAllPriorities, false) {
+		return sendAt
+	}
+
+	window, err := ChannelSendWindow(msg.Channel())
+	if err != nil {
+		slog.Error("error reading channel send window", "channel_uuid", msg.Channel().UUID(), "error", err)
+		return sendAt.Add(sendWindowRecheck)
+	}
+	if window == nil {
+		return sendAt
+	}
+
+	return window.NextOpen(sendAt)
+}
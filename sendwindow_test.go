@@ -0,0 +1,74 @@
+package courier_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/test"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendWindow(t *testing.T) {
+	kigali, _ := time.LoadLocation("Africa/Kigali") // UTC+2
+
+	_, err := courier.ParseSendWindow("8am-8pm", kigali)
+	assert.EqualError(t, err, "invalid send window '8am-8pm'")
+	_, err = courier.ParseSendWindow("08:00-25:00", kigali)
+	assert.EqualError(t, err, "invalid send window '08:00-25:00'")
+
+	tcs := []struct {
+		window   string
+		now      time.Time
+		expected time.Time
+	}{
+		{"08:00-20:00", time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC), time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)},   // open
+		{"08:00-20:00", time.Date(2025, 3, 1, 5, 30, 0, 0, time.UTC), time.Date(2025, 3, 1, 6, 0, 0, 0, time.UTC)},    // opens later today
+		{"08:00-20:00", time.Date(2025, 3, 1, 18, 0, 0, 0, time.UTC), time.Date(2025, 3, 2, 6, 0, 0, 0, time.UTC)},    // opens tomorrow
+		{"08:00-20:00", time.Date(2025, 3, 1, 17, 59, 0, 0, time.UTC), time.Date(2025, 3, 1, 17, 59, 0, 0, time.UTC)}, // just before close
+		{"20:00-06:00", time.Date(2025, 3, 1, 23, 0, 0, 0, time.UTC), time.Date(2025, 3, 1, 23, 0, 0, 0, time.UTC)},   // open over midnight
+		{"20:00-06:00", time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), time.Date(2025, 3, 1, 18, 0, 0, 0, time.UTC)},   // closed during the day
+		{"09:00-09:00", time.Date(2025, 3, 1, 3, 0, 0, 0, time.UTC), time.Date(2025, 3, 1, 3, 0, 0, 0, time.UTC)},     // always open
+		{"06:00-24:00", time.Date(2025, 3, 1, 21, 59, 0, 0, time.UTC), time.Date(2025, 3, 1, 21, 59, 0, 0, time.UTC)}, // open until midnight
+	}
+
+	for _, tc := range tcs {
+		w, err := courier.ParseSendWindow(tc.window, kigali)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, w.NextOpen(tc.now).UTC(), "next open mismatch for %s at %s", tc.window, tc.now)
+	}
+}
+
+func TestNextSendTime(t *testing.T) {
+	now := time.Date(2025, 3, 1, 20, 0, 0, 0, time.UTC) // 22:00 in Rwanda, 15:00 in New York
+
+	noWindow := test.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "MCK", "2020", "RW", []string{urns.Phone.Prefix}, map[string]any{})
+	rwanda := test.NewMockChannel("53e5aafa-8155-449d-9009-fcb30d54bd26", "MCK", "2020", "RW", []string{urns.Phone.Prefix}, map[string]any{"send_window": "08:00-20:00"})
+	rwandaAll := test.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "MCK", "2020", "RW", []string{urns.Phone.Prefix}, map[string]any{"send_window": "08:00-20:00", "send_window_all_priorities": true})
+	us := test.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{"send_window": "08:00-20:00", "timezone": "America/New_York"})
+	usNoTZ := test.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c96a", "MCK", "2020", "US", []string{urns.Phone.Prefix}, map[string]any{"send_window": "08:00-20:00"})
+
+	bulk := func(ch courier.Channel) *test.MockMsg {
+		return test.NewMockMsg(courier.MsgID(101), courier.NilMsgUUID, ch, "tel:+250788383383", "hi", nil)
+	}
+	reply := func(ch courier.Channel) *test.MockMsg {
+		m := test.NewMockMsg(courier.MsgID(102), courier.NilMsgUUID, ch, "tel:+250788383383", "hi", nil)
+		m.WithHighPriority(true)
+		return m
+	}
+
+	assert.Equal(t, now, courier.NextSendTime(bulk(noWindow), true, now))
+	assert.Equal(t, time.Date(2025, 3, 2, 6, 0, 0, 0, time.UTC), courier.NextSendTime(bulk(rwanda), true, now).UTC())
+	assert.Equal(t, now, courier.NextSendTime(reply(rwanda), false, now))
+	assert.Equal(t, time.Date(2025, 3, 2, 6, 0, 0, 0, time.UTC), courier.NextSendTime(reply(rwandaAll), false, now).UTC())
+	assert.Equal(t, now, courier.NextSendTime(bulk(us), true, now))
+	assert.Equal(t, now.Add(time.Hour), courier.NextSendTime(bulk(usNoTZ), true, now)) // can't determine timezone so held
+	assert.Equal(t, now, courier.NextSendTime(reply(usNoTZ), false, now))              // unless window doesn't apply
+
+	// send after time is taken into account
+	assert.Equal(t, now.Add(time.Hour), courier.NextSendTime(bulk(noWindow).WithSendAfter(now.Add(time.Hour)), true, now))
+	assert.Equal(t, time.Date(2025, 3, 2, 6, 0, 0, 0, time.UTC), courier.NextSendTime(bulk(rwanda).WithSendAfter(now.Add(time.Hour)), true, now).UTC())
+	assert.Equal(t, time.Date(2025, 3, 2, 13, 0, 0, 0, time.UTC), courier.NextSendTime(bulk(us).WithSendAfter(now.Add(5*time.Hour)), true, now).UTC())
+}
@@ -107,6 +107,7 @@ func (s *server) Start() error {
 	s.router.Get("/", s.handleIndex)
 	s.router.Get("/status", s.basicAuthRequired(s.handleStatus))
 	s.publicRouter.Post("/_fetch-attachment", s.tokenAuthRequired(s.handleFetchAttachment)) // becomes /c/_fetch-attachment
+	s.addAdminRoutes()
 
 	// initialize our handlers
 	s.initializeChannelHandlers()
@@ -150,7 +151,7 @@ func (s *server) Stop() error {
 	log := slog.With("comp", "server")
 	log.Info("stopping server", "state", "stopping")
 
-	// stop our foreman
+	// stop our foreman, letting any in-flight sends complete
 	s.foreman.Stop()
 
 	// shut down our HTTP server
@@ -350,6 +351,10 @@ func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	buf.WriteString("\n\n")
 	buf.WriteString(s.backend.Status())
 	buf.WriteString("\n\n")
+	if s.foreman != nil {
+		buf.WriteString(s.foreman.Status())
+		buf.WriteString("\n\n")
+	}
 	buf.WriteString("</pre></body></html>")
 	w.Write(buf.Bytes())
 }
@@ -1,6 +1,11 @@
 package courier
 
-import "github.com/nyaruka/gocommon/urns"
+import (
+	"time"
+
+	"github.com/nyaruka/courier/utils/clogs"
+	"github.com/nyaruka/gocommon/urns"
+)
 
 // MsgStatus is the status of a message
 type MsgStatus string
@@ -18,6 +23,61 @@ const (
 	NilMsgStatus       MsgStatus = ""
 )
 
+// MsgFailedReason is the reason a message failed, which is saved with it when the reason isn't that sending it errored
+// too many times
+type MsgFailedReason string
+
+// Possible values for MsgFailedReason
+const (
+	MsgFailedReasonTooOld  MsgFailedReason = "O" // expired before it could be sent
+	MsgFailedReasonLooping MsgFailedReason = "L" // duplicate of a msg just sent to the same contact
+	NilMsgFailedReason     MsgFailedReason = ""
+)
+
+// how far along in being sent a msg is with each status, where errored is the same as queued because an errored msg is
+// queued to be retried
+var msgStatusProgress = map[MsgStatus]int{
+	MsgStatusPending:   0,
+	MsgStatusQueued:    1,
+	MsgStatusErrored:   1,
+	MsgStatusWired:     2,
+	MsgStatusSent:      3,
+	MsgStatusDelivered: 4,
+	MsgStatusRead:      5,
+}
+
+// IsFinal returns whether a msg with this status can't be updated to any other status. A failed msg can still be resent
+// but that resets its status before it's queued again.
+func (s MsgStatus) IsFinal() bool {
+	return s == MsgStatusFailed
+}
+
+// CanTransitionTo returns whether a msg with this status can be updated to the given status. Statuses only move forward
+// so that a late update can't replace a later one, i.e. read beats delivered beats sent beats wired. A msg can error
+// until it's sent and can fail until it's delivered, so delivered and read can only be replaced by read, and failed is
+// final.
+func (s MsgStatus) CanTransitionTo(to MsgStatus) bool {
+	if s == to {
+		return true
+	}
+	if s.IsFinal() {
+		return false
+	}
+
+	from, fromKnown := msgStatusProgress[s]
+
+	if to.IsFinal() {
+		return !fromKnown || from < msgStatusProgress[MsgStatusDelivered]
+	}
+	if to == MsgStatusErrored {
+		return !fromKnown || from < msgStatusProgress[MsgStatusSent]
+	}
+
+	progress, toKnown := msgStatusProgress[to]
+
+	return !fromKnown || !toKnown || progress >= from
+}
+
 //-----------------------------------------------------------------------------
 // StatusUpdate Interface
 //-----------------------------------------------------------------------------
@@ -35,6 +95,22 @@ type StatusUpdate interface {
 	ExternalID() string
 	SetExternalID(string)
 
+	// ExternalIDs returns all the external IDs of a msg sent in multiple parts, the first being its main external ID
+	ExternalIDs() []string
+	SetExternalIDs([]string)
+
 	Status() MsgStatus
 	SetStatus(MsgStatus)
+
+	// FailedReason returns why a failed msg failed, if that's not because sending it errored too many times
+	FailedReason() MsgFailedReason
+	SetFailedReason(MsgFailedReason)
+}
+
+// StatusTimelineEntry is a status update which was applied to an outgoing message
+type StatusTimelineEntry struct {
+	Status     MsgStatus  `json:"status"`
+	ExternalID string     `json:"external_id,omitempty"`
+	LogUUID    clogs.UUID `json:"log_uuid"`
+	CreatedOn  time.Time  `json:"created_on"`
 }
@@ -0,0 +1,65 @@
+package courier_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgStatusTransitions(t *testing.T) {
+	assert.False(t, courier.MsgStatusQueued.IsFinal())
+	assert.False(t, courier.MsgStatusRead.IsFinal())
+	assert.True(t, courier.MsgStatusFailed.IsFinal())
+
+	tcs := []struct {
+		from    courier.MsgStatus
+		to      courier.MsgStatus
+		allowed bool
+	}{
+		{courier.MsgStatusQueued, courier.MsgStatusWired, true},
+		{courier.MsgStatusQueued, courier.MsgStatusDelivered, true}, // can skip statuses
+		{courier.MsgStatusWired, courier.MsgStatusSent, true},
+		{courier.MsgStatusSent, courier.MsgStatusDelivered, true},
+		{courier.MsgStatusDelivered, courier.MsgStatusRead, true},
+		{courier.MsgStatusSent, courier.MsgStatusSent, true},
+		{courier.MsgStatusRead, courier.MsgStatusDelivered, false},
+		{courier.MsgStatusDelivered, courier.MsgStatusSent, false},
+		{courier.MsgStatusDelivered, courier.MsgStatusWired, false},
+		{courier.MsgStatusSent, courier.MsgStatusWired, false},
+		{courier.MsgStatusWired, courier.MsgStatusQueued, false},
+
+		// errored msgs are retried
+		{courier.MsgStatusQueued, courier.MsgStatusErrored, true},
+		{courier.MsgStatusWired, courier.MsgStatusErrored, true},
+		{courier.MsgStatusSent, courier.MsgStatusErrored, false},
+		{courier.MsgStatusErrored, courier.MsgStatusErrored, true},
+		{courier.MsgStatusErrored, courier.MsgStatusQueued, true},
+		{courier.MsgStatusErrored, courier.MsgStatusWired, true},
+		{courier.MsgStatusDelivered, courier.MsgStatusErrored, false},
+
+		// failed is final
+		{courier.MsgStatusWired, courier.MsgStatusFailed, true},
+		{courier.MsgStatusErrored, courier.MsgStatusFailed, true},
+		{courier.MsgStatusQueued, courier.MsgStatusFailed, true},
+		{courier.MsgStatusSent, courier.MsgStatusFailed, true},
+		{courier.MsgStatusFailed, courier.MsgStatusFailed, true},
+		{courier.MsgStatusFailed, courier.MsgStatusWired, false},
+		{courier.MsgStatusFailed, courier.MsgStatusDelivered, false},
+		{courier.MsgStatusFailed, courier.MsgStatusErrored, false},
+		{courier.MsgStatusFailed, courier.MsgStatusSent, false},
+
+		// delivered and read can only be replaced by read
+		{courier.MsgStatusDelivered, courier.MsgStatusFailed, false},
+		{courier.MsgStatusRead, courier.MsgStatusFailed, false},
+		{courier.MsgStatusRead, courier.MsgStatusErrored, false},
+
+		// unknown statuses don't restrict anything
+		{courier.NilMsgStatus, courier.MsgStatusWired, true},
+		{courier.MsgStatus("I"), courier.MsgStatusQueued, true},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.allowed, tc.from.CanTransitionTo(tc.to), "transition mismatch for %s -> %s", tc.from, tc.to)
+	}
+}
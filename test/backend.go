@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,6 +16,7 @@ import (
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
 )
@@ -26,6 +29,17 @@ func buildMockBackend(config *courier.Config) courier.Backend {
 	return NewMockBackend()
 }
 
+type RequeuedMsg struct {
+	Msg   courier.MsgOut
+	Delay time.Duration
+}
+
+type PausedChannel struct {
+	Channel  courier.Channel
+	Duration time.Duration
+	BulkOnly bool
+}
+
 type SavedAttachment struct {
 	Channel     courier.Channel
 	ContentType string
@@ -39,8 +53,15 @@ type MockBackend struct {
 	channelsByAddress map[courier.ChannelAddress]courier.Channel
 	contacts          map[urns.URN]courier.Contact
 	outgoingMsgs      []courier.MsgOut
+	requeuedMsgs      []*RequeuedMsg
+	returnedMsgs      []courier.MsgOut
+	pausedChannels    []*PausedChannel
+	deadLetters       []*courier.DeadLetter
+	statusTimelines   map[courier.MsgUUID][]*courier.StatusTimelineEntry
 	media             map[string]courier.Media // url -> Media
 	errorOnQueue      bool
+	poppedNotify      chan courier.MsgOut
+	returnedNotify    chan courier.MsgOut
 
 	mutex     sync.RWMutex
 	redisPool *redis.Pool
@@ -52,11 +73,13 @@ type MockBackend struct {
 	savedAttachments     []*SavedAttachment
 	storageError         error
 
-	lastMsgID       courier.MsgID
-	lastContactName string
-	urnAuthTokens   map[urns.URN]map[string]string
-	sentMsgs        map[courier.MsgID]bool
-	seenExternalIDs map[string]courier.MsgUUID
+	lastMsgID           courier.MsgID
+	lastContactName     string
+	lastContactLanguage i18n.Language
+	urnAuthTokens       map[urns.URN]map[string]string
+	sentMsgs            map[courier.MsgID]bool
+	sentContent         map[string]time.Time
+	seenExternalIDs     map[string]courier.MsgUUID
 }
 
 // NewMockBackend returns a new mock backend suitable for testing
@@ -89,7 +112,9 @@ func NewMockBackend() *MockBackend {
 		contacts:          make(map[urns.URN]courier.Contact),
 		media:             make(map[string]courier.Media),
 		sentMsgs:          make(map[courier.MsgID]bool),
+		sentContent:       make(map[string]time.Time),
 		seenExternalIDs:   make(map[string]courier.MsgUUID),
+		statusTimelines:   make(map[courier.MsgUUID][]*courier.StatusTimelineEntry),
 		redisPool:         redisPool,
 	}
 }
@@ -144,10 +169,14 @@ func (mb *MockBackend) PopNextOutgoingMsg(ctx context.Context) (courier.MsgOut,
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
 
-	if len(mb.outgoingMsgs) > 0 {
-		msg, rest := mb.outgoingMsgs[0], mb.outgoingMsgs[1:]
-		mb.outgoingMsgs = rest
-		return msg, nil
+	// msgs which can't be sent yet stay parked until they can be
+	now := time.Now()
+	for i, msg := range mb.outgoingMsgs {
+		if !courier.NextSendTime(msg, !msg.HighPriority(), now).After(now) {
+			mb.outgoingMsgs = slices.Delete(mb.outgoingMsgs, i, i+1)
+			notify(mb.poppedNotify, msg)
+			return msg, nil
+		}
 	}
 
 	return nil, nil
@@ -169,12 +198,196 @@ func (mb *MockBackend) ClearMsgSent(ctx context.Context, id courier.MsgID) error
 	return nil
 }
 
+func (mb *MockBackend) WasMsgDuplicate(ctx context.Context, msg courier.MsgOut) (bool, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	window := time.Duration(msg.Channel().IntConfigForKey(courier.ConfigDedupWindow, 0)) * time.Second
+	lastSent, ok := mb.sentContent[mockContentKey(msg)]
+	return ok && time.Since(lastSent) < window, nil
+}
+
+// RequeueMsg records that the passed in msg was requeued to be retried
+func (mb *MockBackend) RequeueMsg(ctx context.Context, msg courier.MsgOut, delay time.Duration) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.requeuedMsgs = append(mb.requeuedMsgs, &RequeuedMsg{Msg: msg, Delay: delay})
+	return nil
+}
+
+// ReturnMsg puts the msg back at the front of our outgoing msgs
+func (mb *MockBackend) ReturnMsg(ctx context.Context, msg courier.MsgOut) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.outgoingMsgs = append([]courier.MsgOut{msg}, mb.outgoingMsgs...)
+	mb.returnedMsgs = append(mb.returnedMsgs, msg)
+	notify(mb.returnedNotify, msg)
+	return nil
+}
+
+// NotifyPopped makes the backend send msgs to the given channel as they're popped so that tests can wait for them
+func (mb *MockBackend) NotifyPopped(c chan courier.MsgOut) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.poppedNotify = c
+}
+
+// NotifyReturned makes the backend send msgs to the given channel as they're returned so that tests can wait for them
+func (mb *MockBackend) NotifyReturned(c chan courier.MsgOut) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.returnedNotify = c
+}
+
+// sends the given msg to the given notification channel if it has room, so that tests which stop listening never block
+// the backend
+func notify(c chan courier.MsgOut, msg courier.MsgOut) {
+	if c != nil {
+		select {
+		case c <- msg:
+		default:
+		}
+	}
+}
+
 // OnSendComplete marks the passed msg as having been dealt with
+// PauseChannel records that the passed in channel was paused
+func (mb *MockBackend) PauseChannel(ctx context.Context, ch courier.Channel, duration time.Duration, bulkOnly bool) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.pausedChannels = append(mb.pausedChannels, &PausedChannel{Channel: ch, Duration: duration, BulkOnly: bulkOnly})
+	return nil
+}
+
+// ResumeChannel removes the recorded pauses of the passed in channel
+func (mb *MockBackend) ResumeChannel(ctx context.Context, ch courier.Channel) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.pausedChannels = slices.DeleteFunc(mb.pausedChannels, func(p *PausedChannel) bool { return p.Channel.UUID() == ch.UUID() })
+	return nil
+}
+
+// UpdateChannelQueue returns the channel with the passed in UUID as there's no queue to update
+func (mb *MockBackend) UpdateChannelQueue(ctx context.Context, uuid courier.ChannelUUID) (courier.Channel, error) {
+	return mb.GetChannel(ctx, courier.AnyChannelType, uuid)
+}
+
+// PurgeChannel removes the outgoing msgs of the passed in channel
+func (mb *MockBackend) PurgeChannel(ctx context.Context, ch courier.Channel) (int, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	before := len(mb.outgoingMsgs)
+	mb.outgoingMsgs = slices.DeleteFunc(mb.outgoingMsgs, func(m courier.MsgOut) bool { return m.Channel().UUID() == ch.UUID() })
+	return before - len(mb.outgoingMsgs), nil
+}
+
+// AddDeadLetter adds a dead letter to this backend
+func (mb *MockBackend) AddDeadLetter(dl *courier.DeadLetter) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.deadLetters = append(mb.deadLetters, dl)
+}
+
+// DeadLetters returns the dead letters added to this backend
+func (mb *MockBackend) DeadLetters(ctx context.Context) ([]*courier.DeadLetter, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	return slices.Clone(mb.deadLetters), nil
+}
+
+// ReplayDeadLetter removes the dead letter with the given ID
+func (mb *MockBackend) ReplayDeadLetter(ctx context.Context, id string) (bool, error) {
+	return mb.removeDeadLetter(id), nil
+}
+
+// DiscardDeadLetter removes the dead letter with the given ID
+func (mb *MockBackend) DiscardDeadLetter(ctx context.Context, id string) (bool, error) {
+	return mb.removeDeadLetter(id), nil
+}
+
+func (mb *MockBackend) removeDeadLetter(id string) bool {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	before := len(mb.deadLetters)
+	mb.deadLetters = slices.DeleteFunc(mb.deadLetters, func(dl *courier.DeadLetter) bool { return dl.ID == id })
+	return len(mb.deadLetters) < before
+}
+
+// AddStatusTimelineEntry adds an entry to the status timeline of the msg with the given UUID
+func (mb *MockBackend) AddStatusTimelineEntry(uuid courier.MsgUUID, entry *courier.StatusTimelineEntry) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.statusTimelines[uuid] = append(mb.statusTimelines[uuid], entry)
+}
+
+// MsgStatusTimeline returns the status timeline entries added for the msg with the given UUID
+func (mb *MockBackend) MsgStatusTimeline(ctx context.Context, uuid courier.MsgUUID) ([]*courier.StatusTimelineEntry, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	return append([]*courier.StatusTimelineEntry{}, mb.statusTimelines[uuid]...), nil
+}
+
+// ChannelQueues returns a queue for each channel with outgoing msgs
+func (mb *MockBackend) ChannelQueues(ctx context.Context) ([]*courier.ChannelQueue, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	queues := make([]*courier.ChannelQueue, 0)
+	byChannel := make(map[courier.ChannelUUID]*courier.ChannelQueue)
+
+	for _, m := range mb.outgoingMsgs {
+		q := byChannel[m.Channel().UUID()]
+		if q == nil {
+			q = &courier.ChannelQueue{ChannelUUID: m.Channel().UUID(), State: "active"}
+			byChannel[q.ChannelUUID] = q
+			queues = append(queues, q)
+		}
+		if m.HighPriority() {
+			q.Size++
+		} else {
+			q.BulkSize++
+		}
+	}
+
+	for _, p := range mb.pausedChannels {
+		if q := byChannel[p.Channel.UUID()]; q != nil {
+			q.Paused = q.Paused || !p.BulkOnly
+			q.BulkPaused = q.BulkPaused || p.BulkOnly
+		}
+	}
+
+	return queues, nil
+}
+
 func (mb *MockBackend) OnSendComplete(ctx context.Context, msg courier.MsgOut, s courier.StatusUpdate, clog *courier.ChannelLog) {
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
 
+	if clog.DryRun() {
+		return
+	}
+
 	mb.sentMsgs[msg.ID()] = true
+
+	if s.Status() == courier.MsgStatusWired || s.Status() == courier.MsgStatusSent {
+		mb.sentContent[mockContentKey(msg)] = time.Now()
+	}
+}
+
+func mockContentKey(m courier.MsgOut) string {
+	return fmt.Sprintf("%s|%s|%s|%s", m.Channel().UUID(), m.URN().Identity(), m.Text(), strings.Join(m.Attachments(), "|"))
 }
 
 func (mb *MockBackend) OnReceiveComplete(ctx context.Context, ch courier.Channel, events []courier.Event, clog *courier.ChannelLog) {
@@ -212,6 +425,7 @@ func (mb *MockBackend) WriteMsg(ctx context.Context, m courier.MsgIn, clog *cour
 
 	mb.writtenMsgs = append(mb.writtenMsgs, m)
 	mb.lastContactName = mm.contactName
+	mb.lastContactLanguage = mm.contactLanguage
 
 	if mm.urnAuthTokens != nil {
 		mb.recordURNAuthTokens(mm.urn, mm.urnAuthTokens)
@@ -271,6 +485,7 @@ func (mb *MockBackend) WriteChannelEvent(ctx context.Context, event courier.Chan
 
 	mb.writtenChannelEvents = append(mb.writtenChannelEvents, event)
 	mb.lastContactName = evt.contactName
+	mb.lastContactLanguage = evt.contactLanguage
 
 	if evt.urnAuthTokens != nil {
 		mb.recordURNAuthTokens(evt.urn, evt.urnAuthTokens)
@@ -392,6 +607,10 @@ func (mb *MockBackend) WrittenMsgStatuses() []courier.StatusUpdate    { return m
 func (mb *MockBackend) WrittenChannelEvents() []courier.ChannelEvent  { return mb.writtenChannelEvents }
 func (mb *MockBackend) WrittenChannelLogs() []*courier.ChannelLog     { return mb.writtenChannelLogs }
 func (mb *MockBackend) SavedAttachments() []*SavedAttachment          { return mb.savedAttachments }
+func (mb *MockBackend) RequeuedMsgs() []*RequeuedMsg                  { return mb.requeuedMsgs }
+func (mb *MockBackend) ReturnedMsgs() []courier.MsgOut                { return mb.returnedMsgs }
+func (mb *MockBackend) PausedChannels() []*PausedChannel              { return mb.pausedChannels }
+func (mb *MockBackend) OutgoingMsgs() []courier.MsgOut                { return mb.outgoingMsgs }
 func (mb *MockBackend) URNAuthTokens() map[urns.URN]map[string]string { return mb.urnAuthTokens }
 
 // LastContactName returns the contact name set on the last msg or channel event written
@@ -399,6 +618,11 @@ func (mb *MockBackend) LastContactName() string {
 	return mb.lastContactName
 }
 
+// LastContactLanguage returns the contact language set on the last msg or channel event written
+func (mb *MockBackend) LastContactLanguage() i18n.Language {
+	return mb.lastContactLanguage
+}
+
 // MockMedia adds the given media to the mocked backend
 func (mb *MockBackend) MockMedia(media courier.Media) {
 	mb.media[media.URL()] = media
@@ -425,6 +649,10 @@ func (mb *MockBackend) Reset() {
 	mb.writtenMsgStatuses = nil
 	mb.writtenChannelEvents = nil
 	mb.writtenChannelLogs = nil
+	mb.requeuedMsgs = nil
+	mb.returnedMsgs = nil
+	mb.pausedChannels = nil
+	mb.deadLetters = nil
 	mb.urnAuthTokens = nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/i18n"
 	"github.com/nyaruka/gocommon/urns"
 )
 
@@ -15,9 +16,10 @@ type mockChannelEvent struct {
 	createdOn  time.Time
 	occurredOn time.Time
 
-	contactName   string
-	urnAuthTokens map[string]string
-	extra         map[string]string
+	contactName     string
+	contactLanguage i18n.Language
+	urnAuthTokens   map[string]string
+	extra           map[string]string
 }
 
 func (e *mockChannelEvent) EventID() int64                      { return 0 }
@@ -40,6 +42,11 @@ func (e *mockChannelEvent) WithContactName(name string) courier.ChannelEvent {
 	return e
 }
 
+func (e *mockChannelEvent) WithContactLanguage(locale string) courier.ChannelEvent {
+	e.contactLanguage = courier.ParseContactLanguage(locale)
+	return e
+}
+
 func (e *mockChannelEvent) WithURNAuthTokens(tokens map[string]string) courier.ChannelEvent {
 	e.urnAuthTokens = tokens
 	return e
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils/clogs"
@@ -48,7 +50,11 @@ func (h *mockHandler) Initialize(s courier.Server) error {
 func (h *mockHandler) Send(ctx context.Context, msg courier.MsgOut, res *courier.SendResult, clog *courier.ChannelLog) error {
 	// log a request that contains a header value that should be redacted
 	req, _ := httpx.NewRequest(ctx, "GET", "http://mock.com/send", nil, map[string]string{"Authorization": "Token sesame"})
-	trace, err := httpx.DoTrace(http.DefaultClient, req, nil, nil, 1024)
+	client := http.DefaultClient
+	if clog.DryRun() {
+		client = courier.DryRunClient(client)
+	}
+	trace, err := httpx.DoTrace(client, req, nil, nil, 1024)
 	clog.HTTP(trace)
 
 	if err != nil || trace.Response.StatusCode/100 == 5 {
@@ -56,7 +62,8 @@ func (h *mockHandler) Send(ctx context.Context, msg courier.MsgOut, res *courier
 	} else if trace.Response.StatusCode == 403 {
 		return courier.ErrContactStopped
 	} else if trace.Response.StatusCode == 429 {
-		return courier.ErrConnectionThrottled
+		retryAfter, _ := strconv.Atoi(trace.Response.Header.Get("Retry-After"))
+		return courier.ErrRetryAfter(courier.ErrConnectionThrottled, time.Duration(retryAfter)*time.Second)
 	}
 
 	// log an error than contains a value that should be redacted
@@ -64,6 +71,8 @@ func (h *mockHandler) Send(ctx context.Context, msg courier.MsgOut, res *courier
 
 	if msg.Text() == "err:config" {
 		return courier.ErrChannelConfig
+	} else if msg.Text() == "err:response" {
+		return courier.ErrResponseUnparseable
 	}
 
 	return nil
@@ -21,6 +21,7 @@ type MockMsg struct {
 	templating           *courier.Templating
 	externalID           string
 	contactName          string
+	contactLanguage      i18n.Language
 	highPriority         bool
 	quickReplies         []courier.QuickReply
 	origin               courier.MsgOrigin
@@ -28,6 +29,9 @@ type MockMsg struct {
 	responseToExternalID string
 	alreadyWritten       bool
 	isResend             bool
+	retries              int
+	sendAfter            *time.Time
+	expiresOn            *time.Time
 	session              *courier.Session
 
 	flow   *courier.FlowReference
@@ -68,6 +72,9 @@ func (m *MockMsg) ContactLastSeenOn() *time.Time      { return m.contactLastSeen
 func (m *MockMsg) ResponseToExternalID() string       { return m.responseToExternalID }
 func (m *MockMsg) SentOn() *time.Time                 { return m.sentOn }
 func (m *MockMsg) IsResend() bool                     { return m.isResend }
+func (m *MockMsg) Retries() int                       { return m.retries }
+func (m *MockMsg) SendAfter() *time.Time              { return m.sendAfter }
+func (m *MockMsg) ExpiresOn() *time.Time              { return m.expiresOn }
 func (m *MockMsg) Flow() *courier.FlowReference       { return m.flow }
 func (m *MockMsg) OptIn() *courier.OptInReference     { return m.optIn }
 func (m *MockMsg) UserID() courier.UserID             { return m.userID }
@@ -81,6 +88,10 @@ func (m *MockMsg) WithAttachment(url string) courier.MsgIn {
 	return m
 }
 func (m *MockMsg) WithContactName(name string) courier.MsgIn { m.contactName = name; return m }
+func (m *MockMsg) WithContactLanguage(locale string) courier.MsgIn {
+	m.contactLanguage = courier.ParseContactLanguage(locale)
+	return m
+}
 func (m *MockMsg) WithURNAuthTokens(tokens map[string]string) courier.MsgIn {
 	m.urnAuthTokens = tokens
 	return m
@@ -96,3 +107,7 @@ func (m *MockMsg) WithOptIn(o *courier.OptInReference) courier.MsgOut  { m.optIn
 func (m *MockMsg) WithUserID(uid courier.UserID) courier.MsgOut        { m.userID = uid; return m }
 func (m *MockMsg) WithLocale(lc i18n.Locale) courier.MsgOut            { m.locale = lc; return m }
 func (m *MockMsg) WithURNAuth(token string) courier.MsgOut             { m.urnAuth = token; return m }
+func (m *MockMsg) WithRetries(n int) courier.MsgOut                    { m.retries = n; return m }
+func (m *MockMsg) WithHighPriority(hp bool) courier.MsgOut             { m.highPriority = hp; return m }
+func (m *MockMsg) WithSendAfter(t time.Time) courier.MsgOut            { m.sendAfter = &t; return m }
+func (m *MockMsg) WithExpiresOn(t time.Time) courier.MsgOut            { m.expiresOn = &t; return m }
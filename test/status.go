@@ -13,7 +13,9 @@ type MockStatusUpdate struct {
 	oldURN     urns.URN
 	newURN     urns.URN
 	externalID string
+	extraIDs   []string
 	status     courier.MsgStatus
+	reason     courier.MsgFailedReason
 	createdOn  time.Time
 }
 
@@ -33,5 +35,21 @@ func (m *MockStatusUpdate) URNUpdate() (urns.URN, urns.URN) {
 func (m *MockStatusUpdate) ExternalID() string      { return m.externalID }
 func (m *MockStatusUpdate) SetExternalID(id string) { m.externalID = id }
 
+func (m *MockStatusUpdate) ExternalIDs() []string {
+	if m.externalID == "" {
+		return nil
+	}
+	return append([]string{m.externalID}, m.extraIDs...)
+}
+func (m *MockStatusUpdate) SetExternalIDs(ids []string) {
+	m.externalID, m.extraIDs = "", nil
+	if len(ids) > 0 {
+		m.externalID, m.extraIDs = ids[0], ids[1:]
+	}
+}
+
 func (m *MockStatusUpdate) Status() courier.MsgStatus          { return m.status }
 func (m *MockStatusUpdate) SetStatus(status courier.MsgStatus) { m.status = status }
+
+func (m *MockStatusUpdate) FailedReason() courier.MsgFailedReason          { return m.reason }
+func (m *MockStatusUpdate) SetFailedReason(reason courier.MsgFailedReason) { m.reason = reason }
@@ -0,0 +1,86 @@
+// Generates timezones.go from the IANA zone.tab, mapping countries which have a single zone to it. Run with go generate
+// from the root package.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+func main() {
+	zoneTab := flag.String("zonetab", "/usr/share/zoneinfo/zone.tab", "the path of the IANA zone.tab to read")
+	out := flag.String("out", "timezones.go", "the path of the Go file to write")
+	flag.Parse()
+
+	zones, err := readZones(*zoneTab)
+	if err != nil {
+		log.Fatalf("error reading %s: %s", *zoneTab, err)
+	}
+
+	src, err := generate(zones)
+	if err != nil {
+		log.Fatalf("error generating source: %s", err)
+	}
+
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalf("error writing %s: %s", *out, err)
+	}
+}
+
+// reads the zones of each country from the given zone.tab
+func readZones(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zones := make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid line '%s'", line)
+		}
+		zones[fields[0]] = append(zones[fields[0]], strings.TrimSpace(fields[2]))
+	}
+	return zones, scanner.Err()
+}
+
+// generates the source of timezones.go, where countries with a single zone get that as their default timezone, and
+// channels in countries with more than one zone have to have their timezone configured explicitly
+func generate(zones map[string][]string) ([]byte, error) {
+	countries := make([]string, 0, len(zones))
+	for country := range zones {
+		countries = append(countries, country)
+	}
+	slices.Sort(countries)
+
+	b := &bytes.Buffer{}
+	b.WriteString("// Code generated by tools/country_timezones from the IANA zone.tab. DO NOT EDIT.\n\n")
+	b.WriteString("package courier\n\n")
+	b.WriteString("import \"github.com/nyaruka/gocommon/i18n\"\n\n")
+	b.WriteString("// countryTimezones maps countries which have a single timezone to it. Channels in other countries need their\n")
+	b.WriteString("// timezone to be configured explicitly.\n")
+	b.WriteString("var countryTimezones = map[i18n.Country]string{\n")
+	for _, country := range countries {
+		if len(zones[country]) == 1 {
+			fmt.Fprintf(b, "\t%q: %q,\n", country, zones[country][0])
+		}
+	}
+	b.WriteString("}\n")
+
+	return format.Source(b.Bytes())
+}